@@ -48,7 +48,13 @@ func NewAccessibilityNode(node *HtmlNode, parent A11yNode) *AccessibilityNode {
 			a.role = val
 		} else if elt.Tag == "a" {
 			a.role = "link"
-		} else if elt.Tag == "input" {
+		} else if is_checkable(node) {
+			a.role = input_type(node)
+		} else if elt.Tag == "select" {
+			a.role = "combobox"
+		} else if is_input(node, "hidden") {
+			a.role = "none"
+		} else if elt.Tag == "input" || elt.Tag == "textarea" {
 			a.role = "textbox"
 		} else if elt.Tag == "button" {
 			a.role = "button"
@@ -117,7 +123,24 @@ func (a *AccessibilityNode) Build() {
 				value = ""
 			}
 		}
+		if is_input(a.node, "password") {
+			value = strings.Repeat("*", len([]rune(value)))
+		}
 		a.text = "Input box: " + value
+	} else if a.role == "checkbox" || a.role == "radio" {
+		label, state := "Checkbox", "not checked"
+		if a.role == "radio" {
+			label = "Radio button"
+		}
+		if is_checked(a.node) {
+			state = "checked"
+		}
+		a.text = label + ": " + state
+	} else if a.role == "combobox" {
+		a.text = "Select: "
+		if option := selected_option(a.node); option != nil {
+			a.text += option_label(option)
+		}
 	} else if a.role == "button" {
 		a.text = "Button"
 	} else if a.role == "link" {
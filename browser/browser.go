@@ -41,6 +41,7 @@ func init() {
 }
 
 type Browser struct {
+	Fetcher                  url.Fetcher
 	tabs                     []*Tab
 	ActiveTab                *Tab
 	sdl_window               *sdl.Window
@@ -86,6 +87,7 @@ type Browser struct {
 func NewBrowser() *Browser {
 	// browser thread
 	browser := &Browser{
+		Fetcher:               &url.NetworkFetcher{},
 		tabs:                  make([]*Tab, 0),
 		ActiveTab:             nil,
 		needs_animation_frame: false,
@@ -210,14 +212,15 @@ func (b *Browser) new_tab_internal(url *url.URL) {
 	new_tab := NewTab(b, HEIGHT-b.chrome.bottom)
 	b.tabs = append(b.tabs, new_tab)
 	b.set_active_tab(new_tab)
-	b.ScheduleLoad(url, "")
+	b.ScheduleLoad(url, nil)
 }
 
 func (b *Browser) HandleQuit() {
 	b.measure.Finish()
 	for _, tab := range b.tabs {
-		tab.TaskRunner.SetNeedsQuit()
+		tab.Close()
 	}
+	url.CONNECTION_POOL.CloseIdle()
 	b.sdl_window.Destroy()
 }
 
@@ -373,6 +376,20 @@ func (b *Browser) CycleTabs() {
 	b.lock.Unlock()
 }
 
+// CloseTab closes the active tab and reports whether any tabs are left.
+func (b *Browser) CloseTab() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	active_idx := slices.Index(b.tabs, b.ActiveTab)
+	b.ActiveTab.Close()
+	b.tabs = slices.Delete(b.tabs, active_idx, active_idx+1)
+	if len(b.tabs) == 0 {
+		return false
+	}
+	b.set_active_tab(b.tabs[min(active_idx, len(b.tabs)-1)])
+	return true
+}
+
 func (b *Browser) GoBack() {
 	task := task.NewTask(func(i ...interface{}) {
 		b.ActiveTab.go_back()
@@ -381,6 +398,14 @@ func (b *Browser) GoBack() {
 	b.clear_data()
 }
 
+func (b *Browser) GoForward() {
+	task := task.NewTask(func(i ...interface{}) {
+		b.ActiveTab.go_forward()
+	})
+	b.ActiveTab.TaskRunner.ScheduleTask(task)
+	b.clear_data()
+}
+
 func (b *Browser) IncrementZoom(increment bool) {
 	task := task.NewTask(func(i ...interface{}) {
 		b.ActiveTab.ZoomBy(increment)
@@ -528,11 +553,12 @@ func (b *Browser) SetNeedsAnimationFrame(tab *Tab) {
 	b.lock.Unlock()
 }
 
-func (b *Browser) ScheduleLoad(url *url.URL, body string) {
+func (b *Browser) ScheduleLoad(url *url.URL, payload *url.Payload) {
+	b.ActiveTab.CancelLoads()
 	b.ActiveTab.TaskRunner.ClearPendingTasks()
 	task := task.NewTask(func(i ...interface{}) {
-		b.ActiveTab.Load(url, body)
-	}, url, body)
+		b.ActiveTab.Load(url, payload)
+	}, url, payload)
 	b.ActiveTab.TaskRunner.ScheduleTask(task)
 }
 
@@ -22,6 +22,7 @@ type Chrome struct {
 	urlbar_top    float64
 	urlbar_bottom float64
 	back_rect     *rect.Rect
+	forward_rect  *rect.Rect
 	address_rect  *rect.Rect
 	focus         string
 	address_bar   string
@@ -48,8 +49,15 @@ func NewChrome(browser *Browser) *Chrome {
 		chrome.padding+back_width,
 		chrome.urlbar_bottom-chrome.padding,
 	)
+	forward_width := fnt.Measure(chrome.font, ">") + 2*chrome.padding
+	chrome.forward_rect = rect.NewRect(
+		chrome.back_rect.Right+chrome.padding,
+		chrome.urlbar_top+chrome.padding,
+		chrome.back_rect.Right+chrome.padding+forward_width,
+		chrome.urlbar_bottom-chrome.padding,
+	)
 	chrome.address_rect = rect.NewRect(
-		chrome.back_rect.Top+chrome.padding,
+		chrome.forward_rect.Right+chrome.padding,
 		chrome.urlbar_top+chrome.padding,
 		WIDTH-chrome.padding,
 		chrome.urlbar_bottom-chrome.padding,
@@ -102,6 +110,13 @@ func (c *Chrome) paint() []Command {
 		"<", c.font, color,
 	))
 
+	cmds = append(cmds, NewDrawOutline(c.forward_rect, color, 1))
+	cmds = append(cmds, NewDrawText(
+		c.forward_rect.Left+c.padding,
+		c.forward_rect.Top,
+		">", c.font, color,
+	))
+
 	cmds = append(cmds, NewDrawOutline(c.address_rect, color, 1))
 	if c.focus == "address bar" {
 		cmds = append(cmds, NewDrawText(
@@ -146,6 +161,11 @@ func (c *Chrome) click(x, y float64) {
 			c.browser.ActiveTab.go_back()
 		})
 		c.browser.ActiveTab.TaskRunner.ScheduleTask(task)
+	} else if c.forward_rect.ContainsPoint(x, y) {
+		task := task.NewTask(func(i ...interface{}) {
+			c.browser.ActiveTab.go_forward()
+		})
+		c.browser.ActiveTab.TaskRunner.ScheduleTask(task)
 	} else if c.address_rect.ContainsPoint(x, y) {
 		c.focus = "address bar"
 		c.address_bar = ""
@@ -189,7 +209,7 @@ func (c *Chrome) enter() bool {
 			fmt.Println("Creating URL failed: " + err.Error())
 			return false
 		}
-		c.browser.ActiveTab.browser.ScheduleLoad(new_url, "")
+		c.browser.ActiveTab.browser.ScheduleLoad(new_url, nil)
 		c.focus = ""
 		return true
 	}
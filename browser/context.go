@@ -3,7 +3,9 @@ package browser
 import (
 	"fmt"
 	"gowser/task"
+	u "gowser/url"
 	"os"
+	"strings"
 	"time"
 
 	duk "gopkg.in/olebedev/go-duktape.v3"
@@ -79,6 +81,24 @@ func NewJSContext(tab *Tab, origin string) *JSContext {
 	if err != nil {
 		fmt.Println(err)
 	}
+	_, err = js.ctx.PushGlobalGoFunction("_innerHTML_get", func(ctx *duk.Context) int {
+		handle := ctx.GetInt(0)
+		window_id := ctx.GetInt(1)
+		ctx.PushString(js.innerHTML_get(handle, window_id))
+		return 1
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	_, err = js.ctx.PushGlobalGoFunction("_outerHTML_get", func(ctx *duk.Context) int {
+		handle := ctx.GetInt(0)
+		window_id := ctx.GetInt(1)
+		ctx.PushString(js.outerHTML_get(handle, window_id))
+		return 1
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
 	_, err = js.ctx.PushGlobalGoFunction("_style_set", func(ctx *duk.Context) int {
 		handle := ctx.GetInt(0)
 		s := ctx.GetString(1)
@@ -157,6 +177,25 @@ func NewJSContext(tab *Tab, origin string) *JSContext {
 		fmt.Println(err)
 	}
 
+	_, err = js.ctx.PushGlobalGoFunction("_cookie_get", func(ctx *duk.Context) int {
+		window_id := ctx.GetInt(0)
+		ctx.PushString(js.cookie_get(window_id))
+		return 1
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	_, err = js.ctx.PushGlobalGoFunction("_cookie_set", func(ctx *duk.Context) int {
+		window_id := ctx.GetInt(0)
+		cookie := ctx.GetString(1)
+		js.cookie_set(window_id, cookie)
+		return 0
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+
 	err = js.ctx.PevalString("function Window(id) { this._id = id };")
 	if err != nil {
 		fmt.Println(err)
@@ -225,7 +264,7 @@ func (j *JSContext) DispatchEvent(eventType string, elt *HtmlNode, window_id int
 }
 
 func (j *JSContext) dispatch_settimeout(handle int, window_id int) {
-	if j.Discarded {
+	if j.Discarded || j.window_gone(window_id) {
 		return
 	}
 	j.tab.browser.measure.Time("eval_set_timeout")
@@ -278,13 +317,15 @@ func (j *JSContext) setAttribute(handle int, attr, value string, window_id int)
 	j.throw_if_cross_origin(frame)
 	elt := j.handle_to_node[handle]
 	elt.Token.(ElementToken).Attributes[attr] = value
-	obj := elt.LayoutObject
-	_, iframe := obj.Layout.(*IframeLayout)
-	_, image := obj.Layout.(*ImageLayout)
-	if iframe || image {
-		if attr == "width" || attr == "height" {
-			obj.Width.Mark()
-			obj.Height.Mark()
+	// inline scripts run before the document is first laid out
+	if obj := elt.LayoutObject; obj != nil {
+		_, iframe := obj.Layout.(*IframeLayout)
+		_, image := obj.Layout.(*ImageLayout)
+		if iframe || image {
+			if attr == "width" || attr == "height" {
+				obj.Width.Mark()
+				obj.Height.Mark()
+			}
 		}
 	}
 	j.tab.SetNeedsRenderAllFrames()
@@ -295,21 +336,42 @@ func (j *JSContext) innerHTML_set(handle int, s string, window_id int) {
 	j.throw_if_cross_origin(frame)
 	doc := NewHTMLParser("<html><body>" + s + "</body></html>").Parse()
 	new_nodes := doc.Children[0].Children
+	for _, node := range TreeToList(doc) {
+		// scripts inserted with innerHTML never run
+		if is_element(node, "script") {
+			node.ScriptStarted = true
+		}
+	}
 	elt := j.handle_to_node[handle]
+	frame.nodes_removed(elt.Children)
 	elt.Children = new_nodes
 	for _, child := range elt.Children {
 		child.Parent = elt
 	}
-	obj := elt.LayoutObject
-	_, isBlock := obj.Layout.(*BlockLayout)
-	for !isBlock {
-		obj = obj.Parent
-		_, isBlock = obj.Layout.(*BlockLayout)
+	frame.update_rules()
+	frame.nodes_inserted(new_nodes)
+	// elements that aren't rendered, like scripts, have no layout
+	if obj := elt.LayoutObject; obj != nil {
+		_, isBlock := obj.Layout.(*BlockLayout)
+		for !isBlock {
+			obj = obj.Parent
+			_, isBlock = obj.Layout.(*BlockLayout)
+		}
+		obj.Children.Mark()
 	}
-	obj.Children.Mark()
 	frame.SetNeedsRender()
 }
 
+func (j *JSContext) innerHTML_get(handle int, window_id int) string {
+	j.throw_if_cross_origin(j.tab.window_id_to_frame[window_id])
+	return j.handle_to_node[handle].InnerHTML()
+}
+
+func (j *JSContext) outerHTML_get(handle int, window_id int) string {
+	j.throw_if_cross_origin(j.tab.window_id_to_frame[window_id])
+	return j.handle_to_node[handle].OuterHTML()
+}
+
 func (j *JSContext) style_set(handle int, s string, window_id int) {
 	frame := j.tab.window_id_to_frame[window_id]
 	j.throw_if_cross_origin(frame)
@@ -325,25 +387,48 @@ func (j *JSContext) xmlHttpRequest_send(method string, url string, body string,
 		fmt.Println("Request failed: " + err.Error())
 		return ""
 	}
-	if !j.tab.root_frame.allowed_request(full_url) {
+	if !j.tab.root_frame.allowed_request("connect-src", full_url) {
 		fmt.Println("Cross-origin XHR blocked by CSP")
 		return ""
 	}
-	if full_url.Origin() != j.tab.url.Origin() {
+	if !same_origin(j.origin, full_url) {
 		fmt.Println("Cross-origin XHR request not allowed")
 		return ""
 	}
+	frame := j.tab.window_id_to_frame[window_id]
+	if frame == nil {
+		return ""
+	}
+	// redirects must stay within the origin and the CSP too
+	csp_check := j.tab.root_frame.redirect_check("connect-src", "")
+	origin := j.origin
+	ctx := u.WithRedirectCheck(frame.ctx, func(url *u.URL) error {
+		if !same_origin(origin, url) {
+			return fmt.Errorf("cross-origin redirect to %s not allowed", url)
+		}
+		return csp_check(url)
+	})
+	// GET and HEAD requests have no body
+	var payload *u.Payload
+	if method = strings.ToUpper(method); method != "GET" {
+		payload = &u.Payload{Method: method}
+		if method != "HEAD" {
+			payload.ContentType, payload.Body = "text/plain;charset=UTF-8", body
+		}
+	}
 	run_load := func() string {
-		_, response, err := full_url.Request(j.tab.url, body)
+		response, err := j.tab.browser.Fetcher.Fetch(ctx, full_url, j.tab.url, payload)
 		if err != nil {
 			fmt.Println("Request failed: " + err.Error())
 			return ""
 		}
+		// responseText is UTF-8 unless the response says otherwise
+		text := decode_subresource(response, "utf-8", false)
 		task := task.NewTask(func(i ...interface{}) {
-			j.dispatch_xhr_onload(string(response), handle, window_id)
+			j.dispatch_xhr_onload(text, handle, window_id)
 		}, response, handle)
 		j.tab.TaskRunner.ScheduleTask(task)
-		return string(response)
+		return text
 	}
 	if !is_async {
 		return run_load()
@@ -353,8 +438,24 @@ func (j *JSContext) xmlHttpRequest_send(method string, url string, body string,
 	}
 }
 
+func (j *JSContext) cookie_get(window_id int) string {
+	frame := j.tab.window_id_to_frame[window_id]
+	if frame == nil {
+		return ""
+	}
+	return u.COOKIE_JAR.ScriptCookies(frame.url)
+}
+
+func (j *JSContext) cookie_set(window_id int, cookie string) {
+	frame := j.tab.window_id_to_frame[window_id]
+	if frame == nil {
+		return
+	}
+	u.COOKIE_JAR.SetCookie(frame.url, cookie, true)
+}
+
 func (j *JSContext) dispatch_xhr_onload(out string, handle int, window_id int) {
-	if j.Discarded {
+	if j.Discarded || j.window_gone(window_id) {
 		return
 	}
 	j.tab.browser.measure.Time("eval_dispatch_xhr_onload")
@@ -381,15 +482,29 @@ func (j *JSContext) DispatchRAF(window_id int) {
 }
 
 func (j *JSContext) parent(window_id int) int {
-	parent_frame := j.tab.window_id_to_frame[window_id].parent_frame
+	frame := j.tab.window_id_to_frame[window_id]
+	if frame == nil {
+		return -1
+	}
+	parent_frame := frame.parent_frame
 	if parent_frame == nil {
 		return -1
 	}
 	return parent_frame.window_id
 }
 
+// window_gone is true for the window of an iframe that was removed, its
+// timers and event handlers don't run anymore.
+func (j *JSContext) window_gone(window_id int) bool {
+	_, ok := j.tab.window_id_to_frame[window_id]
+	return !ok
+}
+
 func (j *JSContext) throw_if_cross_origin(frame *Frame) {
-	if frame.url.Origin() != j.origin {
+	if frame == nil {
+		panic("Window of a removed frame accessed from script")
+	}
+	if frame.origin != j.origin {
 		panic("Cross-origin access disallowed from script")
 	}
 }
@@ -402,5 +517,8 @@ func (j *JSContext) postMessage(target_window_id int, message, origin string) {
 }
 
 func (j *JSContext) dispatch_post_message(message string, window_id int) {
+	if j.window_gone(window_id) {
+		return
+	}
 	j.ctx.EvalString(j.wrap(fmt.Sprintf("window.dispatchEvent(new window.MessageEvent(%s))", message), window_id))
 }
@@ -0,0 +1,126 @@
+package browser
+
+import (
+	"fmt"
+	u "gowser/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// counts the opaque origins handed out, see document_origin
+	OPAQUE_ORIGINS atomic.Int64
+)
+
+// document_origin is the origin of a document loaded from url. Every
+// document with an opaque origin, like one from a data: URL, gets one of
+// its own, which equals no other and no URL's origin.
+func document_origin(url *u.URL) string {
+	if url.HasOpaqueOrigin() {
+		return fmt.Sprintf("null#%d", OPAQUE_ORIGINS.Add(1))
+	}
+	return url.Origin()
+}
+
+// same_origin tells whether url has the given origin. An opaque origin
+// is never the same as another.
+func same_origin(origin string, url *u.URL) bool {
+	return !url.HasOpaqueOrigin() && url.Origin() == origin
+}
+
+// A ContentSecurityPolicy restricts what a document loads and runs. It
+// supports the fetch directives like script-src with origins, schemes
+// like data:, 'self', 'none', 'unsafe-inline' and nonces.
+type ContentSecurityPolicy struct {
+	origin     string
+	directives map[string][]string
+}
+
+// NewContentSecurityPolicy parses the Content-Security-Policy header of a
+// document with the given origin. It returns nil for a missing header,
+// which allows everything.
+func NewContentSecurityPolicy(header string, origin string) *ContentSecurityPolicy {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+	csp := &ContentSecurityPolicy{origin: origin, directives: map[string][]string{}}
+	for _, directive := range strings.Split(header, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if _, ok := csp.directives[name]; ok {
+			// only the first one counts
+			continue
+		}
+		sources := []string{}
+		for _, source := range fields[1:] {
+			if strings.HasPrefix(source, "'") {
+				// keywords are case-insensitive, nonces are not
+				if !strings.HasPrefix(source, "'nonce-") {
+					source = strings.ToLower(source)
+				}
+			} else if !strings.HasSuffix(source, ":") && source != "*" {
+				new_url, err := u.NewURL(source)
+				if err != nil {
+					fmt.Println("Invalid URL: " + err.Error())
+					continue
+				}
+				source = new_url.Origin()
+			}
+			sources = append(sources, source)
+		}
+		csp.directives[name] = sources
+	}
+	return csp
+}
+
+// sources returns the sources of a directive like script-src, or of
+// default-src if it is not given.
+func (c *ContentSecurityPolicy) sources(directive string) ([]string, bool) {
+	if sources, ok := c.directives[directive]; ok {
+		return sources, true
+	}
+	sources, ok := c.directives["default-src"]
+	return sources, ok
+}
+
+// allows_nonce is true if the element has a nonce the directive lists.
+func (c *ContentSecurityPolicy) allows_nonce(directive string, nonce string) bool {
+	sources, _ := c.sources(directive)
+	return nonce != "" && slices.Contains(sources, "'nonce-"+nonce+"'")
+}
+
+// allows_url checks a request made for directive, an element with a
+// matching nonce may load from anywhere.
+func (c *ContentSecurityPolicy) allows_url(directive string, url *u.URL, nonce string) bool {
+	if c == nil {
+		return true
+	}
+	sources, ok := c.sources(directive)
+	if !ok || c.allows_nonce(directive, nonce) {
+		return true
+	}
+	return slices.Contains(sources, "*") || slices.ContainsFunc(sources, func(source string) bool {
+		return same_origin(source, url)
+	}) || slices.Contains(sources, url.Scheme()+":") ||
+		slices.Contains(sources, "'self'") && same_origin(c.origin, url)
+}
+
+// allows_inline checks an inline script or style. 'unsafe-inline' is
+// ignored when the directive lists nonces.
+func (c *ContentSecurityPolicy) allows_inline(directive string, nonce string) bool {
+	if c == nil {
+		return true
+	}
+	sources, ok := c.sources(directive)
+	if !ok || c.allows_nonce(directive, nonce) {
+		return true
+	}
+	has_nonces := slices.ContainsFunc(sources, func(source string) bool {
+		return strings.HasPrefix(source, "'nonce-")
+	})
+	return slices.Contains(sources, "'unsafe-inline'") && !has_nonces
+}
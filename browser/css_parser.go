@@ -3,101 +3,431 @@ package browser
 import (
 	"cmp"
 	"gowser/try"
-	"slices"
 	"strconv"
 	"strings"
-	"unicode"
 )
 
+// The parser follows the parsing section of CSS Syntax Level 3,
+// https://www.w3.org/TR/css-syntax-3/#parsing. The input is split into
+// component values first, rules and declarations are then read from
+// those. The block of an at-rule is kept as it is until it is known
+// whether it holds rules or declarations.
+
+var (
+	// the token that ends a function or a block opened by a token
+	CSS_BLOCK_ENDINGS = map[css_token_kind]css_token_kind{
+		CSS_FUNCTION_TOKEN:    CSS_CLOSE_PAREN_TOKEN,
+		CSS_OPEN_PAREN_TOKEN:  CSS_CLOSE_PAREN_TOKEN,
+		CSS_OPEN_SQUARE_TOKEN: CSS_CLOSE_SQUARE_TOKEN,
+		CSS_OPEN_CURLY_TOKEN:  CSS_CLOSE_CURLY_TOKEN,
+	}
+	CSS_CLOSING_TEXT = map[css_token_kind]string{
+		CSS_CLOSE_PAREN_TOKEN:  ")",
+		CSS_CLOSE_SQUARE_TOKEN: "]",
+		CSS_CLOSE_CURLY_TOKEN:  "}",
+	}
+)
+
+// A ComponentValue is a preserved token, a function or a simple block.
+// Functions and blocks keep the token that opened them and the values
+// inside.
+type ComponentValue struct {
+	Token  css_token
+	Values []ComponentValue
+}
+
+// String serializes v as it was written, with comments left out and
+// whitespace collapsed.
+func (v ComponentValue) String() string {
+	if v.Token.kind == CSS_WHITESPACE_TOKEN {
+		return " "
+	}
+	ending, ok := CSS_BLOCK_ENDINGS[v.Token.kind]
+	if !ok {
+		return v.Token.source
+	}
+	return v.Token.source + serialize_values(v.Values) + CSS_CLOSING_TEXT[ending]
+}
+
+func serialize_values(values []ComponentValue) string {
+	out := strings.Builder{}
+	for _, value := range values {
+		out.WriteString(value.String())
+	}
+	return out.String()
+}
+
+// trim_whitespace drops the whitespace at both ends of values.
+func trim_whitespace(values []ComponentValue) []ComponentValue {
+	for len(values) > 0 && values[0].Token.kind == CSS_WHITESPACE_TOKEN {
+		values = values[1:]
+	}
+	for len(values) > 0 && values[len(values)-1].Token.kind == CSS_WHITESPACE_TOKEN {
+		values = values[:len(values)-1]
+	}
+	return values
+}
+
+// A CSSRule is a QualifiedRule or an AtRule.
+type CSSRule interface {
+	css_rule()
+}
+
+// A QualifiedRule has a prelude, the selector of a style rule, and a {}
+// block.
+type QualifiedRule struct {
+	Prelude []ComponentValue
+	Block   ComponentValue
+}
+
+func (r *QualifiedRule) css_rule() {}
+
+// Declarations parses the block of the rule, as the block of a style
+// rule holds declarations.
+func (r *QualifiedRule) Declarations() []Declaration {
+	return (&CSSParser{values: r.Block.Values}).ParseDeclarations()
+}
+
+// An AtRule is a rule like @media or @import. Its Block is nil for rules
+// ending with a semicolon instead.
+type AtRule struct {
+	Name    string
+	Prelude []ComponentValue
+	Block   *ComponentValue
+}
+
+func (r *AtRule) css_rule() {}
+
+// Rules parses the block of an at-rule holding rules, like @media,
+// @supports or @keyframes.
+func (r *AtRule) Rules() []CSSRule {
+	if r.Block == nil {
+		return []CSSRule{}
+	}
+	return (&CSSParser{values: r.Block.Values}).consume_rules(false)
+}
+
+// Declarations parses the block of an at-rule holding declarations, like
+// @font-face.
+func (r *AtRule) Declarations() []Declaration {
+	if r.Block == nil {
+		return []Declaration{}
+	}
+	return (&CSSParser{values: r.Block.Values}).ParseDeclarations()
+}
+
+type Declaration struct {
+	Name      string
+	Value     []ComponentValue
+	Important bool
+}
+
 type CSSParser struct {
-	style string
-	i     int
+	values []ComponentValue
+	i      int
 }
 
 func NewCSSParser(style string) *CSSParser {
-	return &CSSParser{
-		style: style,
-		i:     0,
+	tokenizer := NewCSSTokenizer(style)
+	values := []ComponentValue{}
+	for token := tokenizer.next(); token.kind != CSS_EOF_TOKEN; token = tokenizer.next() {
+		values = append(values, consume_component_value(token, tokenizer))
 	}
+	return &CSSParser{values: values}
+}
+
+// consume_component_value reads the function or block token opens, and
+// returns other tokens as they are. A missing end is a parse error, the
+// end of the input closes it.
+func consume_component_value(token css_token, tokenizer *CSSTokenizer) ComponentValue {
+	ending, ok := CSS_BLOCK_ENDINGS[token.kind]
+	if !ok {
+		return ComponentValue{Token: token}
+	}
+	value := ComponentValue{Token: token, Values: []ComponentValue{}}
+	for next := tokenizer.next(); next.kind != ending && next.kind != CSS_EOF_TOKEN; next = tokenizer.next() {
+		value.Values = append(value.Values, consume_component_value(next, tokenizer))
+	}
+	return value
+}
+
+// next consumes a component value, an EOF token at the end.
+func (p *CSSParser) next() ComponentValue {
+	value := p.peek()
+	if p.i < len(p.values) {
+		p.i++
+	}
+	return value
+}
+
+func (p *CSSParser) peek() ComponentValue {
+	if p.i < len(p.values) {
+		return p.values[p.i]
+	}
+	return ComponentValue{Token: css_token{kind: CSS_EOF_TOKEN}}
+}
+
+func (p *CSSParser) reconsume() {
+	p.i--
 }
 
 func (p *CSSParser) whitespace() {
-	for p.i < len(p.style) && unicode.IsSpace(rune(p.style[p.i])) {
+	for p.peek().Token.kind == CSS_WHITESPACE_TOKEN {
 		p.i++
 	}
 }
 
-func (p *CSSParser) word() string {
-	start := p.i
-	for p.i < len(p.style) {
-		if unicode.IsLetter(rune(p.style[p.i])) || unicode.IsDigit(rune(p.style[p.i])) || slices.Contains([]rune{'#', '-', '.', '%'}, rune(p.style[p.i])) {
-			p.i++
-		} else {
-			break
+// ParseStyleSheet parses the rules of a style sheet.
+func (p *CSSParser) ParseStyleSheet() []CSSRule {
+	return p.consume_rules(true)
+}
+
+// consume_rules reads a list of rules. Only at the top level of a style
+// sheet are the <!-- and --> of old pages skipped.
+func (p *CSSParser) consume_rules(top_level bool) []CSSRule {
+	rules := []CSSRule{}
+	for {
+		value := p.next()
+		switch value.Token.kind {
+		case CSS_WHITESPACE_TOKEN:
+		case CSS_EOF_TOKEN:
+			return rules
+		case CSS_AT_KEYWORD_TOKEN:
+			rules = append(rules, p.consume_at_rule(value))
+		case CSS_CDO_TOKEN, CSS_CDC_TOKEN:
+			if top_level {
+				continue
+			}
+			fallthrough
+		default:
+			p.reconsume()
+			if rule := p.consume_qualified_rule(); rule != nil {
+				rules = append(rules, rule)
+			}
+		}
+	}
+}
+
+func (p *CSSParser) consume_at_rule(keyword ComponentValue) *AtRule {
+	rule := &AtRule{Name: keyword.Token.value, Prelude: []ComponentValue{}}
+	for {
+		value := p.next()
+		switch value.Token.kind {
+		case CSS_SEMICOLON_TOKEN, CSS_EOF_TOKEN:
+			return rule
+		case CSS_OPEN_CURLY_TOKEN:
+			rule.Block = &value
+			return rule
 		}
+		rule.Prelude = append(rule.Prelude, value)
 	}
-	if !(p.i > start) {
-		panic("Expected a word at position " + strconv.Itoa(p.i))
+}
+
+// consume_qualified_rule returns nil for a rule the input ends in before
+// its block, which is dropped.
+func (p *CSSParser) consume_qualified_rule() *QualifiedRule {
+	prelude := []ComponentValue{}
+	for {
+		value := p.next()
+		switch value.Token.kind {
+		case CSS_EOF_TOKEN:
+			return nil
+		case CSS_OPEN_CURLY_TOKEN:
+			return &QualifiedRule{Prelude: prelude, Block: value}
+		}
+		prelude = append(prelude, value)
 	}
-	return p.style[start:p.i]
 }
 
-func (p *CSSParser) literal(literal rune) {
-	if !(p.i < len(p.style) && rune(p.style[p.i]) == literal) {
-		panic("Expected literal '" + string(literal) + "' at position " + strconv.Itoa(p.i))
+// ParseDeclarations parses a list of declarations, the way the style
+// attribute and the blocks of style rules are read. Declarations that
+// are broken are skipped up to the next semicolon. No at-rules are
+// allowed among declarations yet, they are skipped too.
+func (p *CSSParser) ParseDeclarations() []Declaration {
+	declarations := []Declaration{}
+	for {
+		value := p.next()
+		switch value.Token.kind {
+		case CSS_WHITESPACE_TOKEN, CSS_SEMICOLON_TOKEN:
+		case CSS_EOF_TOKEN:
+			return declarations
+		case CSS_AT_KEYWORD_TOKEN:
+			p.consume_at_rule(value)
+		case CSS_IDENT_TOKEN:
+			values := []ComponentValue{value}
+			for p.peek().Token.kind != CSS_SEMICOLON_TOKEN && p.peek().Token.kind != CSS_EOF_TOKEN {
+				values = append(values, p.next())
+			}
+			if declaration := consume_declaration(values); declaration != nil {
+				declarations = append(declarations, *declaration)
+			}
+		default:
+			for p.peek().Token.kind != CSS_SEMICOLON_TOKEN && p.peek().Token.kind != CSS_EOF_TOKEN {
+				p.next()
+			}
+		}
 	}
-	p.i++
 }
 
-func (p *CSSParser) pair(until ...rune) (string, string) {
-	prop := p.word()
-	p.whitespace()
-	p.literal(':')
-	p.whitespace()
-	val := p.until_chars(until...)
-	return strings.ToLower(prop), strings.TrimSpace(val)
+// consume_declaration reads a declaration from its name up to the
+// semicolon, nil if it has no colon after the name.
+func consume_declaration(values []ComponentValue) *Declaration {
+	declaration := &Declaration{Name: values[0].Token.value}
+	values = trim_whitespace(values[1:])
+	if len(values) == 0 || values[0].Token.kind != CSS_COLON_TOKEN {
+		return nil
+	}
+	values = trim_whitespace(values[1:])
+	if last := len(values) - 1; last >= 0 && values[last].Token.kind == CSS_IDENT_TOKEN &&
+		strings.EqualFold(values[last].Token.value, "important") {
+		rest := trim_whitespace(values[:last])
+		if bang := len(rest) - 1; bang >= 0 && rest[bang].Token.kind == CSS_DELIM_TOKEN && rest[bang].Token.value == "!" {
+			declaration.Important = true
+			values = trim_whitespace(rest[:bang])
+		}
+	}
+	declaration.Value = values
+	return declaration
 }
 
+// Body parses declarations into the values of the properties they set.
+// Declarations with values no property takes are left out.
 func (p *CSSParser) Body() map[string]string {
+	return declaration_map(p.ParseDeclarations())
+}
+
+func declaration_map(declarations []Declaration) map[string]string {
 	pairs := make(map[string]string)
-	for p.i < len(p.style) && p.style[p.i] != '}' {
-		err := try.Try(func() {
-			prop, val := p.pair(';', '}')
-			pairs[strings.ToLower(prop)] = val
-			p.whitespace()
-			p.literal(';')
-			p.whitespace()
-		})
-		if err != nil {
-			why := p.ignore_until(';', '}')
-			if why == ';' {
-				p.literal(';')
-				p.whitespace()
-			} else {
-				break
-			}
+	for _, declaration := range declarations {
+		if is_valid_value(declaration.Value) {
+			pairs[strings.ToLower(declaration.Name)] = serialize_values(declaration.Value)
 		}
 	}
 	return pairs
 }
 
-func (p *CSSParser) ignore_until(chars ...rune) rune {
-	for p.i < len(p.style) {
-		if slices.Contains(chars, rune(p.style[p.i])) {
-			return rune(p.style[p.i])
-		} else {
-			p.i++
+// is_valid_value is false for empty values and those with broken
+// strings or urls, or brackets that close nothing.
+func is_valid_value(values []ComponentValue) bool {
+	if len(values) == 0 {
+		return false
+	}
+	for _, value := range values {
+		switch value.Token.kind {
+		case CSS_BAD_STRING_TOKEN, CSS_BAD_URL_TOKEN, CSS_CLOSE_PAREN_TOKEN, CSS_CLOSE_SQUARE_TOKEN, CSS_CLOSE_CURLY_TOKEN:
+			return false
+		}
+		if len(value.Values) > 0 && !is_valid_value(value.Values) {
+			return false
 		}
 	}
-	return 0
+	return true
 }
 
-func (p *CSSParser) until_chars(chars ...rune) string {
-	start := p.i
-	for p.i < len(p.style) && !slices.Contains(chars, rune(p.style[p.i])) {
-		p.i++
+// Parse parses a style sheet into the style rules it has, with the
+// color scheme of the @media rule they are in. Rules with selectors that
+// aren't supported are dropped, and so are other at-rules, whose rules
+// ParseStyleSheet returns.
+func (p *CSSParser) Parse() []Rule {
+	return style_rules(p.ParseStyleSheet(), "")
+}
+
+func style_rules(css_rules []CSSRule, media string) []Rule {
+	rules := make([]Rule, 0)
+	for _, css_rule := range css_rules {
+		switch rule := css_rule.(type) {
+		case *QualifiedRule:
+			selector, ok := parse_selector(rule.Prelude)
+			if ok {
+				rules = append(rules, *NewRule(media, selector, declaration_map(rule.Declarations())))
+			}
+		case *AtRule:
+			if !strings.EqualFold(rule.Name, "media") {
+				continue
+			}
+			if rule_media, applies := media_of(rule.Prelude); applies {
+				rules = append(rules, style_rules(rule.Rules(), cmp.Or(rule_media, media))...)
+			}
+		}
 	}
-	return p.style[start:p.i]
+	return rules
+}
+
+// media_of evaluates the query of an @media rule. Of the media features
+// only prefers-color-scheme is known, the rules of other queries apply
+// always, unless they are for print.
+func media_of(prelude []ComponentValue) (string, bool) {
+	media := ""
+	for _, value := range prelude {
+		if value.Token.kind == CSS_IDENT_TOKEN && strings.EqualFold(value.Token.value, "print") {
+			return "", false
+		} else if value.Token.kind != CSS_OPEN_PAREN_TOKEN {
+			continue
+		}
+		feature := trim_whitespace(value.Values)
+		if len(feature) < 3 || feature[0].Token.kind != CSS_IDENT_TOKEN || !strings.EqualFold(feature[0].Token.value, "prefers-color-scheme") {
+			continue
+		}
+		rest := trim_whitespace(feature[1:])
+		if len(rest) == 0 || rest[0].Token.kind != CSS_COLON_TOKEN {
+			continue
+		}
+		rest = trim_whitespace(rest[1:])
+		if len(rest) == 1 && rest[0].Token.kind == CSS_IDENT_TOKEN {
+			if scheme := strings.ToLower(rest[0].Token.value); scheme == "dark" || scheme == "light" {
+				media = scheme
+			}
+		}
+	}
+	return media, true
+}
+
+// parse_selector reads the prelude of a style rule, false if it isn't a
+// supported selector.
+func parse_selector(prelude []ComponentValue) (selector Selector, ok bool) {
+	err := try.Try(func() {
+		selector = (&CSSParser{values: prelude}).Selector()
+	})
+	return selector, err == nil
+}
+
+func (p *CSSParser) simple_selector() Selector {
+	var out Selector
+	value := p.next()
+	if value.Token.kind == CSS_DELIM_TOKEN && value.Token.value == "." && p.peek().Token.kind == CSS_IDENT_TOKEN {
+		out = NewClassSelector("." + p.next().Token.value)
+	} else if value.Token.kind == CSS_IDENT_TOKEN {
+		out = NewTagSelector(strings.ToLower(value.Token.value))
+	} else {
+		panic("Expected a selector at '" + value.String() + "'")
+	}
+	if p.peek().Token.kind == CSS_COLON_TOKEN {
+		p.next()
+		pseudoclass := p.next()
+		if pseudoclass.Token.kind != CSS_IDENT_TOKEN {
+			panic("Expected a pseudo-class at '" + pseudoclass.String() + "'")
+		}
+		out = NewPseudoclassSelector(strings.ToLower(pseudoclass.Token.value), out)
+	}
+	return out
+}
+
+// Selector parses the rest of the input as a selector, and panics if it
+// isn't one.
+func (p *CSSParser) Selector() Selector {
+	p.whitespace()
+	out := p.simple_selector()
+	for p.peek().Token.kind != CSS_EOF_TOKEN {
+		if value := p.next(); value.Token.kind != CSS_WHITESPACE_TOKEN {
+			panic("Unsupported selector at '" + value.String() + "'")
+		}
+		p.whitespace()
+		if p.peek().Token.kind == CSS_EOF_TOKEN {
+			break
+		}
+		out = NewDescendantSelector(out, p.simple_selector())
+	}
+	return out
 }
 
 func ParseTransition(value string) map[string]int {
@@ -151,85 +481,3 @@ func ParseOutline(outline_str string) (int, string) {
 	}
 	return iVal, values[2]
 }
-
-func (p *CSSParser) simple_selector() Selector {
-	var out Selector
-	word := strings.ToLower(p.word())
-	if word[0] == '.' {
-		out = NewClassSelector(word)
-	} else {
-		out = NewTagSelector(word)
-	}
-	if p.i < len(p.style) && p.style[p.i] == ':' {
-		p.literal(':')
-		pseudoclass := strings.ToLower(p.word())
-		out = NewPseudoclassSelector(pseudoclass, out)
-	}
-	return out
-}
-
-func (p *CSSParser) Selector() Selector {
-	out := p.simple_selector()
-	p.whitespace()
-	for p.i < len(p.style) && p.style[p.i] != '{' {
-		descendant := p.simple_selector()
-		out = NewDescendantSelector(out, descendant)
-		p.whitespace()
-	}
-	return out
-}
-
-func (p *CSSParser) Parse() []Rule {
-	rules := make([]Rule, 0)
-	var media string
-	for p.i < len(p.style) {
-		err := try.Try(func() {
-			p.whitespace()
-			if p.style[p.i] == '@' && media == "" {
-				prop, val := p.media_query()
-				if prop == "prefers-color-scheme" && slices.Contains([]string{"dark", "light"}, val) {
-					media = val
-				}
-				p.whitespace()
-				p.literal('{')
-				p.whitespace()
-			} else if p.style[p.i] == '}' && media != "" {
-				p.literal('}')
-				media = ""
-				p.whitespace()
-			} else {
-				selector := p.Selector()
-				p.literal('{')
-				p.whitespace()
-				body := p.Body()
-				p.literal('}')
-				rules = append(rules, *NewRule(media, selector, body))
-			}
-		})
-		if err != nil {
-			why := p.ignore_until('}')
-			if why == '}' {
-				p.literal('}')
-				p.whitespace()
-			} else {
-				break
-			}
-		}
-	}
-	return rules
-}
-
-func (p *CSSParser) media_query() (string, string) {
-	p.literal('@')
-	word := p.word()
-	if word != "media" {
-		panic("unsupported media query: " + word)
-	}
-	p.whitespace()
-	p.literal('(')
-	p.whitespace()
-	prop, val := p.pair(')')
-	p.whitespace()
-	p.literal(')')
-	return prop, val
-}
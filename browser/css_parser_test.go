@@ -1,6 +1,8 @@
 package browser
 
 import (
+	"fmt"
+	"slices"
 	"testing"
 )
 
@@ -58,4 +60,110 @@ func TestSimpleSelector(t *testing.T) {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestCSSParserBody(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`color: red; font-size:16px`, `map[color:red font-size:16px]`},
+		{`COLOR : Red ;; width: 1px !important`, `map[color:Red width:1px]`},
+		{`font-family: "a;b" , 'c}' ; x`, `map[font-family:"a;b" , 'c}']`},
+		{`a: b(c; d); e: f`, `map[a:b(c; d) e:f]`},
+		{`color: ; 5px: x; {a: b}; transform: translate( 1px,2px ) /* c */`, `map[transform:translate( 1px,2px )]`},
+		{`@x y; background: url(a.png) no-repeat`, `map[background:url(a.png) no-repeat]`},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprint(NewCSSParser(test.input).Body()); got != test.want {
+			t.Errorf("Parsing %q gave %s, want %s", test.input, got, test.want)
+		}
+	}
+
+	declarations := NewCSSParser(`a: 1 ! IMPORTANT; b: 2`).ParseDeclarations()
+	if len(declarations) != 2 || !declarations[0].Important || declarations[1].Important {
+		t.Errorf("Expected only the first declaration to be important, got %v", declarations)
+	}
+}
+
+func TestCSSParserRules(t *testing.T) {
+	style := `
+<!-- p { color: red } -->
+div p, span { color: blue }
+#id { color: green }
+a:focus { outline: 1px solid black }
+@import url(x.css);
+@media (prefers-color-scheme: dark) {
+  p { color: white }
+  @media screen { b { color: gray } }
+}
+@media print { p { color: black } }
+@supports (display: grid) { p { display: grid } }
+.a { color: "unclosed
+}
+.b { color: yellow }
+i {`
+	got := []string{}
+	for _, rule := range NewCSSParser(style).Parse() {
+		got = append(got, fmt.Sprint(rule.Media, "|", selectorString(rule.Selector), "|", rule.Body))
+	}
+	want := []string{"|p|map[color:red]", "|a:focus|map[outline:1px solid black]", "dark|p|map[color:white]",
+		"dark|b|map[color:gray]", "|.a|map[]", "|.b|map[color:yellow]", "|i|map[]"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected rules %q, got %q", want, got)
+	}
+}
+
+func TestCSSParserAtRules(t *testing.T) {
+	style := `@import "a.css" screen;
+@font-face { font-family: X; src: url(x.woff) }
+@keyframes spin { from { opacity: 0 } 50% { opacity: 1 } }
+@supports not (display: grid) { p { color: red } }`
+	rules := NewCSSParser(style).ParseStyleSheet()
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 at-rules, got %d", len(rules))
+	}
+	names := []string{}
+	for _, rule := range rules {
+		names = append(names, rule.(*AtRule).Name)
+	}
+	if !slices.Equal(names, []string{"import", "font-face", "keyframes", "supports"}) {
+		t.Errorf("Unexpected at-rules %q", names)
+	}
+
+	import_rule := rules[0].(*AtRule)
+	if import_rule.Block != nil || serialize_values(trim_whitespace(import_rule.Prelude)) != `"a.css" screen` {
+		t.Errorf("Unexpected @import prelude %q", serialize_values(import_rule.Prelude))
+	}
+	font_face := declaration_map(rules[1].(*AtRule).Declarations())
+	if fmt.Sprint(font_face) != "map[font-family:X src:url(x.woff)]" {
+		t.Errorf("Unexpected @font-face declarations %v", font_face)
+	}
+	keyframes := []string{}
+	for _, rule := range rules[2].(*AtRule).Rules() {
+		keyframe := rule.(*QualifiedRule)
+		keyframes = append(keyframes, serialize_values(trim_whitespace(keyframe.Prelude))+" "+fmt.Sprint(declaration_map(keyframe.Declarations())))
+	}
+	if !slices.Equal(keyframes, []string{"from map[opacity:0]", "50% map[opacity:1]"}) {
+		t.Errorf("Unexpected keyframes %q", keyframes)
+	}
+	supports := rules[3].(*AtRule)
+	if serialize_values(trim_whitespace(supports.Prelude)) != "not (display: grid)" || len(supports.Rules()) != 1 {
+		t.Errorf("Unexpected @supports rule %s with %d rules", serialize_values(supports.Prelude), len(supports.Rules()))
+	}
+}
+
+// selectorString writes a selector the way it is written in CSS.
+func selectorString(selector Selector) string {
+	switch selector := selector.(type) {
+	case *TagSelector:
+		return selector.Tag
+	case *ClassSelector:
+		return selector.Class
+	case *DescendantSelector:
+		return selectorString(selector.Ancestor) + " " + selectorString(selector.Descendant)
+	case *PseudoclassSelector:
+		return selectorString(selector.base) + ":" + selector.pseudoclass
+	}
+	return "?"
+}
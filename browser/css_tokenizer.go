@@ -0,0 +1,401 @@
+package browser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The tokenizer follows the tokenization section of CSS Syntax Level 3,
+// https://www.w3.org/TR/css-syntax-3/#tokenization. Parse errors are not
+// reported, the spec says how to recover from each of them.
+
+type css_token_kind int
+
+const (
+	CSS_IDENT_TOKEN css_token_kind = iota
+	CSS_FUNCTION_TOKEN
+	CSS_AT_KEYWORD_TOKEN
+	CSS_HASH_TOKEN
+	CSS_STRING_TOKEN
+	CSS_BAD_STRING_TOKEN
+	CSS_URL_TOKEN
+	CSS_BAD_URL_TOKEN
+	CSS_DELIM_TOKEN
+	CSS_NUMBER_TOKEN
+	CSS_PERCENTAGE_TOKEN
+	CSS_DIMENSION_TOKEN
+	CSS_WHITESPACE_TOKEN
+	CSS_CDO_TOKEN
+	CSS_CDC_TOKEN
+	CSS_COLON_TOKEN
+	CSS_SEMICOLON_TOKEN
+	CSS_COMMA_TOKEN
+	CSS_OPEN_SQUARE_TOKEN
+	CSS_CLOSE_SQUARE_TOKEN
+	CSS_OPEN_PAREN_TOKEN
+	CSS_CLOSE_PAREN_TOKEN
+	CSS_OPEN_CURLY_TOKEN
+	CSS_CLOSE_CURLY_TOKEN
+	CSS_EOF_TOKEN
+)
+
+type css_token struct {
+	kind css_token_kind
+	// the name of an ident, function, at-keyword or hash, the text of a
+	// string or url, the character of a delim
+	value      string
+	number     float64 // of numbers, percentages and dimensions
+	is_integer bool
+	unit       string // of a dimension
+	is_id      bool   // a hash that is a valid ID selector
+	// the source text of the token, to serialize values as they were
+	// written
+	source string
+}
+
+type CSSTokenizer struct {
+	input []rune
+	pos   int
+}
+
+func NewCSSTokenizer(input string) *CSSTokenizer {
+	// preprocessing: newlines are normalized and NULs replaced
+	input = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\f", "\n", "\x00", "\uFFFD").Replace(input)
+	return &CSSTokenizer{input: []rune(input)}
+}
+
+// peek returns the code point i after the next one, eof past the end.
+func (t *CSSTokenizer) peek(i int) rune {
+	if t.pos+i < len(t.input) {
+		return t.input[t.pos+i]
+	}
+	return eof
+}
+
+func (t *CSSTokenizer) consume() rune {
+	c := t.peek(0)
+	t.pos++
+	return c
+}
+
+// reconsume puts back the code point consumed last.
+func (t *CSSTokenizer) reconsume() {
+	t.pos--
+}
+
+// next consumes a token, CSS_EOF_TOKEN at the end of the input.
+func (t *CSSTokenizer) next() css_token {
+	t.consume_comments()
+	start := t.pos
+	token := t.consume_token()
+	// consuming the end of the input moves past it
+	t.pos = min(t.pos, len(t.input))
+	token.source = string(t.input[start:t.pos])
+	return token
+}
+
+func (t *CSSTokenizer) consume_comments() {
+	for t.peek(0) == '/' && t.peek(1) == '*' {
+		t.pos += 2
+		for t.peek(0) != eof && !(t.peek(0) == '*' && t.peek(1) == '/') {
+			t.pos++
+		}
+		t.pos = min(t.pos+2, len(t.input))
+	}
+}
+
+func (t *CSSTokenizer) consume_token() css_token {
+	c := t.consume()
+	switch {
+	case is_css_whitespace(c):
+		for is_css_whitespace(t.peek(0)) {
+			t.consume()
+		}
+		return css_token{kind: CSS_WHITESPACE_TOKEN}
+	case c == '"' || c == '\'':
+		return t.consume_string(c)
+	case c == '#':
+		if is_ident_code_point(t.peek(0)) || is_valid_escape(t.peek(0), t.peek(1)) {
+			is_id := would_start_ident(t.peek(0), t.peek(1), t.peek(2))
+			return css_token{kind: CSS_HASH_TOKEN, value: t.consume_ident_sequence(), is_id: is_id}
+		}
+	case c == '(':
+		return css_token{kind: CSS_OPEN_PAREN_TOKEN}
+	case c == ')':
+		return css_token{kind: CSS_CLOSE_PAREN_TOKEN}
+	case c == ',':
+		return css_token{kind: CSS_COMMA_TOKEN}
+	case c == ':':
+		return css_token{kind: CSS_COLON_TOKEN}
+	case c == ';':
+		return css_token{kind: CSS_SEMICOLON_TOKEN}
+	case c == '[':
+		return css_token{kind: CSS_OPEN_SQUARE_TOKEN}
+	case c == ']':
+		return css_token{kind: CSS_CLOSE_SQUARE_TOKEN}
+	case c == '{':
+		return css_token{kind: CSS_OPEN_CURLY_TOKEN}
+	case c == '}':
+		return css_token{kind: CSS_CLOSE_CURLY_TOKEN}
+	case c == '+' || c == '.':
+		if would_start_number(c, t.peek(0), t.peek(1)) {
+			t.reconsume()
+			return t.consume_numeric()
+		}
+	case c == '-':
+		if would_start_number(c, t.peek(0), t.peek(1)) {
+			t.reconsume()
+			return t.consume_numeric()
+		} else if t.peek(0) == '-' && t.peek(1) == '>' {
+			t.pos += 2
+			return css_token{kind: CSS_CDC_TOKEN}
+		} else if would_start_ident(c, t.peek(0), t.peek(1)) {
+			t.reconsume()
+			return t.consume_ident_like()
+		}
+	case c == '<':
+		if t.peek(0) == '!' && t.peek(1) == '-' && t.peek(2) == '-' {
+			t.pos += 3
+			return css_token{kind: CSS_CDO_TOKEN}
+		}
+	case c == '@':
+		if would_start_ident(t.peek(0), t.peek(1), t.peek(2)) {
+			return css_token{kind: CSS_AT_KEYWORD_TOKEN, value: t.consume_ident_sequence()}
+		}
+	case c == '\\':
+		if is_valid_escape(c, t.peek(0)) {
+			t.reconsume()
+			return t.consume_ident_like()
+		}
+	case is_ascii_digit(c):
+		t.reconsume()
+		return t.consume_numeric()
+	case is_ident_start_code_point(c):
+		t.reconsume()
+		return t.consume_ident_like()
+	case c == eof:
+		return css_token{kind: CSS_EOF_TOKEN}
+	}
+	return css_token{kind: CSS_DELIM_TOKEN, value: string(c)}
+}
+
+func (t *CSSTokenizer) consume_numeric() css_token {
+	number, is_integer := t.consume_number()
+	if would_start_ident(t.peek(0), t.peek(1), t.peek(2)) {
+		return css_token{kind: CSS_DIMENSION_TOKEN, number: number, is_integer: is_integer, unit: t.consume_ident_sequence()}
+	} else if t.peek(0) == '%' {
+		t.consume()
+		return css_token{kind: CSS_PERCENTAGE_TOKEN, number: number}
+	}
+	return css_token{kind: CSS_NUMBER_TOKEN, number: number, is_integer: is_integer}
+}
+
+func (t *CSSTokenizer) consume_number() (float64, bool) {
+	start := t.pos
+	is_integer := true
+	if t.peek(0) == '+' || t.peek(0) == '-' {
+		t.consume()
+	}
+	t.consume_digits()
+	if t.peek(0) == '.' && is_ascii_digit(t.peek(1)) {
+		t.consume()
+		t.consume_digits()
+		is_integer = false
+	}
+	if t.peek(0) == 'e' || t.peek(0) == 'E' {
+		if is_ascii_digit(t.peek(1)) {
+			t.consume()
+			t.consume_digits()
+			is_integer = false
+		} else if (t.peek(1) == '+' || t.peek(1) == '-') && is_ascii_digit(t.peek(2)) {
+			t.pos += 2
+			t.consume_digits()
+			is_integer = false
+		}
+	}
+	number, _ := strconv.ParseFloat(string(t.input[start:t.pos]), 64)
+	return number, is_integer
+}
+
+func (t *CSSTokenizer) consume_digits() {
+	for is_ascii_digit(t.peek(0)) {
+		t.consume()
+	}
+}
+
+// consume_ident_like consumes an ident, a function or a url.
+func (t *CSSTokenizer) consume_ident_like() css_token {
+	name := t.consume_ident_sequence()
+	if strings.EqualFold(name, "url") && t.peek(0) == '(' {
+		t.consume()
+		for is_css_whitespace(t.peek(0)) && is_css_whitespace(t.peek(1)) {
+			t.consume()
+		}
+		next := t.peek(0)
+		if is_css_whitespace(next) {
+			next = t.peek(1)
+		}
+		// a quoted url is a function taking a string
+		if next == '"' || next == '\'' {
+			return css_token{kind: CSS_FUNCTION_TOKEN, value: name}
+		}
+		return t.consume_url()
+	} else if t.peek(0) == '(' {
+		t.consume()
+		return css_token{kind: CSS_FUNCTION_TOKEN, value: name}
+	}
+	return css_token{kind: CSS_IDENT_TOKEN, value: name}
+}
+
+func (t *CSSTokenizer) consume_string(ending rune) css_token {
+	value := strings.Builder{}
+	for {
+		c := t.consume()
+		switch {
+		case c == ending || c == eof:
+			return css_token{kind: CSS_STRING_TOKEN, value: value.String()}
+		case c == '\n':
+			t.reconsume()
+			return css_token{kind: CSS_BAD_STRING_TOKEN}
+		case c == '\\':
+			if t.peek(0) == '\n' {
+				// an escaped newline continues the string
+				t.consume()
+			} else if t.peek(0) != eof {
+				value.WriteRune(t.consume_escape())
+			}
+		default:
+			value.WriteRune(c)
+		}
+	}
+}
+
+func (t *CSSTokenizer) consume_url() css_token {
+	value := strings.Builder{}
+	for is_css_whitespace(t.peek(0)) {
+		t.consume()
+	}
+	for {
+		c := t.consume()
+		switch {
+		case c == ')' || c == eof:
+			return css_token{kind: CSS_URL_TOKEN, value: value.String()}
+		case is_css_whitespace(c):
+			for is_css_whitespace(t.peek(0)) {
+				t.consume()
+			}
+			if t.peek(0) == ')' || t.peek(0) == eof {
+				t.consume()
+				return css_token{kind: CSS_URL_TOKEN, value: value.String()}
+			}
+			t.consume_bad_url()
+			return css_token{kind: CSS_BAD_URL_TOKEN}
+		case c == '"' || c == '\'' || c == '(' || is_non_printable(c):
+			t.consume_bad_url()
+			return css_token{kind: CSS_BAD_URL_TOKEN}
+		case c == '\\':
+			if !is_valid_escape(c, t.peek(0)) {
+				t.consume_bad_url()
+				return css_token{kind: CSS_BAD_URL_TOKEN}
+			}
+			value.WriteRune(t.consume_escape())
+		default:
+			value.WriteRune(c)
+		}
+	}
+}
+
+// consume_bad_url skips the rest of a broken url, up to its ')'.
+func (t *CSSTokenizer) consume_bad_url() {
+	for {
+		c := t.consume()
+		if c == ')' || c == eof {
+			return
+		} else if is_valid_escape(c, t.peek(0)) {
+			t.consume_escape()
+		}
+	}
+}
+
+// consume_escape consumes what follows a '\', a code point or up to six
+// hex digits giving one.
+func (t *CSSTokenizer) consume_escape() rune {
+	c := t.consume()
+	if c == eof {
+		return '\uFFFD'
+	} else if !is_ascii_hex_digit(c) {
+		return c
+	}
+	code := hex_digit_value(c)
+	for i := 0; i < 5 && is_ascii_hex_digit(t.peek(0)); i++ {
+		code = code*16 + hex_digit_value(t.consume())
+	}
+	if is_css_whitespace(t.peek(0)) {
+		t.consume()
+	}
+	if code == 0 || (code >= 0xD800 && code <= 0xDFFF) || code > 0x10FFFF {
+		return '\uFFFD'
+	}
+	return rune(code)
+}
+
+func (t *CSSTokenizer) consume_ident_sequence() string {
+	out := strings.Builder{}
+	for {
+		c := t.consume()
+		if is_ident_code_point(c) {
+			out.WriteRune(c)
+		} else if is_valid_escape(c, t.peek(0)) {
+			out.WriteRune(t.consume_escape())
+		} else {
+			t.reconsume()
+			return out.String()
+		}
+	}
+}
+
+func is_css_whitespace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func is_ascii_digit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+func is_ident_start_code_point(c rune) bool {
+	return is_ascii_alpha(c) || c >= 0x80 || c == '_'
+}
+
+func is_ident_code_point(c rune) bool {
+	return is_ident_start_code_point(c) || is_ascii_digit(c) || c == '-'
+}
+
+func is_non_printable(c rune) bool {
+	return (c >= 0 && c <= 0x8) || c == 0xB || (c >= 0xE && c <= 0x1F) || c == 0x7F
+}
+
+func is_valid_escape(c1, c2 rune) bool {
+	return c1 == '\\' && c2 != '\n'
+}
+
+// would_start_ident tells whether the three code points start an ident.
+func would_start_ident(c1, c2, c3 rune) bool {
+	switch {
+	case c1 == '-':
+		return is_ident_start_code_point(c2) || c2 == '-' || is_valid_escape(c2, c3)
+	case c1 == '\\':
+		return is_valid_escape(c1, c2)
+	}
+	return is_ident_start_code_point(c1)
+}
+
+// would_start_number tells whether the three code points start a number.
+func would_start_number(c1, c2, c3 rune) bool {
+	switch {
+	case c1 == '+' || c1 == '-':
+		return is_ascii_digit(c2) || (c2 == '.' && is_ascii_digit(c3))
+	case c1 == '.':
+		return is_ascii_digit(c2)
+	}
+	return is_ascii_digit(c1)
+}
@@ -0,0 +1,69 @@
+package browser
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// cssTokens describes the tokens of input, by kind and value.
+func cssTokens(input string) []string {
+	names := map[css_token_kind]string{
+		CSS_IDENT_TOKEN: "ident", CSS_FUNCTION_TOKEN: "function", CSS_AT_KEYWORD_TOKEN: "at",
+		CSS_HASH_TOKEN: "hash", CSS_STRING_TOKEN: "string", CSS_BAD_STRING_TOKEN: "bad-string",
+		CSS_URL_TOKEN: "url", CSS_BAD_URL_TOKEN: "bad-url", CSS_DELIM_TOKEN: "delim",
+		CSS_WHITESPACE_TOKEN: "ws", CSS_CDO_TOKEN: "<!--", CSS_CDC_TOKEN: "-->", CSS_COLON_TOKEN: ":",
+		CSS_SEMICOLON_TOKEN: ";", CSS_COMMA_TOKEN: ",", CSS_OPEN_SQUARE_TOKEN: "[",
+		CSS_CLOSE_SQUARE_TOKEN: "]", CSS_OPEN_PAREN_TOKEN: "(", CSS_CLOSE_PAREN_TOKEN: ")",
+		CSS_OPEN_CURLY_TOKEN: "{", CSS_CLOSE_CURLY_TOKEN: "}",
+	}
+	tokens := []string{}
+	tokenizer := NewCSSTokenizer(input)
+	for token := tokenizer.next(); token.kind != CSS_EOF_TOKEN; token = tokenizer.next() {
+		switch token.kind {
+		case CSS_NUMBER_TOKEN:
+			tokens = append(tokens, fmt.Sprintf("number:%v", token.number))
+		case CSS_PERCENTAGE_TOKEN:
+			tokens = append(tokens, fmt.Sprintf("percentage:%v", token.number))
+		case CSS_DIMENSION_TOKEN:
+			tokens = append(tokens, fmt.Sprintf("dimension:%v%s", token.number, token.unit))
+		case CSS_HASH_TOKEN:
+			tokens = append(tokens, fmt.Sprintf("hash:%s:%v", token.value, token.is_id))
+		default:
+			if token.value == "" {
+				tokens = append(tokens, names[token.kind])
+			} else {
+				tokens = append(tokens, names[token.kind]+":"+token.value)
+			}
+		}
+	}
+	return tokens
+}
+
+func TestCSSTokenizer(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`p{color:red}`, []string{"ident:p", "{", "ident:color", ":", "ident:red", "}"}},
+		{`a /* b */ c/* d`, []string{"ident:a", "ws", "ws", "ident:c"}},
+		{`"a\"b" 'c\
+d' "e
+`, []string{`string:a"b`, "ws", "string:cd", "ws", "bad-string", "ws"}},
+		{`\41 b \2603x \0`, []string{"ident:Ab", "ws", "ident:☃x", "ws", "ident:�"}},
+		{`12 -3.5 +.5e2 1e 10% 2.5em -x --y`, []string{"number:12", "ws", "number:-3.5", "ws", "number:50", "ws",
+			"dimension:1e", "ws", "percentage:10", "ws", "dimension:2.5em", "ws", "ident:-x", "ws", "ident:--y"}},
+		{`#fff #1a #-`, []string{"hash:fff:true", "ws", "hash:1a:false", "ws", "hash:-:false"}},
+		{`url( a.png ) url("b") URL(c d) url(e`, []string{"url:a.png", "ws", "function:url", "string:b", ")", "ws",
+			"bad-url", "ws", "url:e"}},
+		{`@media screen{} @ x`, []string{"at:media", "ws", "ident:screen", "{", "}", "ws", "delim:@", "ws", "ident:x"}},
+		{`<!-- a --> !;,[]() 5px+`, []string{"<!--", "ws", "ident:a", "ws", "-->", "ws", "delim:!", ";", ",", "[", "]",
+			"(", ")", "ws", "dimension:5px", "delim:+"}},
+		{"a\r\nb\fc\x00", []string{"ident:a", "ws", "ident:b", "ws", "ident:c�"}},
+	}
+	for _, test := range tests {
+		if got := cssTokens(test.input); !slices.Equal(got, test.want) {
+			t.Errorf("Tokenizing %q gave %q, want %q", test.input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package browser
+
+import (
+	"bytes"
+	u "gowser/url"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+var (
+	// matches both <meta charset=x> and <meta http-equiv content="text/html; charset=x">
+	META_CHARSET = regexp.MustCompile(`(?i)<meta[^>]*charset\s*=\s*["']?\s*([\w.:+-]+)`)
+	CSS_CHARSET  = regexp.MustCompile(`^@charset "([^"]*)";`)
+)
+
+// bom_encoding returns the encoding a byte order mark selects and the
+// length of the mark.
+func bom_encoding(body []byte) (string, int) {
+	switch {
+	case bytes.HasPrefix(body, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8", 3
+	case bytes.HasPrefix(body, []byte{0xfe, 0xff}):
+		return "utf-16be", 2
+	case bytes.HasPrefix(body, []byte{0xff, 0xfe}):
+		return "utf-16le", 2
+	}
+	return "", 0
+}
+
+func content_type_charset(headers map[string]string) string {
+	_, params, err := mime.ParseMediaType(headers["content-type"])
+	if err != nil {
+		return ""
+	}
+	return params["charset"]
+}
+
+// lookup_encoding finds the encoding for a label like "latin1" and its
+// canonical name. Labels map the way they do in browsers, so ISO-8859-1
+// is decoded as windows-1252.
+func lookup_encoding(label string) (encoding.Encoding, string) {
+	enc, err := htmlindex.Get(strings.TrimSpace(label))
+	if err != nil {
+		return nil, ""
+	}
+	name, _ := htmlindex.Name(enc)
+	return enc, name
+}
+
+func decode(body []byte, name string) string {
+	enc, _ := lookup_encoding(name)
+	if enc == nil || name == "utf-8" {
+		return strings.ToValidUTF8(string(body), "�")
+	}
+	text, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return string(body)
+	}
+	return string(text)
+}
+
+// document_encoding picks the encoding of an HTML document from, in this
+// order, a byte order mark, the charset of the Content-Type header or a
+// <meta charset> in the first 1024 bytes. Documents that don't say are
+// taken as UTF-8 if they are valid UTF-8, and as windows-1252 otherwise.
+func document_encoding(headers map[string]string, body []byte) (string, int) {
+	if name, bom := bom_encoding(body); name != "" {
+		return name, bom
+	}
+	if _, name := lookup_encoding(content_type_charset(headers)); name != "" {
+		return name, 0
+	}
+	if match := META_CHARSET.FindSubmatch(body[:min(len(body), 1024)]); match != nil {
+		if _, name := lookup_encoding(string(match[1])); name != "" {
+			// a document that could be read as ASCII up to here is no UTF-16
+			if strings.HasPrefix(name, "utf-16") {
+				name = "utf-8"
+			}
+			return name, 0
+		}
+	}
+	if utf8.Valid(body) {
+		return "utf-8", 0
+	}
+	return "windows-1252", 0
+}
+
+// decode_document returns the text of an HTML document and the name of
+// its encoding.
+func decode_document(headers map[string]string, body []byte) (string, string) {
+	name, bom := document_encoding(headers, body)
+	return decode(body[bom:], name), name
+}
+
+// decode_subresource returns the text of a stylesheet or script. Unless it
+// has a byte order mark, a charset in its Content-Type or, for CSS, an
+// @charset rule, it is in the encoding of the document that loads it.
+func decode_subresource(response *u.Response, document_encoding string, is_css bool) string {
+	body := response.Body
+	if name, bom := bom_encoding(body); name != "" {
+		return decode(body[bom:], name)
+	}
+	if _, name := lookup_encoding(content_type_charset(response.Headers)); name != "" {
+		return decode(body, name)
+	}
+	if match := CSS_CHARSET.FindSubmatch(body); is_css && match != nil {
+		if _, name := lookup_encoding(string(match[1])); name != "" {
+			return decode(body, name)
+		}
+	}
+	if document_encoding == "" {
+		document_encoding = "utf-8"
+	}
+	return decode(body, document_encoding)
+}
@@ -0,0 +1,87 @@
+package browser
+
+import (
+	u "gowser/url"
+	"strings"
+	"testing"
+)
+
+func TestDecodeDocument(t *testing.T) {
+	tests := []struct {
+		name         string
+		content_type string
+		body         string
+		text         string
+		encoding     string
+	}{
+		{"utf-8 by default", "text/html", "<p>café</p>", "<p>café</p>", "utf-8"},
+		{"content-type", "text/html; charset=ISO-8859-1", "<p>caf\xe9</p>", "<p>café</p>", "windows-1252"},
+		{"quoted content-type", `text/html; charset="shift_jis"`, "<p>\x82\xa0</p>", "<p>あ</p>", "shift_jis"},
+		{"meta charset", "text/html", "<meta charset=\"windows-1252\"><p>caf\xe9", `<meta charset="windows-1252"><p>café`, "windows-1252"},
+		{"meta http-equiv", "text/html", `<meta http-equiv="Content-Type" content="text/html; charset=latin1">` + "\x80",
+			`<meta http-equiv="Content-Type" content="text/html; charset=latin1">€`, "windows-1252"},
+		{"meta utf-16 is utf-8", "text/html", `<meta charset=utf-16><p>é`, `<meta charset=utf-16><p>é`, "utf-8"},
+		{"header before meta", "text/html; charset=utf-8", `<meta charset="windows-1252"><p>é`, `<meta charset="windows-1252"><p>é`, "utf-8"},
+		{"utf-8 bom", "text/html; charset=windows-1252", "\xef\xbb\xbf<p>é", "<p>é", "utf-8"},
+		{"utf-16le bom", "text/html", "\xff\xfe<\x00p\x00>\x00\xe9\x00", "<p>é", "utf-16le"},
+		{"utf-16be bom", "text/html", "\xfe\xff\x00<\x00p\x00>\x00\xe9", "<p>é", "utf-16be"},
+		{"windows-1252 fallback", "text/html", "\x93quoted\x94", "“quoted”", "windows-1252"},
+		{"unknown label", "text/html; charset=bogus", "<p>é", "<p>é", "utf-8"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := map[string]string{"content-type": test.content_type}
+			text, encoding := decode_document(headers, []byte(test.body))
+			if text != test.text || encoding != test.encoding {
+				t.Errorf("Expected %q in %s, got %q in %s", test.text, test.encoding, text, encoding)
+			}
+		})
+	}
+}
+
+func TestDecodeSubresource(t *testing.T) {
+	tests := []struct {
+		name         string
+		content_type string
+		body         string
+		is_css       bool
+		text         string
+	}{
+		{"document encoding", "text/css", "p{x:\xe9}", true, "p{x:é}"},
+		{"content-type", "text/css; charset=utf-8", "p{x:é}", true, "p{x:é}"},
+		{"@charset", "text/css", "@charset \"utf-8\";p{x:é}", true, "@charset \"utf-8\";p{x:é}"},
+		{"@charset in script", "text/javascript", "@charset \"utf-8\";\xe9", false, "@charset \"utf-8\";é"},
+		{"bom", "text/javascript", "\xef\xbb\xbfé", false, "é"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := &u.Response{Headers: map[string]string{"content-type": test.content_type}, Body: []byte(test.body)}
+			if text := decode_subresource(response, "windows-1252", test.is_css); text != test.text {
+				t.Errorf("Expected %q, got %q", test.text, text)
+			}
+		})
+	}
+}
+
+func TestFrameLoadLegacyEncoding(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.AddExchange(&u.Exchange{
+		Method:  "GET",
+		URL:     "http://example.org/",
+		Status:  200,
+		Headers: map[string]string{"content-type": "text/html; charset=shift_jis"},
+		Body:    []byte(`<link rel=stylesheet href=style.css><p>` + "\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd" + `</p>`),
+	})
+	fetcher.Add("http://example.org/style.css", "text/css", `p { font-family: "`+"\x83\x65"+`" }`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	frame := tab.root_frame
+	if text := documentText(frame); !strings.Contains(text, "こんにちは") {
+		t.Errorf("Expected the document to be decoded as Shift_JIS, got %q", text)
+	}
+	rules := frame.rules[len(DEFAULT_STYLE_SHEET):]
+	if len(rules) != 1 || !strings.Contains(rules[0].Body["font-family"], "テ") {
+		t.Errorf("Expected the stylesheet to be decoded like its document, got %v", rules)
+	}
+}
@@ -0,0 +1,78 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	u "gowser/url"
+	"html"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+func NewErrorPage(url *u.URL, title, message string) *u.Response {
+	out := "<!doctype html>"
+	out += "<title>" + html.EscapeString(title) + "</title>"
+	out += "<h1>" + html.EscapeString(title) + "</h1>"
+	out += "<p>" + html.EscapeString(message) + "</p>"
+	out += "<p><code>" + html.EscapeString(url.String()) + "</code></p>"
+	return &u.Response{
+		URL:     url,
+		Headers: map[string]string{"content-type": "text/html"},
+		Body:    []byte(out),
+	}
+}
+
+// NewLoadErrorPage explains why url could not be loaded at all.
+func NewLoadErrorPage(url *u.URL, err error) *u.Response {
+	var dns_err *net.DNSError
+	var net_err net.Error
+	switch {
+	case errors.As(err, &dns_err):
+		return NewErrorPage(url, "Server not found", "The server "+dns_err.Name+" could not be found.")
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return NewErrorPage(url, "Connection refused", "The server refused the connection.")
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &net_err) && net_err.Timeout()):
+		return NewErrorPage(url, "Connection timed out", "The server took too long to respond.")
+	case errors.Is(err, u.ErrTLSHandshake):
+		return NewErrorPage(url, "Secure connection failed", err.Error())
+	case errors.Is(err, u.ErrTooManyRedirects):
+		return NewErrorPage(url, "Too many redirects", err.Error())
+	}
+	return NewErrorPage(url, "This page could not be loaded", err.Error())
+}
+
+// NewHTTPErrorPage stands in for an error response that came without a
+// body of its own.
+func NewHTTPErrorPage(response *u.Response) *u.Response {
+	status := strconv.Itoa(response.Status)
+	if response.Reason != "" {
+		status += " " + response.Reason
+	}
+	page := NewErrorPage(response.URL, status, "The server could not handle the request.")
+	if response.Status < 500 {
+		page = NewErrorPage(response.URL, status, "The server could not find or would not return the page.")
+	}
+	page.Status, page.Reason = response.Status, response.Reason
+	return page
+}
+
+// NewCertErrorPage is shown instead of a page whose certificate could not
+// be verified. The proceed link is handled by Frame.activate_element.
+func NewCertErrorPage(url *u.URL, err *u.CertificateError) *u.Response {
+	response := NewErrorPage(url, "Your connection is not private", err.Error())
+	proceed := "<p><a id=proceed href=\"" + html.EscapeString(url.String()) + "\">"
+	proceed += "Proceed to " + html.EscapeString(err.Host) + " (unsafe)</a></p>"
+	response.Body = append(response.Body, proceed...)
+	return response
+}
+
+// NewResubmitPage asks before a form is posted again while moving through
+// history. The resubmit link is handled by Frame.activate_element.
+func NewResubmitPage(url *u.URL) *u.Response {
+	response := NewErrorPage(url, "Confirm form resubmission",
+		"This page was the result of a form submission. Sending the form again repeats whatever it did.")
+	resubmit := "<p><a id=resubmit href=\"" + html.EscapeString(url.String()) + "\">Resubmit the form</a></p>"
+	response.Body = append(response.Body, resubmit...)
+	return response
+}
@@ -0,0 +1,390 @@
+package browser
+
+import (
+	"crypto/rand"
+	"fmt"
+	u "gowser/url"
+	"mime"
+	urllib "net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// elements laid out as a single box by InputLayout
+	WIDGET_TAGS = []string{"input", "button", "textarea", "select"}
+	// inputs that aren't typed into
+	NON_TEXT_INPUT_TYPES = []string{
+		"hidden", "checkbox", "radio", "submit", "reset", "button", "image"}
+	CHECKBOX_SIZE_PX = 16.
+	TEXTAREA_ROWS    = 2
+	// multipart/form-data payloads are split by a boundary made from this
+	FORM_BOUNDARY_PREFIX = "----GowserFormBoundary"
+)
+
+// A form_entry is a name and value a form submits. For file inputs the
+// value is the path of the file the user typed into the input.
+type form_entry struct {
+	name  string
+	value string
+	file  bool
+}
+
+// input_type is the type of an input element, text if it has none.
+func input_type(node *HtmlNode) string {
+	if input_type := strings.ToLower(node.Token.(ElementToken).Attributes["type"]); input_type != "" {
+		return input_type
+	}
+	return "text"
+}
+
+func is_input(node *HtmlNode, types ...string) bool {
+	element, ok := node.Token.(ElementToken)
+	return ok && element.Tag == "input" && slices.Contains(types, input_type(node))
+}
+
+// is_text_input is true for inputs with a value typed into them, like
+// text, password or search. The path of a file input is typed too.
+func is_text_input(node *HtmlNode) bool {
+	element, ok := node.Token.(ElementToken)
+	return ok && element.Tag == "input" && !slices.Contains(NON_TEXT_INPUT_TYPES, input_type(node))
+}
+
+// input_value is what was typed into a text input. The path of a file
+// input is not its value attribute, so that neither markup nor scripts
+// can pick a file to upload.
+func input_value(node *HtmlNode) string {
+	if is_input(node, "file") {
+		return node.FilePath
+	}
+	return node.Token.(ElementToken).Attributes["value"]
+}
+
+func set_input_value(node *HtmlNode, value string) {
+	if is_input(node, "file") {
+		node.FilePath = value
+	} else {
+		node.Token.(ElementToken).Attributes["value"] = value
+	}
+}
+
+// clears_on_first_key is true for inputs without a value attribute, which
+// are cleared when the user starts typing.
+func clears_on_first_key(node *HtmlNode) bool {
+	_, ok := node.Token.(ElementToken).Attributes["value"]
+	return !ok && !is_input(node, "file")
+}
+
+func is_checkable(node *HtmlNode) bool {
+	return is_input(node, "checkbox", "radio")
+}
+
+func is_element(node *HtmlNode, tag string) bool {
+	element, ok := node.Token.(ElementToken)
+	return ok && element.Tag == tag
+}
+
+// control_width is the width of a form control in CSS pixels.
+func control_width(node *HtmlNode) float64 {
+	if is_checkable(node) {
+		return CHECKBOX_SIZE_PX
+	}
+	return INPUT_WIDTH_PX
+}
+
+// control_rows is the height of a form control in lines.
+func control_rows(node *HtmlNode) int {
+	if !is_element(node, "textarea") {
+		return 1
+	}
+	rows, err := strconv.Atoi(node.Token.(ElementToken).Attributes["rows"])
+	if err != nil || rows <= 0 {
+		return TEXTAREA_ROWS
+	}
+	return rows
+}
+
+// textarea_value is what a textarea holds, the text inside it.
+func textarea_value(node *HtmlNode) string {
+	return inline_text(node)
+}
+
+func set_textarea_value(node *HtmlNode, value string) {
+	node.Children = []*HtmlNode{NewNode(NewTextToken(value), node)}
+}
+
+// options returns the option elements of a select, also those inside
+// an optgroup.
+func options(node *HtmlNode) []*HtmlNode {
+	options := []*HtmlNode{}
+	for _, child := range TreeToList(node)[1:] {
+		if is_element(child, "option") {
+			options = append(options, child)
+		}
+	}
+	return options
+}
+
+// selected_option is the option of a select shown, the first one marked
+// as selected or else the first one.
+func selected_option(node *HtmlNode) *HtmlNode {
+	options := options(node)
+	for _, option := range options {
+		if _, selected := option.Token.(ElementToken).Attributes["selected"]; selected {
+			return option
+		}
+	}
+	if len(options) > 0 {
+		return options[0]
+	}
+	return nil
+}
+
+// select_option makes option the selected one of its select.
+func select_option(node *HtmlNode, option *HtmlNode) {
+	for _, other := range options(node) {
+		delete(other.Token.(ElementToken).Attributes, "selected")
+	}
+	option.Token.(ElementToken).Attributes["selected"] = ""
+}
+
+// option_label is the text an option shows.
+func option_label(option *HtmlNode) string {
+	return strings.Join(strings.Fields(inline_text(option)), " ")
+}
+
+// option_value is what an option submits, its label unless it has a
+// value.
+func option_value(option *HtmlNode) string {
+	if value, ok := option.Token.(ElementToken).Attributes["value"]; ok {
+		return value
+	}
+	return option_label(option)
+}
+
+// cycle_option selects the option after the selected one, or the next
+// one starting with prefix if it is given.
+func cycle_option(node *HtmlNode, prefix string) {
+	options := options(node)
+	start := slices.Index(options, selected_option(node))
+	for i := 1; i <= len(options); i++ {
+		option := options[(start+i)%len(options)]
+		if prefix == "" || strings.HasPrefix(strings.ToLower(option_label(option)), strings.ToLower(prefix)) {
+			select_option(node, option)
+			return
+		}
+	}
+}
+
+func is_checked(node *HtmlNode) bool {
+	_, checked := node.Token.(ElementToken).Attributes["checked"]
+	return checked
+}
+
+// set_checked checks or unchecks a checkbox or radio button. Checking a
+// radio button unchecks the others of its group.
+func set_checked(node *HtmlNode, checked bool) {
+	attributes := node.Token.(ElementToken).Attributes
+	if !checked {
+		delete(attributes, "checked")
+		return
+	}
+	if is_input(node, "radio") && attributes["name"] != "" {
+		for _, other := range TreeToList(form_root(node)) {
+			if other != node && is_input(other, "radio") && other.Token.(ElementToken).Attributes["name"] == attributes["name"] {
+				delete(other.Token.(ElementToken).Attributes, "checked")
+			}
+		}
+	}
+	attributes["checked"] = ""
+}
+
+// form_root is the form a control belongs to, or the document for
+// controls outside of forms.
+func form_root(node *HtmlNode) *HtmlNode {
+	if form := form_of(node); form != nil {
+		return form
+	}
+	for node.Parent != nil {
+		node = node.Parent
+	}
+	return node
+}
+
+// form_of is the form a control belongs to, nil if it is in none.
+func form_of(node *HtmlNode) *HtmlNode {
+	for ; node != nil; node = node.Parent {
+		if is_element(node, "form") {
+			return node
+		}
+	}
+	return nil
+}
+
+// is_submit_button is true for the buttons that submit their form.
+func is_submit_button(node *HtmlNode) bool {
+	if is_element(node, "button") {
+		button_type := strings.ToLower(node.Token.(ElementToken).Attributes["type"])
+		return button_type == "" || button_type == "submit"
+	}
+	return is_input(node, "submit")
+}
+
+// default_button is the first submit button of a form, the one pressing
+// Enter in its fields clicks.
+func default_button(form *HtmlNode) *HtmlNode {
+	for _, node := range TreeToList(form) {
+		if is_submit_button(node) {
+			return node
+		}
+	}
+	return nil
+}
+
+// is_disabled is true for disabled controls and those in a disabled
+// fieldset.
+func is_disabled(node *HtmlNode) bool {
+	if _, disabled := node.Token.(ElementToken).Attributes["disabled"]; disabled {
+		return true
+	}
+	for parent := node.Parent; parent != nil; parent = parent.Parent {
+		if is_element(parent, "fieldset") {
+			if _, disabled := parent.Token.(ElementToken).Attributes["disabled"]; disabled {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// form_entries lists the names and values form submits, in document
+// order. Of the buttons only submitter, the one that submits the form,
+// is included.
+func form_entries(form *HtmlNode, submitter *HtmlNode) []form_entry {
+	entries := []form_entry{}
+	for _, node := range TreeToList(form) {
+		element, ok := node.Token.(ElementToken)
+		if !ok || element.Attributes["name"] == "" || is_disabled(node) {
+			continue
+		}
+		name := element.Attributes["name"]
+		switch {
+		case node == submitter:
+			entries = append(entries, form_entry{name, element.Attributes["value"], false})
+		case is_input(node, "file"):
+			entries = append(entries, form_entry{name, node.FilePath, true})
+		case is_checkable(node):
+			if !is_checked(node) {
+				continue
+			}
+			value, ok := element.Attributes["value"]
+			if !ok {
+				value = "on"
+			}
+			entries = append(entries, form_entry{name, value, false})
+		case is_text_input(node) || is_input(node, "hidden"):
+			entries = append(entries, form_entry{name, element.Attributes["value"], false})
+		case element.Tag == "textarea":
+			// line breaks are submitted as CRLF
+			value := strings.ReplaceAll(textarea_value(node), "\r\n", "\n")
+			entries = append(entries, form_entry{name, strings.ReplaceAll(value, "\n", "\r\n"), false})
+		case element.Tag == "select":
+			if _, multiple := element.Attributes["multiple"]; multiple {
+				for _, option := range options(node) {
+					if _, selected := option.Token.(ElementToken).Attributes["selected"]; selected {
+						entries = append(entries, form_entry{name, option_value(option), false})
+					}
+				}
+			} else if option := selected_option(node); option != nil {
+				entries = append(entries, form_entry{name, option_value(option), false})
+			}
+		}
+	}
+	return entries
+}
+
+// file_name is the name a file entry is submitted with, without the
+// directories of its path.
+func (e form_entry) file_name() string {
+	if e.value == "" {
+		return ""
+	}
+	return filepath.Base(e.value)
+}
+
+// encode_form serializes entries for a POST with the given enctype,
+// url-encoded unless it is multipart/form-data or text/plain.
+func encode_form(entries []form_entry, enctype string) (*u.Payload, error) {
+	switch strings.ToLower(enctype) {
+	case "multipart/form-data":
+		boundary := FORM_BOUNDARY_PREFIX + rand.Text()
+		body, err := encode_multipart(entries, boundary)
+		if err != nil {
+			return nil, err
+		}
+		return &u.Payload{Method: "POST", ContentType: "multipart/form-data; boundary=" + boundary, Body: body}, nil
+	case "text/plain":
+		return &u.Payload{Method: "POST", ContentType: "text/plain", Body: encode_text_plain(entries)}, nil
+	}
+	return &u.Payload{Method: "POST", ContentType: "application/x-www-form-urlencoded", Body: encode_urlencoded(entries)}, nil
+}
+
+// encode_urlencoded serializes entries as a query string. Files are
+// sent by their name only.
+func encode_urlencoded(entries []form_entry) string {
+	pairs := []string{}
+	for _, entry := range entries {
+		value := entry.value
+		if entry.file {
+			value = entry.file_name()
+		}
+		pairs = append(pairs, urllib.QueryEscape(entry.name)+"="+urllib.QueryEscape(value))
+	}
+	return strings.Join(pairs, "&")
+}
+
+func encode_text_plain(entries []form_entry) string {
+	out := ""
+	for _, entry := range entries {
+		value := entry.value
+		if entry.file {
+			value = entry.file_name()
+		}
+		out += entry.name + "=" + value + "\r\n"
+	}
+	return out
+}
+
+// encode_multipart serializes entries as multipart/form-data, each in a
+// part of its own. Files are read from disk when the form is submitted.
+func encode_multipart(entries []form_entry, boundary string) (string, error) {
+	// quotes and line breaks would end the header values early
+	escaper := strings.NewReplacer("\"", "%22", "\r", "%0D", "\n", "%0A")
+	out := ""
+	for _, entry := range entries {
+		out += "--" + boundary + "\r\n"
+		out += "Content-Disposition: form-data; name=\"" + escaper.Replace(entry.name) + "\""
+		if !entry.file {
+			value := strings.ReplaceAll(strings.ReplaceAll(entry.value, "\r\n", "\n"), "\n", "\r\n")
+			out += "\r\n\r\n" + value + "\r\n"
+			continue
+		}
+		var content []byte
+		if entry.value != "" {
+			var err error
+			if content, err = os.ReadFile(entry.value); err != nil {
+				return "", fmt.Errorf("reading %s failed: %w", entry.value, err)
+			}
+		}
+		content_type := mime.TypeByExtension(filepath.Ext(entry.value))
+		if content_type == "" {
+			content_type = "application/octet-stream"
+		}
+		out += "; filename=\"" + escaper.Replace(entry.file_name()) + "\"\r\n"
+		out += "Content-Type: " + content_type + "\r\n\r\n" + string(content) + "\r\n"
+	}
+	return out + "--" + boundary + "--\r\n", nil
+}
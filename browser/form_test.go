@@ -0,0 +1,288 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	u "gowser/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// withRuntime loads the JS runtime for the tabs the test opens, so that
+// events aren't cancelled by the missing runtime.
+func withRuntime(t *testing.T) {
+	data, err := os.ReadFile("../runtime.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := RUNTIME_JS
+	RUNTIME_JS = string(data)
+	t.Cleanup(func() { RUNTIME_JS = runtime })
+}
+
+func TestFormEntries(t *testing.T) {
+	form := NewHTMLParser(`<form>
+<input name=text value="a b">
+<input type=hidden name=token value=t>
+<input type=password name=pw value=secret>
+<input type=checkbox name=box1 checked>
+<input type=checkbox name=box2 value=yes>
+<input type=checkbox name=box3 value=yes checked>
+<input type=radio name=color value=red>
+<input type=radio name=color value=blue checked>
+<textarea name=notes>
+line 1
+line 2</textarea>
+<select name=size><option>S<option selected value=m>M</select>
+<select name=tags multiple><option selected>x<option>y<option selected>z</select>
+<input name=off value=x disabled>
+<fieldset disabled><input name=off2 value=y></fieldset>
+<input type=file name=upload value="/tmp/photo.png">
+<input type=submit name=go value=Go><button name=other>Other</button>
+</form>`).Parse()
+	var form_node *HtmlNode
+	for _, node := range TreeToList(form) {
+		if is_element(node, "form") {
+			form_node = node
+		}
+	}
+
+	got := []string{}
+	for _, entry := range form_entries(form_node, default_button(form_node)) {
+		got = append(got, entry.name+"="+entry.value)
+	}
+	want := []string{"text=a b", "token=t", "pw=secret", "box1=on", "box3=yes", "color=blue",
+		"notes=line 1\r\nline 2", "size=m", "tags=x", "tags=z", "upload=", "go=Go"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected form entries %q, got %q", want, got)
+	}
+}
+
+func TestFormControls(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<form>
+<input type=hidden name=token value=t>
+<input type=password name=pw value=abc>
+<input type=checkbox name=box>
+<input type=radio name=r value=1 checked><input type=radio name=r value=2>
+<select name=fruit><option>apple<option>banana<option>cherry</select>
+</form>`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+	frame := tab.root_frame
+
+	inputs := map[string]*HtmlNode{}
+	for _, node := range TreeToList(frame.Nodes) {
+		if element, ok := node.Token.(ElementToken); ok && element.Attributes["name"] != "" {
+			inputs[element.Attributes["name"]+element.Attributes["value"]] = node
+		}
+	}
+	runTask(tab, func() {
+		frame.Render()
+		if inputs["tokent"].LayoutObject != nil || IsFocusable(inputs["tokent"]) {
+			t.Error("Expected hidden inputs not to be rendered or focusable")
+		}
+		password := ""
+		for _, cmd := range inputs["pwabc"].LayoutObject.Layout.Paint() {
+			if text, ok := cmd.(*DrawText); ok {
+				password = text.text
+			}
+		}
+		if password != "***" {
+			t.Errorf("Expected the password to be masked, got %q", password)
+		}
+
+		// without the JS runtime events count as cancelled, so this only
+		// covers what doesn't dispatch any
+		frame.activate_element(inputs["box"])
+		frame.activate_element(inputs["r2"])
+		tab.focus = inputs["fruit"]
+		frame.enter()
+		frame.enter()
+	})
+	if !is_checked(inputs["box"]) || is_checked(inputs["r1"]) || !is_checked(inputs["r2"]) {
+		t.Error("Expected the checkbox and the second radio button to be checked")
+	}
+	if label := option_label(selected_option(inputs["fruit"])); label != "cherry" {
+		t.Errorf("Expected cherry to be selected, got %s", label)
+	}
+	runTask(tab, func() { frame.activate_element(inputs["r1"]) })
+	if !is_checked(inputs["r1"]) || is_checked(inputs["r2"]) {
+		t.Error("Expected checking a radio button to uncheck the others of its group")
+	}
+}
+
+func TestFormStateHistory(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/a", "text/html", `<input type=checkbox name=box>
+<select name=s><option>x<option>y</select><textarea name=t>old</textarea>
+<input type=password name=pw><input type=file name=f>`)
+	fetcher.Add("http://example.org/b", "text/html", `<p>b</p>`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/a"))
+
+	runTask(tab, func() {
+		frame := tab.root_frame
+		set_checked(findElement(frame, "input"), true)
+		cycle_option(findElement(frame, "select"), "")
+		set_textarea_value(findElement(frame, "textarea"), "new")
+		for _, control := range TreeToList(frame.Nodes) {
+			if is_input(control, "password", "file") {
+				control.Token.(ElementToken).Attributes["value"] = "secret"
+			}
+		}
+	})
+	loadPage(tab, mustURL(t, "http://example.org/b"))
+	runTask(tab, tab.go_back)
+
+	frame := tab.root_frame
+	state := fmt.Sprintf("%v %s %s", is_checked(findElement(frame, "input")), option_label(selected_option(findElement(frame, "select"))),
+		textarea_value(findElement(frame, "textarea")))
+	if state != "true y new" {
+		t.Errorf("Expected the form state to be restored, got %s", state)
+	}
+	if slices.Contains(tab.history.entries[0].form_state, "secret") {
+		t.Errorf("Expected passwords and files not to be kept in history, got %q", tab.history.entries[0].form_state)
+	}
+}
+
+func TestFormEncodings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("file\ncontent"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entries := []form_entry{{"q", "a b&c", false}, {"note", "x\r\ny", false}, {"up", path, true}, {"none", "", true}}
+
+	if got := encode_urlencoded(entries); got != "q=a+b%26c&note=x%0D%0Ay&up=a.txt&none=" {
+		t.Errorf("Unexpected url-encoded form %q", got)
+	}
+	if got := encode_text_plain(entries); got != "q=a b&c\r\nnote=x\r\ny\r\nup=a.txt\r\nnone=\r\n" {
+		t.Errorf("Unexpected text/plain form %q", got)
+	}
+	got, err := encode_multipart(entries, "XYZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--XYZ\r\nContent-Disposition: form-data; name=\"q\"\r\n\r\na b&c\r\n" +
+		"--XYZ\r\nContent-Disposition: form-data; name=\"note\"\r\n\r\nx\r\ny\r\n" +
+		"--XYZ\r\nContent-Disposition: form-data; name=\"up\"; filename=\"a.txt\"\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\nfile\ncontent\r\n" +
+		"--XYZ\r\nContent-Disposition: form-data; name=\"none\"; filename=\"\"\r\n" +
+		"Content-Type: application/octet-stream\r\n\r\n\r\n" +
+		"--XYZ--\r\n"
+	if got != want {
+		t.Errorf("Unexpected multipart form:\n%q\nwant:\n%q", got, want)
+	}
+	if _, err := encode_multipart([]form_entry{{"up", path + ".missing", true}}, "XYZ"); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestFormSubmit(t *testing.T) {
+	withRuntime(t)
+	page := `
+<form id=get action=/search method=get><input name=q value="a b"><input name=r></form>
+<form id=post action=/submit method=post><input name=q value=x><button name=b value=1>Go</button></form>
+<form id=plain action=/submit method=post enctype=text/plain><input name=q value=x></form>
+<form id=multipart action=/submit method=post enctype=multipart/form-data><input name=q value=x></form>
+<form id=empty action=/empty method=post><input value=unnamed></form>
+<form id=emptyplain action=/empty method=post enctype=text/plain></form>`
+	var requests []string
+	fetcher := fetcherFunc(func(ctx context.Context, url *u.URL, referrer *u.URL, payload *u.Payload) (*u.Response, error) {
+		request := "GET " + url.String()
+		if payload != nil {
+			request = payload.Method + " " + url.String() + " " + payload.ContentType + " " + payload.Body
+		}
+		requests = append(requests, request)
+		body := page
+		if url.String() != "http://example.org/" {
+			body = "<p>done</p>"
+		}
+		return &u.Response{URL: url, Status: 200, Reason: "OK", Headers: map[string]string{}, Body: []byte(body)}, nil
+	})
+	tab := newTestTab(t, fetcher)
+
+	tests := []struct {
+		id    string
+		enter bool // submit by pressing Enter in the first field
+		want  string
+	}{
+		{"get", true, "GET http://example.org/search?q=a+b&r="},
+		{"post", true, "POST http://example.org/submit application/x-www-form-urlencoded q=x&b=1"},
+		{"post", false, "POST http://example.org/submit application/x-www-form-urlencoded q=x"},
+		{"plain", false, "POST http://example.org/submit text/plain q=x\r\n"},
+		{"multipart", false, "POST http://example.org/submit multipart/form-data; boundary=" + FORM_BOUNDARY_PREFIX},
+		// forms without entries are posted all the same
+		{"empty", false, "POST http://example.org/empty application/x-www-form-urlencoded "},
+		{"emptyplain", false, "POST http://example.org/empty text/plain "},
+	}
+	for _, test := range tests {
+		loadPage(tab, mustURL(t, "http://example.org/"))
+		requests = nil
+		runTask(tab, func() {
+			frame := tab.root_frame
+			var form *HtmlNode
+			for _, node := range TreeToList(frame.Nodes) {
+				if is_element(node, "form") && node.Token.(ElementToken).Attributes["id"] == test.id {
+					form = node
+				}
+			}
+			if test.enter {
+				frame.focus_element(form.Children[0])
+				frame.enter()
+			} else {
+				frame.submit_form(form, nil)
+			}
+		})
+		waitForLoads(tab)
+		if len(requests) != 1 || !strings.HasPrefix(requests[0], test.want) {
+			t.Errorf("Expected submitting %s to request %q, got %q", test.id, test.want, requests)
+		}
+	}
+}
+
+func TestFormFileInput(t *testing.T) {
+	withRuntime(t)
+	dir := t.TempDir()
+	for name, content := range map[string]string{"markup.txt": "from markup", "script.txt": "from script", "chosen.txt": "chosen"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := fmt.Sprintf(`<form action=/submit method=post enctype=multipart/form-data>`+
+		`<input type=file name=f value=%q></form>`, filepath.Join(dir, "markup.txt"))
+	var payloads []string
+	fetcher := fetcherFunc(func(ctx context.Context, url *u.URL, referrer *u.URL, payload *u.Payload) (*u.Response, error) {
+		if payload != nil {
+			payloads = append(payloads, payload.Body)
+		}
+		return &u.Response{URL: url, Status: 200, Reason: "OK", Headers: map[string]string{}, Body: []byte(page)}, nil
+	})
+	tab := newTestTab(t, fetcher)
+	submit := func() string {
+		payloads = nil
+		runTask(tab, func() { tab.root_frame.submit_form(findElement(tab.root_frame, "form"), nil) })
+		waitForLoads(tab)
+		return strings.Join(payloads, "")
+	}
+
+	loadPage(tab, mustURL(t, "http://example.org/"))
+	evalScript(t, tab, fmt.Sprintf(`window.document.querySelectorAll("input")[0].setAttribute("value", %q)`, filepath.Join(dir, "script.txt")))
+	if payload := submit(); strings.Contains(payload, "from markup") || strings.Contains(payload, "from script") {
+		t.Errorf("Expected files named by the page not to be uploaded, got %q", payload)
+	}
+
+	runTask(tab, func() {
+		frame := tab.root_frame
+		frame.focus_element(findElement(frame, "input"))
+		for _, char := range filepath.Join(dir, "chosen.txt") {
+			frame.keypress(char)
+		}
+	})
+	if payload := submit(); !strings.Contains(payload, "chosen") {
+		t.Errorf("Expected the file the user typed to be uploaded, got %q", payload)
+	}
+}
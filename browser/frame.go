@@ -1,12 +1,11 @@
 package browser
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"gowser/rect"
-	"gowser/task"
 	u "gowser/url"
-	"image"
 	"math"
 	"slices"
 	"sort"
@@ -28,13 +27,27 @@ type Frame struct {
 	scroll                  float64
 	scroll_changed_in_frame bool
 	needs_focus_scroll      bool
+	needs_fragment_scroll   bool
 	zoom                    float64
 	Nodes                   *HtmlNode
 	rules                   []Rule
 	url                     *u.URL
 	js                      *JSContext
 	Loaded                  bool
-	allowed_origins         []string
+	csp                     *ContentSecurityPolicy
+	origin                  string               // of the document, see document_origin
+	sheet_rules             map[*HtmlNode][]Rule // by link or style element
+	cert_error_host         string
+	resubmit_payload        *u.Payload
+	error_page              bool // the document is one of our error pages
+	response                *u.Response
+	encoding                string // of the document, also used for its subresources
+	restored_scroll         float64
+	needs_scroll_restore    bool
+
+	// cancelled when the frame navigates or its tab closes
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	frame_width  float64
 	frame_height float64
@@ -49,198 +62,115 @@ func NewFrame(tab *Tab, parent_frame *Frame, frame_element *HtmlNode) *Frame {
 		scroll:        0,
 		zoom:          1.0,
 	}
-	frame.window_id = len(frame.tab.window_id_to_frame)
+	frame.window_id = tab.next_window_id
+	tab.next_window_id++
 	frame.tab.window_id_to_frame[frame.window_id] = frame
 	return frame
 }
 
-func (f *Frame) Load(url *u.URL, payload string) {
-	f.Loaded = false
-	f.zoom = 1.0
-	f.scroll = 0
-	f.scroll_changed_in_frame = true
+func (f *Frame) Load(url *u.URL, payload *u.Payload) {
+	f.start_loading()
 	fmt.Println("Requesting URL:", url)
 	start := time.Now()
-	headers, body, err := url.Request(f.url, payload)
-	if err != nil {
-		fmt.Println("Request failed: " + err.Error())
+	request_url := url
+	if source := url.ViewSource(); source != nil {
+		request_url = source
+	}
+	response, err := f.tab.browser.Fetcher.Fetch(f.ctx, request_url, f.url, payload)
+	if err == nil && request_url != url {
+		response = NewViewSourcePage(url, response)
+	}
+	response = f.handle_load_error(url, response, err)
+	if response == nil {
 		return
 	}
 	fmt.Println("Request took:", time.Since(start))
+	f.load_response(response)
+}
 
+// start_loading cancels all loads of the current document and of the
+// frames inside it, and makes a fresh context for the next one.
+func (f *Frame) start_loading() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+	parent_ctx := f.tab.load_context()
+	if f.parent_frame != nil {
+		parent_ctx = f.parent_frame.ctx
+	}
+	f.ctx, f.cancel = context.WithCancel(parent_ctx)
+	f.Loaded = false
+	f.resubmit_payload = nil
+	f.error_page = false
+}
+
+// handle_load_error turns failed loads and HTTP errors into an error
+// page, and returns nil if the load was cancelled.
+func (f *Frame) handle_load_error(url *u.URL, response *u.Response, err error) *u.Response {
+	f.cert_error_host = ""
+	var cert_err *u.CertificateError
+	if f.ctx.Err() != nil {
+		// navigated away in the meantime
+		return nil
+	} else if errors.As(err, &cert_err) {
+		fmt.Println("Request failed: " + err.Error())
+		response = NewCertErrorPage(url, cert_err)
+		f.cert_error_host = cert_err.Host
+		f.error_page = true
+	} else if err != nil {
+		fmt.Println("Request failed: " + err.Error())
+		response = NewLoadErrorPage(url, err)
+		f.error_page = true
+	} else if response.Status >= 400 && len(response.Body) == 0 {
+		// servers usually send a page explaining the error, show ours otherwise
+		fmt.Println("Request failed:", response.Status, response.Reason)
+		response = NewHTTPErrorPage(response)
+		f.error_page = true
+	}
+	return response
+}
+
+func (f *Frame) load_response(response *u.Response) {
+	f.zoom = 1.0
+	f.scroll = 0
+	f.scroll_changed_in_frame = true
+	url := response.URL
+	headers, body := response.Headers, response.Body
 	f.url = url
+	f.response = response
+	f.needs_fragment_scroll = url.HasFragment()
 
-	f.allowed_origins = nil
-	if val, ok := headers["content-security-policy"]; ok {
-		csp := strings.Fields(val)
-		if len(csp) > 0 && csp[0] == "default-src" {
-			f.allowed_origins = make([]string, 0)
-			for _, origin := range csp[1:] {
-				new_url, err := u.NewURL(origin)
-				if err != nil {
-					fmt.Println("Invalid URL: " + err.Error())
-					continue
-				}
-				f.allowed_origins = append(f.allowed_origins, new_url.Origin())
-			}
-		}
-	}
+	f.origin = document_origin(url)
+	f.csp = NewContentSecurityPolicy(headers["content-security-policy"], f.origin)
 
-	start = time.Now()
-	f.Nodes = NewHTMLParser(string(body)).Parse()
+	f.teardown_frames()
+	start := time.Now()
+	var text string
+	text, f.encoding = decode_document(headers, body)
+	f.Nodes = NewHTMLParser(text).Parse()
 	if PRINT_HTML_TREE {
 		f.Nodes.PrintTree(0)
 	}
 	fmt.Println("Parsing took:", time.Since(start))
 
-	start = time.Now()
 	if f.js != nil {
 		f.js.Discarded = true
 	}
-	f.js = f.tab.get_js(url)
+	f.js = f.tab.get_js(f.origin)
 	f.js.AddWindow(f)
-	scripts := f.scripts(f.Nodes)
-	for _, script := range scripts {
-		script_url, err := url.Resolve(script)
-		if err != nil {
-			fmt.Println("Resolving URL failed:", err.Error())
-			continue
-		}
-		if !f.allowed_request(script_url) {
-			fmt.Println("Blocked script", script_url, "due to CSP")
-			continue
-		}
-		fmt.Println("Loading script:", script_url)
-		_, code, err := script_url.Request(url, "")
-		if err != nil {
-			fmt.Println("Error loading script:", err)
-		} else {
-			task := task.NewTask(func(i ...interface{}) {
-				start := time.Now()
-				f.tab.browser.measure.Time("eval_" + script)
-				f.js.Run(script, string(code), f.window_id)
-				f.tab.browser.measure.Stop("eval_" + script)
-				fmt.Println("Eval "+script+" took:", time.Since(start))
-			}, script, code)
-			f.tab.TaskRunner.ScheduleTask(task)
-		}
-	}
-	fmt.Println("Loading scripts took:", time.Since(start))
 
-	start = time.Now()
-	f.rules = slices.Clone(DEFAULT_STYLE_SHEET)
-	links := f.links(f.Nodes)
-	for _, link := range links {
-		style_url, err := url.Resolve(link)
-		if err != nil {
-			fmt.Println("Resolving URL failed:", err.Error())
-			continue
-		}
-		if !f.allowed_request(style_url) {
-			fmt.Println("Blocked stylesheet", style_url, "due to CSP")
-			continue
-		}
-		fmt.Println("Loading stylesheet:", style_url)
-		_, style_body, err := style_url.Request(url, "")
-		if err != nil {
-			fmt.Println("Error loading stylesheet:", err)
-		} else {
-			f.rules = append(f.rules, NewCSSParser(string(style_body)).Parse()...)
-		}
-	}
-	fmt.Println("Loading stylesheets took:", time.Since(start))
-
-	start = time.Now()
-	images := f.images(f.Nodes)
-	for _, img := range images {
-		elt, _ := img.Token.(ElementToken)
-		src := elt.Attributes["src"]
-		image_url, err := url.Resolve(src)
-		if err != nil {
-			fmt.Println("Resolving URL failed:", err.Error())
-			continue
-		}
-		if !f.allowed_request(image_url) {
-			fmt.Println("Blocked image", image_url, "due to CSP")
-			continue
-		}
-		fmt.Println("Loading image:", image_url)
-		_, img_body, err := image_url.Request(url, "")
-		if err != nil {
-			fmt.Println("Error loading image:", err)
-			img.Image = BROKEN_IMAGE
-		} else {
-			image, _, err := image.Decode(bytes.NewReader(img_body))
-			if err != nil {
-				fmt.Println("Error decoding image:", err)
-				img.Image = BROKEN_IMAGE
-			} else {
-				img.Image = image
-			}
-		}
-	}
-	fmt.Println("Loading images took:", time.Since(start))
-
-	start = time.Now()
-	iframes := f.frames(f.Nodes)
-	for _, iframe := range iframes {
-		elt, _ := iframe.Token.(ElementToken)
-		src := elt.Attributes["src"]
-		iframe_url, err := url.Resolve(src)
-		if err != nil {
-			fmt.Println("Resolving URL failed:", err.Error())
-			continue
-		}
-		if !f.allowed_request(iframe_url) {
-			fmt.Println("Blocked iframe", iframe_url, "due to CSP")
-			iframe.Frame = nil
-			continue
-		}
-		iframe.Frame = NewFrame(f.tab, f, iframe)
-
-		task := task.NewTask(func(i ...interface{}) {
-			fmt.Println("Loading iframe:", iframe_url)
-			iframe.Frame.Load(iframe_url, "")
-		}, iframe_url)
-		f.tab.TaskRunner.ScheduleTask(task)
-	}
-	fmt.Println("Loading iframes took:", time.Since(start))
+	f.sheet_rules = map[*HtmlNode][]Rule{}
+	f.update_rules()
+	f.load_subresources(f.Nodes)
 
 	f.Document = NewLayoutNode(NewDocumentLayout(), f.Nodes, nil, nil, f)
 	f.SetNeedsRender()
 	f.Loaded = true
-}
-
-func (f *Frame) allowed_request(url *u.URL) bool {
-	return f.allowed_origins == nil || slices.Contains(f.allowed_origins, url.Origin())
-}
-
-func (f *Frame) scripts(nodes *HtmlNode) []string {
-	flatNodes := TreeToList(nodes)
-	links := []string{}
-	for _, node := range flatNodes {
-		if element, ok := node.Token.(ElementToken); ok && element.Tag == "script" {
-			if src, exists := element.Attributes["src"]; exists {
-				links = append(links, src)
-			}
-		}
-	}
-	return links
-}
 
-func (f *Frame) links(nodes *HtmlNode) []string {
-	flatNodes := TreeToList(nodes)
-	links := []string{}
-	for _, node := range flatNodes {
-		if element, ok := node.Token.(ElementToken); ok && element.Tag == "link" {
-			if rel, exists := element.Attributes["rel"]; exists && rel == "stylesheet" {
-				if href, exists := element.Attributes["href"]; exists {
-					links = append(links, href)
-				}
-			}
-		}
+	if entry := f.tab.restoring_entry(f); entry != nil && entry.url != nil &&
+		entry.url.WithoutFragment().String() == url.WithoutFragment().String() {
+		f.restore_state(entry)
 	}
-	return links
 }
 
 func (f *Frame) images(nodes *HtmlNode) []*HtmlNode {
@@ -407,66 +337,123 @@ func (f *Frame) advance_tab() {
 
 func (f *Frame) activate_element(node *HtmlNode) {
 	elt, _ := node.Token.(ElementToken)
-	if elt.Tag == "input" {
-		elt.Attributes["value"] = ""
+	if is_text_input(node) {
+		set_input_value(node, "")
+		f.SetNeedsRender()
+	} else if is_input(node, "checkbox") {
+		set_checked(node, !is_checked(node))
+		f.SetNeedsRender()
+	} else if is_input(node, "radio") {
+		set_checked(node, true)
+		f.SetNeedsRender()
+	} else if elt.Tag == "select" {
+		cycle_option(node, "")
 		f.SetNeedsRender()
 	} else if elt.Tag == "a" && elt.Attributes["href"] != "" {
 		url, err := f.url.Resolve(elt.Attributes["href"])
 		if err != nil {
 			fmt.Println("Resolving URL failed:", err.Error())
+		} else if f.cert_error_host != "" && elt.Attributes["id"] == "proceed" {
+			// the page takes the place of the interstitial in history
+			u.AddCertException(f.cert_error_host)
+			f.Load(url, nil)
+			f.tab.update_history_entry(f)
+		} else if f.resubmit_payload != nil && elt.Attributes["id"] == "resubmit" {
+			f.Load(url, f.resubmit_payload)
+			f.tab.update_history_entry(f)
 		} else {
-			f.Load(url, "")
+			f.navigate(url, nil)
 		}
-	} else if elt.Tag == "button" {
-		for node != nil {
-			elt, _ := node.Token.(ElementToken)
-			if elt.Tag == "form" && elt.Attributes["action"] != "" {
-				f.submit_form(node)
-			}
-			node = node.Parent
+	} else if is_submit_button(node) {
+		if form := form_of(node); form != nil && !is_disabled(node) {
+			f.submit_form(form, node)
 		}
 	}
 }
 
-func (f *Frame) submit_form(elt *HtmlNode) {
-	if f.js.DispatchEvent("submit", elt, f.window_id) {
-		return
-	}
-	var inputs []*ElementToken
-	for _, node := range TreeToList(elt) {
-		if element, ok := node.Token.(ElementToken); ok && element.Tag == "input" && element.Attributes["name"] != "" {
-			inputs = append(inputs, &element)
-		}
-	}
+// is_fragment_navigation tells whether going to url only moves within the
+// current document, which does not need to be fetched again.
+func (f *Frame) is_fragment_navigation(url *u.URL) bool {
+	return f.url != nil && url.HasFragment() && url.WithoutFragment().String() == f.url.WithoutFragment().String()
+}
 
-	var body string
-	for _, input := range inputs {
-		name := input.Attributes["name"]
-		value := input.Attributes["value"]
-		name = urllib.QueryEscape(name)
-		value = urllib.QueryEscape(value)
-		body += "&" + name + "=" + value
+// navigate loads url in this frame as a new history entry.
+func (f *Frame) navigate(url *u.URL, payload *u.Payload) {
+	if payload == nil && f.is_fragment_navigation(url) {
+		f.tab.navigate_to_fragment(f, url)
+	} else if f == f.tab.root_frame {
+		f.tab.Load(url, payload)
+	} else {
+		f.tab.navigate_frame(f, url, payload)
 	}
-	body = body[1:]
+}
 
-	url, err := f.url.Resolve(elt.Token.(ElementToken).Attributes["action"])
+// submit_form submits form as its method and enctype say. submitter is
+// the button it was submitted with, if any.
+func (f *Frame) submit_form(form *HtmlNode, submitter *HtmlNode) {
+	if f.js.DispatchEvent("submit", form, f.window_id) {
+		return
+	}
+	attributes := form.Token.(ElementToken).Attributes
+	url, err := f.url.Resolve(attributes["action"])
 	if err != nil {
 		fmt.Println("Resolving URL failed:", err.Error())
-	} else {
-		f.Load(url, body)
+		return
+	}
+	entries := form_entries(form, submitter)
+	if strings.ToLower(attributes["method"]) == "get" {
+		f.navigate(url.WithQuery(encode_urlencoded(entries)), nil)
+		return
+	}
+	payload, err := encode_form(entries, attributes["enctype"])
+	if err != nil {
+		fmt.Println("Submitting form failed:", err.Error())
+		return
+	}
+	f.navigate(url, payload)
+}
+
+// implicit_submit submits the form of node the way pressing Enter in one
+// of its fields does, by clicking its default button if it has one.
+func (f *Frame) implicit_submit(node *HtmlNode) {
+	form := form_of(node)
+	if form == nil {
+		return
+	}
+	if button := default_button(form); button == nil {
+		f.submit_form(form, nil)
+	} else if !f.js.DispatchEvent("click", button, f.window_id) {
+		f.activate_element(button)
 	}
 }
 
 func (f *Frame) keypress(char rune) {
-	if f.tab.focus != nil && f.tab.focus.Token.(ElementToken).Tag == "input" {
-		if _, ok := f.tab.focus.Token.(ElementToken).Attributes["value"]; !ok {
+	if f.tab.focus != nil && is_text_input(f.tab.focus) {
+		if clears_on_first_key(f.tab.focus) {
 			f.activate_element(f.tab.focus)
 		}
 		if f.js.DispatchEvent("keydown", f.tab.focus, f.window_id) {
 			return
 		}
-		f.tab.focus.Token.(ElementToken).Attributes["value"] += string(char)
+		set_input_value(f.tab.focus, input_value(f.tab.focus)+string(char))
 		f.SetNeedsRender()
+	} else if f.tab.focus != nil && is_element(f.tab.focus, "textarea") {
+		if f.js.DispatchEvent("keydown", f.tab.focus, f.window_id) {
+			return
+		}
+		set_textarea_value(f.tab.focus, textarea_value(f.tab.focus)+string(char))
+		f.SetNeedsRender()
+	} else if f.tab.focus != nil && (is_checkable(f.tab.focus) || is_element(f.tab.focus, "select")) {
+		if f.js.DispatchEvent("keydown", f.tab.focus, f.window_id) {
+			return
+		}
+		if char == ' ' {
+			f.activate_element(f.tab.focus)
+		} else if is_element(f.tab.focus, "select") {
+			// typing picks an option by its first letter
+			cycle_option(f.tab.focus, string(char))
+			f.SetNeedsRender()
+		}
 	} else if f.tab.focus != nil && f.tab.focus.Token.(ElementToken).Attributes["contenteditable"] != "" {
 		text_nodes := []*HtmlNode{}
 		for _, t := range TreeToList(f.tab.focus) {
@@ -496,15 +483,24 @@ func (f *Frame) keypress(char rune) {
 }
 
 func (f *Frame) backspace() {
-	if f.tab.focus != nil && f.tab.focus.Token.(ElementToken).Tag == "input" {
-		if _, ok := f.tab.focus.Token.(ElementToken).Attributes["value"]; !ok {
+	if f.tab.focus != nil && is_text_input(f.tab.focus) {
+		if clears_on_first_key(f.tab.focus) {
 			f.activate_element(f.tab.focus)
 		}
 		if f.js.DispatchEvent("keydown", f.tab.focus, f.window_id) {
 			return
 		}
-		if len(f.tab.focus.Token.(ElementToken).Attributes["value"]) > 0 {
-			f.tab.focus.Token.(ElementToken).Attributes["value"] = f.tab.focus.Token.(ElementToken).Attributes["value"][:len(f.tab.focus.Token.(ElementToken).Attributes["value"])-1]
+		if value := input_value(f.tab.focus); len(value) > 0 {
+			set_input_value(f.tab.focus, value[:len(value)-1])
+		}
+		f.SetNeedsRender()
+	} else if f.tab.focus != nil && is_element(f.tab.focus, "textarea") {
+		if f.js.DispatchEvent("keydown", f.tab.focus, f.window_id) {
+			return
+		}
+		value := []rune(textarea_value(f.tab.focus))
+		if len(value) > 0 {
+			set_textarea_value(f.tab.focus, string(value[:len(value)-1]))
 		}
 		f.SetNeedsRender()
 	} else if f.tab.focus != nil && f.tab.focus.Token.(ElementToken).Attributes["contenteditable"] != "" {
@@ -537,6 +533,18 @@ func (f *Frame) backspace() {
 	}
 }
 
+// enter handles the Enter key, which starts a new line in a textarea,
+// submits the form of a text field and activates everything else.
+func (f *Frame) enter() {
+	if is_element(f.tab.focus, "textarea") {
+		f.keypress('\n')
+	} else if is_text_input(f.tab.focus) {
+		f.implicit_submit(f.tab.focus)
+	} else {
+		f.activate_element(f.tab.focus)
+	}
+}
+
 func (f *Frame) clamp_scroll(scroll float64) float64 {
 	height := math.Ceil(f.Document.Height.Get() + 2*VSTEP)
 	maxscroll := height - f.frame_height
@@ -576,3 +584,57 @@ func (f *Frame) scroll_to(elt *HtmlNode) {
 	f.scroll_changed_in_frame = true
 	f.tab.SetNeedsPaint()
 }
+
+// scroll_to_fragment scrolls to the element the fragment of the frame's
+// URL names, or to the top for an empty fragment or "#top".
+func (f *Frame) scroll_to_fragment() {
+	f.needs_fragment_scroll = false
+	fragment, err := urllib.PathUnescape(f.url.Fragment())
+	if err != nil {
+		fragment = f.url.Fragment()
+	}
+	new_scroll := 0.
+	if target := find_fragment_target(f.Nodes, fragment); target != nil {
+		found := false
+		// inline elements have no layout object of their own, use their first word
+		for _, obj := range LayoutTreeToList(f.Document) {
+			for node := obj.Node; node != nil && !found; node = node.Parent {
+				found = node == target
+			}
+			if found {
+				new_scroll = obj.Y.Get()
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	} else if fragment != "" && !strings.EqualFold(fragment, "top") {
+		return
+	}
+	f.scroll = f.clamp_scroll(new_scroll)
+	f.scroll_changed_in_frame = true
+	f.tab.SetNeedsPaint()
+}
+
+// find_fragment_target finds the element with the given id, or else the
+// first a element with that name.
+func find_fragment_target(nodes *HtmlNode, fragment string) *HtmlNode {
+	if fragment == "" {
+		return nil
+	}
+	var named *HtmlNode
+	for _, node := range TreeToList(nodes) {
+		elt, ok := node.Token.(ElementToken)
+		if !ok {
+			continue
+		}
+		if elt.Attributes["id"] == fragment {
+			return node
+		}
+		if named == nil && elt.Tag == "a" && elt.Attributes["name"] == fragment {
+			named = node
+		}
+	}
+	return named
+}
@@ -0,0 +1,631 @@
+package browser
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gowser/task"
+	"gowser/trace"
+	u "gowser/url"
+	"image"
+	"image/png"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fetcherFunc func(ctx context.Context, url *u.URL, referrer *u.URL, payload *u.Payload) (*u.Response, error)
+
+func (f fetcherFunc) Fetch(ctx context.Context, url *u.URL, referrer *u.URL, payload *u.Payload) (*u.Response, error) {
+	return f(ctx, url, referrer, payload)
+}
+
+// newTestTab returns a tab of a browser without a window that loads
+// everything through fetcher.
+func newTestTab(t *testing.T, fetcher u.Fetcher) *Tab {
+	t.Chdir(t.TempDir()) // the trace file is written to the working directory
+	browser := &Browser{
+		Fetcher: fetcher,
+		measure: trace.NewMeasureTime(),
+		lock:    &sync.Mutex{},
+	}
+	tab := NewTab(browser, HEIGHT)
+	t.Cleanup(tab.Close)
+	return tab
+}
+
+// runTask runs fn on the tab's thread and waits for it.
+func runTask(tab *Tab, fn func()) {
+	done := make(chan bool)
+	tab.TaskRunner.ScheduleTask(task.NewTask(func(i ...interface{}) {
+		fn()
+		done <- true
+	}))
+	<-done
+}
+
+// loadPage loads url in tab and waits until all subresources are in.
+func loadPage(tab *Tab, url *u.URL) {
+	runTask(tab, func() { tab.Load(url, nil) })
+	waitForLoads(tab)
+}
+
+// waitForLoads waits until the loads the tab started are done.
+func waitForLoads(tab *Tab) {
+	for {
+		for tab.pending_loads.Load() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		// loaded resources may start new loads, e.g. iframes
+		runTask(tab, func() {})
+		if tab.pending_loads.Load() == 0 {
+			return
+		}
+	}
+}
+
+func mustURL(t *testing.T, s string) *u.URL {
+	url, err := u.NewURL(s)
+	if err != nil {
+		t.Fatalf("Invalid URL %s: %s", s, err)
+	}
+	return url
+}
+
+func TestFrameLoadSubresources(t *testing.T) {
+	var png_data bytes.Buffer
+	png.Encode(&png_data, image.NewRGBA(image.Rect(0, 0, 2, 3)))
+
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html",
+		`<link rel=stylesheet href="style.css"><p>Hello</p><img src="/logo.png">`)
+	fetcher.Add("http://example.org/style.css", "text/css", "p { color: red; }")
+	fetcher.Add("http://example.org/logo.png", "image/png", png_data.String())
+
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	// subresources load in parallel
+	requests := slices.Sorted(slices.Values(fetcher.Requests()))
+	expected := []string{"GET http://example.org/", "GET http://example.org/logo.png", "GET http://example.org/style.css"}
+	if !slices.Equal(requests, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, requests)
+	}
+	frame := tab.root_frame
+	if !frame.Loaded {
+		t.Fatal("Expected frame to be loaded")
+	}
+	if len(frame.rules) != len(DEFAULT_STYLE_SHEET)+1 {
+		t.Errorf("Expected stylesheet rule to be added, got %d rules", len(frame.rules))
+	}
+	images := frame.images(frame.Nodes)
+	if len(images) != 1 || images[0].Image == nil || images[0].Image.Bounds().Dy() != 3 {
+		t.Errorf("Expected image to be decoded, got %v", images)
+	}
+}
+
+func TestFrameLoadBrokenImage(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<img src="missing.png">`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	images := tab.root_frame.images(tab.root_frame.Nodes)
+	if len(images) != 1 || images[0].Image != BROKEN_IMAGE {
+		t.Errorf("Expected broken image for failed load")
+	}
+}
+
+func TestFrameLoadCSP(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.AddExchange(&u.Exchange{
+		Method:  "GET",
+		URL:     "http://example.org/",
+		Status:  200,
+		Headers: map[string]string{"content-security-policy": "default-src http://example.org"},
+		Body:    []byte(`<script src="http://evil.org/x.js"></script><link rel=stylesheet href="a.css">`),
+	})
+	fetcher.Add("http://example.org/a.css", "text/css", "")
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	expected := []string{"GET http://example.org/", "GET http://example.org/a.css"}
+	if !slices.Equal(fetcher.Requests(), expected) {
+		t.Errorf("Expected cross-origin script to be blocked, got %v", fetcher.Requests())
+	}
+}
+
+func evalScript(t *testing.T, tab *Tab, code string) string {
+	var result string
+	runTask(tab, func() {
+		var err error
+		if result, err = tab.root_frame.js.Run("test", code, tab.root_frame.window_id); err != nil {
+			t.Fatalf("Evaluating %s failed: %v", code, err)
+		}
+	})
+	return result
+}
+
+func TestFrameInlineScripts(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<script>window.order = "a"</script>`+
+		`<script src="b.js"></script><script>if (1 < 2) { window.order += "c" }</script>`)
+	fetcher.Add("http://example.org/b.js", "text/javascript", `window.order += "b"`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	if order := evalScript(t, tab, "window.order"); order != "abc" {
+		t.Errorf("Expected inline and external scripts to run in document order, got %s", order)
+	}
+}
+
+func TestFrameScriptTypes(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<script type="text/JavaScript ">window.ran = "a"</script>`+
+		`<script type=application/json>window.ran += "b"</script><script type=module src="c.js"></script>`+
+		`<script type=text/template src="d.js"></script><script type="" src="e.js"></script>`)
+	fetcher.Add("http://example.org/e.js", "text/javascript", `window.ran += "e"`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	if ran := evalScript(t, tab, "window.ran"); ran != "ae" {
+		t.Errorf("Expected only JavaScript to run, got %s", ran)
+	}
+	expected := []string{"GET http://example.org/", "GET http://example.org/e.js"}
+	if !slices.Equal(fetcher.Requests(), expected) {
+		t.Errorf("Expected scripts of other types not to be fetched, got %v", fetcher.Requests())
+	}
+}
+
+func TestFrameInlineStyles(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<style>p { color: green }</style>`+
+		`<link rel=stylesheet href="a.css"><style>p { color: blue }</style>`)
+	fetcher.Add("http://example.org/a.css", "text/css", `p { color: red }`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	colors := []string{}
+	for _, rule := range tab.root_frame.rules[len(DEFAULT_STYLE_SHEET):] {
+		colors = append(colors, rule.Body["color"])
+	}
+	if !slices.Equal(colors, []string{"green", "red", "blue"}) {
+		t.Errorf("Expected style sheets in document order, got %v", colors)
+	}
+}
+
+func TestFrameCSPNonces(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.AddExchange(&u.Exchange{
+		Method:  "GET",
+		URL:     "http://example.org/",
+		Status:  200,
+		Headers: map[string]string{"content-security-policy": "default-src 'self'; script-src 'nonce-r4nd' 'unsafe-inline'"},
+		Body: []byte(`<script nonce=r4nd>window.ran = "a"</script><script>window.ran += "b"</script>` +
+			`<script nonce=r4nd src="http://cdn.test/c.js"></script><script src="d.js"></script>` +
+			`<style>p { color: red }</style><link rel=stylesheet href="e.css">`),
+	})
+	fetcher.Add("http://cdn.test/c.js", "text/javascript", `window.ran += "c"`)
+	fetcher.Add("http://example.org/e.css", "text/css", `p { color: blue }`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	if ran := evalScript(t, tab, "window.ran"); ran != "ac" {
+		t.Errorf("Expected only scripts with the nonce to run, got %s", ran)
+	}
+	// subresources load in parallel
+	requests := slices.Sorted(slices.Values(fetcher.Requests()))
+	expected := []string{"GET http://cdn.test/c.js", "GET http://example.org/", "GET http://example.org/e.css"}
+	if !slices.Equal(requests, expected) {
+		t.Errorf("Expected d.js to be blocked, got %v", requests)
+	}
+	rules := tab.root_frame.rules[len(DEFAULT_STYLE_SHEET):]
+	if len(rules) != 1 || rules[0].Body["color"] != "blue" {
+		t.Errorf("Expected the inline style sheet to be blocked by default-src, got %v", rules)
+	}
+}
+
+// setInnerHTML sets the innerHTML of the element with id from a script
+// and waits for what it loads.
+func setInnerHTML(t *testing.T, tab *Tab, id string, html string) {
+	frame := tab.root_frame
+	var elt *HtmlNode
+	for _, node := range TreeToList(frame.Nodes) {
+		if element, ok := node.Token.(ElementToken); ok && element.Attributes["id"] == id {
+			elt = node
+		}
+	}
+	runTask(tab, func() {
+		frame.Render()
+		handle := frame.js.get_handle(elt)
+		if _, err := frame.js.Run("test", fmt.Sprintf("_innerHTML_set(%d, %q, %d)", handle, html, frame.window_id), frame.window_id); err != nil {
+			t.Fatalf("Setting innerHTML failed: %v", err)
+		}
+	})
+	waitForLoads(tab)
+}
+
+func TestInnerHTMLLoadsSubresources(t *testing.T) {
+	var png_data bytes.Buffer
+	png.Encode(&png_data, image.NewRGBA(image.Rect(0, 0, 2, 3)))
+
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<div id=content></div><script id=s>window.ran = "s"</script>`)
+	fetcher.Add("http://example.org/a.js", "text/javascript", `window.ran += "a"`)
+	fetcher.Add("http://example.org/b.css", "text/css", `p { color: red }`)
+	fetcher.Add("http://example.org/c.png", "image/png", png_data.String())
+	fetcher.Add("http://example.org/d.html", "text/html", `<p>framed</p>`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	setInnerHTML(t, tab, "content", `<script src="a.js"></script><script>window.ran += "b"</script>`+
+		`<link rel=stylesheet href="b.css"><img src="c.png"><iframe src="d.html"></iframe>`)
+
+	requests := slices.Sorted(slices.Values(fetcher.Requests()))
+	expected := []string{"GET http://example.org/", "GET http://example.org/b.css",
+		"GET http://example.org/c.png", "GET http://example.org/d.html"}
+	if !slices.Equal(requests, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, requests)
+	}
+	frame := tab.root_frame
+	// neither scripts inserted with innerHTML nor the one it is set on run
+	setInnerHTML(t, tab, "s", `window.ran += "x"`)
+	if ran := evalScript(t, tab, "window.ran"); ran != "s" {
+		t.Errorf("Expected only the script of the page to run, got %s", ran)
+	}
+	if len(frame.rules) != len(DEFAULT_STYLE_SHEET)+1 {
+		t.Errorf("Expected inserted style sheet to apply, got %d rules", len(frame.rules))
+	}
+	images := frame.images(frame.Nodes)
+	if len(images) != 1 || images[0].Image == nil || images[0].Image.Bounds().Dy() != 3 {
+		t.Errorf("Expected inserted image to be decoded, got %v", images)
+	}
+	iframes := frame.frames(frame.Nodes)
+	if len(iframes) != 1 || iframes[0].Frame == nil || documentText(iframes[0].Frame) != "framed" {
+		t.Fatalf("Expected inserted iframe to be loaded")
+	}
+
+	child := iframes[0].Frame
+	setInnerHTML(t, tab, "content", `<p>gone</p>`)
+	if _, ok := tab.window_id_to_frame[child.window_id]; ok || child.Loaded {
+		t.Error("Expected removed iframe to be torn down")
+	}
+	if len(frame.rules) != len(DEFAULT_STYLE_SHEET) {
+		t.Errorf("Expected removed style sheet to no longer apply, got %d rules", len(frame.rules))
+	}
+}
+
+func TestTabLoadTearsDownFrames(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<iframe src="a.html"></iframe>`)
+	fetcher.Add("http://example.org/a.html", "text/html", `<iframe src="b.html"></iframe>`)
+	fetcher.Add("http://example.org/b.html", "text/html", `<p>b</p>`)
+	fetcher.Add("http://example.org/c.html", "text/html", `<p>c</p>`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+	if len(tab.window_id_to_frame) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(tab.window_id_to_frame))
+	}
+
+	loadPage(tab, mustURL(t, "http://example.org/c.html"))
+	if len(tab.window_id_to_frame) != 1 || tab.window_id_to_frame[tab.root_frame.window_id] != tab.root_frame {
+		t.Errorf("Expected only the new root frame, got %v", tab.window_id_to_frame)
+	}
+}
+
+func TestTabLoadRedirect(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.AddExchange(&u.Exchange{
+		Method:   "GET",
+		URL:      "http://example.org/old",
+		FinalURL: "http://example.org/new",
+		Status:   200,
+		Body:     []byte("<p>moved</p>"),
+	})
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/old"))
+
+	if tab.url.String() != "http://example.org/new" {
+		t.Errorf("Expected tab URL to be the redirect target, got %s", tab.url)
+	}
+	if len(tab.history.entries) != 1 || tab.history.entries[0].url.String() != "http://example.org/new" {
+		t.Errorf("Expected history to hold the redirect target, got %v", tab.history.entries)
+	}
+}
+
+func TestCertErrorInterstitial(t *testing.T) {
+	page := &u.Response{Status: 200, Headers: map[string]string{}, Body: []byte("<p>secret</p>")}
+	tab := newTestTab(t, fetcherFunc(func(ctx context.Context, url *u.URL, referrer *u.URL, payload *u.Payload) (*u.Response, error) {
+		if !u.HasCertException("self-signed.test") {
+			return nil, &u.CertificateError{Host: "self-signed.test", Err: image.ErrFormat}
+		}
+		page.URL = url
+		return page, nil
+	}))
+	loadPage(tab, mustURL(t, "https://self-signed.test/"))
+
+	frame := tab.root_frame
+	if frame.cert_error_host != "self-signed.test" {
+		t.Fatalf("Expected interstitial for self-signed.test, got '%s'", frame.cert_error_host)
+	}
+	var proceed *HtmlNode
+	for _, node := range TreeToList(frame.Nodes) {
+		if elt, ok := node.Token.(ElementToken); ok && elt.Attributes["id"] == "proceed" {
+			proceed = node
+		}
+	}
+	if proceed == nil {
+		t.Fatal("Expected a proceed link on the interstitial")
+	}
+
+	runTask(tab, func() { frame.activate_element(proceed) })
+	if frame.cert_error_host != "" {
+		t.Error("Expected page to load after proceeding")
+	}
+	var text []string
+	for _, node := range TreeToList(frame.Nodes) {
+		if token, ok := node.Token.(TextToken); ok {
+			text = append(text, token.Text)
+		}
+	}
+	if !strings.Contains(strings.Join(text, ""), "secret") {
+		t.Errorf("Expected page content after proceeding, got %v", text)
+	}
+}
+
+func TestFrameLoadParallel(t *testing.T) {
+	started := &sync.WaitGroup{}
+	started.Add(2)
+	all_started := make(chan bool)
+	go func() {
+		started.Wait()
+		close(all_started)
+	}()
+	tab := newTestTab(t, fetcherFunc(func(ctx context.Context, url *u.URL, referrer *u.URL, payload *u.Payload) (*u.Response, error) {
+		body := ""
+		if url.String() == "http://example.org/" {
+			body = `<link rel=stylesheet href=a.css><link rel=stylesheet href=b.css>`
+		} else {
+			// neither stylesheet finishes before both were requested
+			started.Done()
+			select {
+			case <-all_started:
+			case <-time.After(time.Second):
+				return nil, context.DeadlineExceeded
+			}
+			body = "p { color: red; }"
+		}
+		return &u.Response{URL: url, Status: 200, Headers: map[string]string{}, Body: []byte(body)}, nil
+	}))
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	if len(tab.root_frame.rules) != len(DEFAULT_STYLE_SHEET)+2 {
+		t.Errorf("Expected both stylesheets to load in parallel, got %d rules", len(tab.root_frame.rules))
+	}
+}
+
+func TestFrameNavigationCancelsLoads(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	tab := newTestTab(t, fetcherFunc(func(ctx context.Context, url *u.URL, referrer *u.URL, payload *u.Payload) (*u.Response, error) {
+		body := ""
+		switch url.String() {
+		case "http://example.org/slow":
+			body = `<link rel=stylesheet href=slow.css>`
+		case "http://example.org/slow.css":
+			select {
+			case <-ctx.Done():
+				cancelled <- true
+				return nil, ctx.Err()
+			case <-time.After(time.Second):
+				body = "p { color: red; }"
+			}
+		}
+		return &u.Response{URL: url, Status: 200, Headers: map[string]string{}, Body: []byte(body)}, nil
+	}))
+	runTask(tab, func() { tab.Load(mustURL(t, "http://example.org/slow"), nil) })
+	tab.CancelLoads()
+	loadPage(tab, mustURL(t, "http://example.org/fast"))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the stylesheet load to be cancelled")
+	}
+	if tab.url.String() != "http://example.org/fast" || len(tab.root_frame.rules) != len(DEFAULT_STYLE_SHEET) {
+		t.Errorf("Expected only the new page to be loaded, got %s with %d rules", tab.url, len(tab.root_frame.rules))
+	}
+}
+
+func TestFragmentNavigation(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html",
+		`<a href="#target">jump</a>`+strings.Repeat("<p>filler</p>", 100)+`<p id=target>Target</p>`+strings.Repeat("<p>filler</p>", 10))
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	frame := tab.root_frame
+	var link *HtmlNode
+	for _, node := range TreeToList(frame.Nodes) {
+		if elt, ok := node.Token.(ElementToken); ok && elt.Tag == "a" {
+			link = node
+		}
+	}
+	runTask(tab, func() {
+		frame.Render()
+		frame.activate_element(link)
+	})
+
+	if len(fetcher.Requests()) != 1 {
+		t.Errorf("Expected no refetch for a fragment link, got %v", fetcher.Requests())
+	}
+	if tab.url.String() != "http://example.org/#target" || len(tab.history.entries) != 2 {
+		t.Errorf("Expected a history entry for the fragment, got %s and %v", tab.url, tab.history.entries)
+	}
+	if !frame.needs_fragment_scroll {
+		t.Fatal("Expected a scroll to the fragment to be pending")
+	}
+	runTask(tab, func() { frame.scroll_to_fragment() })
+	if frame.scroll == 0 {
+		t.Error("Expected frame to scroll to the target")
+	}
+}
+
+func TestLoadWithFragment(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html",
+		strings.Repeat("<p>filler</p>", 100)+`<a name=anchor>Anchor</a>`+strings.Repeat("<p>filler</p>", 10))
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/#anchor"))
+
+	expected := []string{"GET http://example.org/"}
+	if !slices.Equal(fetcher.Requests(), expected) {
+		t.Errorf("Expected the fragment not to be requested, got %v", fetcher.Requests())
+	}
+	frame := tab.root_frame
+	if !frame.needs_fragment_scroll {
+		t.Fatal("Expected a scroll to the fragment to be pending")
+	}
+	runTask(tab, func() {
+		frame.Render()
+		frame.scroll_to_fragment()
+	})
+	if frame.scroll == 0 {
+		t.Error("Expected frame to scroll to the named anchor")
+	}
+}
+
+func documentText(frame *Frame) string {
+	var text []string
+	for _, node := range TreeToList(frame.Nodes) {
+		if token, ok := node.Token.(TextToken); ok {
+			text = append(text, token.Text)
+		}
+	}
+	return strings.Join(text, " ")
+}
+
+func TestFrameLoadErrorPages(t *testing.T) {
+	tests := []struct {
+		err      error
+		response *u.Response
+		expected string
+	}{
+		{&net.DNSError{Name: "nowhere.test", Err: "no such host", IsNotFound: true}, nil, "Server not found"},
+		{fmt.Errorf("failed to connect to host: %w", syscall.ECONNREFUSED), nil, "Connection refused"},
+		{context.DeadlineExceeded, nil, "Connection timed out"},
+		{fmt.Errorf("%w: remote error", u.ErrTLSHandshake), nil, "Secure connection failed"},
+		{nil, &u.Response{Status: 404, Reason: "Not Found", Headers: map[string]string{}}, "404 Not Found"},
+		{nil, &u.Response{Status: 500, Reason: "Internal Server Error", Headers: map[string]string{}, Body: []byte("<p>Oops</p>")}, "Oops"},
+	}
+	for _, test := range tests {
+		tab := newTestTab(t, fetcherFunc(func(ctx context.Context, url *u.URL, referrer *u.URL, payload *u.Payload) (*u.Response, error) {
+			if test.response != nil {
+				test.response.URL = url
+			}
+			return test.response, test.err
+		}))
+		loadPage(tab, mustURL(t, "http://example.org/"))
+		if !tab.root_frame.Loaded {
+			t.Errorf("Expected an error page for %v, but nothing was loaded", test.err)
+		} else if text := documentText(tab.root_frame); !strings.Contains(text, test.expected) {
+			t.Errorf("Expected '%s' on the error page, got '%s'", test.expected, text)
+		}
+		// going back to our error pages tries again, a server's page is kept
+		generated := test.response == nil || len(test.response.Body) == 0
+		if kept := tab.history.current().response != nil; kept == generated {
+			t.Errorf("Expected the error page for %v to be kept in history: %v", test.err, !generated)
+		}
+	}
+}
+
+func TestFrameLoadSubresourceStatus(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<link rel=stylesheet href="missing.css">`)
+	fetcher.AddExchange(&u.Exchange{Method: "GET", URL: "http://example.org/missing.css", Status: 404, Reason: "Not Found",
+		Body: []byte("p { color: red; }")})
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	if len(tab.root_frame.rules) != len(DEFAULT_STYLE_SHEET) {
+		t.Errorf("Expected the body of a 404 stylesheet to be ignored, got %d rules", len(tab.root_frame.rules))
+	}
+}
+
+func TestFrameCSPRedirect(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.AddExchange(&u.Exchange{
+		Method:  "GET",
+		URL:     "http://example.org/",
+		Status:  200,
+		Headers: map[string]string{"content-security-policy": "default-src 'self'"},
+		Body:    []byte(`<script src="a.js"></script><script src="b.js"></script>`),
+	})
+	fetcher.AddExchange(&u.Exchange{Method: "GET", URL: "http://example.org/a.js", FinalURL: "http://evil.org/a.js",
+		Status: 200, Body: []byte(`window.ran = "a"`)})
+	fetcher.AddExchange(&u.Exchange{Method: "GET", URL: "http://example.org/b.js", FinalURL: "http://example.org/c.js",
+		Status: 200, Body: []byte(`window.ran += "b"`)})
+	fetcher.AddExchange(&u.Exchange{Method: "GET", URL: "http://example.org/data", FinalURL: "http://evil.org/data",
+		Status: 200, Body: []byte("secret")})
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	if ran := evalScript(t, tab, "window.ran"); ran != "undefinedb" {
+		t.Errorf("Expected the script redirected to another origin to be blocked, got %s", ran)
+	}
+	runTask(tab, func() {
+		frame := tab.root_frame
+		if text := frame.js.xmlHttpRequest_send("GET", "/data", "", false, 0, frame.window_id); text != "" {
+			t.Errorf("Expected a cross-origin redirect of an XHR to be blocked, got %q", text)
+		}
+	})
+}
+
+func TestFrameOpaqueOrigins(t *testing.T) {
+	iframe := `<iframe src="data:text/html,<p>x</p>"></iframe>`
+	fetcher := fetcherFunc(func(ctx context.Context, url *u.URL, referrer *u.URL, payload *u.Payload) (*u.Response, error) {
+		if url.Scheme() == "data" {
+			return (&u.NetworkFetcher{}).Fetch(ctx, url, referrer, payload)
+		}
+		return &u.Response{URL: url, Status: 200, Reason: "OK", Headers: map[string]string{}, Body: []byte(iframe + iframe)}, nil
+	})
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	iframes := tab.root_frame.frames(tab.root_frame.Nodes)
+	if len(iframes) != 2 || iframes[0].Frame == nil || iframes[1].Frame == nil {
+		t.Fatal("Expected both iframes to be loaded")
+	}
+	a, b := iframes[0].Frame, iframes[1].Frame
+	if a.origin == b.origin || a.js == b.js {
+		t.Errorf("Expected documents from the same data: URL to have origins of their own, got %s", a.origin)
+	}
+	if same_origin(a.origin, a.url) {
+		t.Error("Expected an opaque origin not to equal the origin of its own URL")
+	}
+	runTask(tab, func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected scripting another data: document to be disallowed")
+			}
+		}()
+		a.js.throw_if_cross_origin(b)
+	})
+}
+
+func TestFrameInlineScriptBeforeLayout(t *testing.T) {
+	withRuntime(t)
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html",
+		`<p>hi</p><script>window.document.querySelectorAll("p")[0].setAttribute("title", "y")</script>`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	p := findElement(tab.root_frame, "p")
+	if title := p.Token.(ElementToken).Attributes["title"]; title != "y" {
+		t.Errorf("Expected the inline script to set title 'y', got '%s'", title)
+	}
+}
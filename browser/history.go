@@ -0,0 +1,366 @@
+package browser
+
+import (
+	u "gowser/url"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// how many entries around the current one keep their documents, the
+	// others load them again
+	MAX_HISTORY_RESPONSES = 20
+)
+
+// A HistoryEntry is one step in the session history of a tab. Loading a
+// page in the root frame starts a new document, navigating an iframe or
+// following a fragment link adds an entry for the same document.
+type HistoryEntry struct {
+	document int // entries of one document share the root frame
+	url      *u.URL
+	payload  *u.Payload
+	// the document as it was loaded, so that going back and forward
+	// neither fetches it again nor repeats a POST
+	response   *u.Response
+	scroll     float64
+	form_state []string
+	// the iframes of the document, by their frame path
+	frames map[string]*HistoryEntry
+}
+
+func (e *HistoryEntry) clone() *HistoryEntry {
+	entry := *e
+	entry.frames = make(map[string]*HistoryEntry, len(e.frames))
+	for path, frame := range e.frames {
+		frame_entry := *frame
+		entry.frames[path] = &frame_entry
+	}
+	return &entry
+}
+
+// frame_entry returns the entry for the iframe at path, creating it if
+// the iframe was not seen yet.
+func (e *HistoryEntry) frame_entry(path string) *HistoryEntry {
+	if _, ok := e.frames[path]; !ok {
+		e.frames[path] = &HistoryEntry{}
+	}
+	return e.frames[path]
+}
+
+// save remembers the state the user left frame in.
+func (e *HistoryEntry) save(frame *Frame) {
+	e.scroll = frame.scroll
+	e.form_state = frame.form_state()
+}
+
+type SessionHistory struct {
+	entries []*HistoryEntry
+	index   int
+}
+
+func NewSessionHistory() *SessionHistory {
+	return &SessionHistory{index: -1}
+}
+
+func (h *SessionHistory) current() *HistoryEntry {
+	if h.index < 0 {
+		return nil
+	}
+	return h.entries[h.index]
+}
+
+// push adds entry after the current one, dropping all forward entries.
+func (h *SessionHistory) push(entry *HistoryEntry) {
+	h.entries = append(h.entries[:h.index+1], entry)
+	h.index++
+	h.drop_responses()
+}
+
+// drop_responses forgets the documents of entries far from the current
+// one. Going back to them fetches them again, through the cache, and asks
+// before posting a form again.
+func (h *SessionHistory) drop_responses() {
+	for i, entry := range h.entries {
+		if i >= h.index-MAX_HISTORY_RESPONSES && i <= h.index+MAX_HISTORY_RESPONSES {
+			continue
+		}
+		entry.response = nil
+		for _, frame_entry := range entry.frames {
+			frame_entry.response = nil
+		}
+	}
+}
+
+func (h *SessionHistory) can_go_back() bool {
+	return h.index > 0
+}
+
+func (h *SessionHistory) can_go_forward() bool {
+	return h.index < len(h.entries)-1
+}
+
+// save_history_state remembers the scroll positions and form state of all
+// frames in the current entry, to restore them when coming back to it.
+func (t *Tab) save_history_state() {
+	entry := t.history.current()
+	if entry == nil || t.root_frame == nil || !t.root_frame.Loaded {
+		return
+	}
+	entry.save(t.root_frame)
+	t.root_frame.walk_frames(func(path string, frame *Frame) bool {
+		if frame.Loaded {
+			frame_entry := entry.frame_entry(path)
+			frame_entry.url, frame_entry.response = frame.url, frame.cached_response()
+			frame_entry.save(frame)
+		}
+		return true
+	})
+}
+
+// update_history_entry records the document frame shows now in the
+// current entry, replacing what was loaded before.
+func (t *Tab) update_history_entry(frame *Frame) {
+	entry := t.history.current()
+	if frame != t.root_frame {
+		entry = entry.frame_entry(frame.history_path())
+	} else {
+		t.url = frame.url
+	}
+	entry.url, entry.response = frame.url, frame.cached_response()
+}
+
+// navigate_frame loads url in an iframe, as a new entry of the current
+// document.
+func (t *Tab) navigate_frame(frame *Frame, url *u.URL, payload *u.Payload) {
+	t.save_history_state()
+	t.restoring = nil
+	path := frame.history_path()
+	frame.Load(url, payload)
+	entry := t.history.current().clone()
+	// frames inside the old document of the iframe are gone
+	maps.DeleteFunc(entry.frames, func(p string, _ *HistoryEntry) bool {
+		return strings.HasPrefix(p, path+"/")
+	})
+	entry.frames[path] = &HistoryEntry{url: url, payload: payload, response: frame.cached_response()}
+	if frame.url != nil {
+		entry.frames[path].url = frame.url
+	}
+	t.history.push(entry)
+}
+
+// navigate_to_fragment moves frame to another fragment of its document.
+func (t *Tab) navigate_to_fragment(frame *Frame, url *u.URL) {
+	t.save_history_state()
+	t.restoring = nil
+	frame.url = url
+	entry := t.history.current().clone()
+	if frame == t.root_frame {
+		t.url = url
+		entry.url = url
+	} else {
+		entry.frame_entry(frame.history_path()).url = url
+	}
+	t.history.push(entry)
+	frame.needs_fragment_scroll = true
+	t.SetNeedsPaint()
+}
+
+func (t *Tab) go_back() {
+	if t.history.can_go_back() {
+		t.traverse(t.history.index - 1)
+	}
+}
+
+func (t *Tab) go_forward() {
+	if t.history.can_go_forward() {
+		t.traverse(t.history.index + 1)
+	}
+}
+
+// traverse goes to the history entry at index. Another document is
+// loaded from the response kept in the entry, within the same document
+// only the iframes that differ are loaded again.
+func (t *Tab) traverse(index int) {
+	t.save_history_state()
+	current, target := t.history.current(), t.history.entries[index]
+	t.history.index = index
+	t.history.drop_responses()
+	t.restoring = target
+
+	if current.document != target.document {
+		t.CancelLoads()
+		t.loaded = false
+		t.TaskRunner.ClearPendingTasks()
+		t.root_frame.teardown()
+		t.root_frame = NewFrame(t, nil, nil)
+		t.root_frame.frame_width = WIDTH
+		t.root_frame.frame_height = t.tab_height
+		t.root_frame.load_entry(target)
+		t.url = target.url
+		t.loaded = true
+		return
+	}
+
+	t.url = target.url
+	t.root_frame.url = target.url
+	t.root_frame.restore_state(target)
+	t.root_frame.walk_frames(func(path string, frame *Frame) bool {
+		frame_entry, ok := target.frames[path]
+		if !ok || frame_entry.url == nil {
+			return true
+		}
+		if frame.url == nil || frame.url.WithoutFragment().String() != frame_entry.url.WithoutFragment().String() {
+			// the frames inside are restored as the iframe loads
+			frame.load_entry(frame_entry)
+			return false
+		}
+		frame.url = frame_entry.url
+		frame.restore_state(frame_entry)
+		return true
+	})
+}
+
+// restoring_entry returns the entry frame is restored from while moving
+// through history, or nil for a new navigation.
+func (t *Tab) restoring_entry(frame *Frame) *HistoryEntry {
+	if t.restoring == nil {
+		return nil
+	}
+	if frame == t.root_frame {
+		return t.restoring
+	}
+	return t.restoring.frames[frame.history_path()]
+}
+
+// history_path identifies an iframe by the position of its element among
+// the iframes of each enclosing document, which stays the same when the
+// document is loaded again.
+func (f *Frame) history_path() string {
+	if f.parent_frame == nil {
+		return ""
+	}
+	index := slices.Index(f.parent_frame.frames(f.parent_frame.Nodes), f.frame_element)
+	return f.parent_frame.history_path() + "/" + strconv.Itoa(index)
+}
+
+// walk_frames calls fn for all iframes inside f, and for the iframes
+// inside those unless fn returns false.
+func (f *Frame) walk_frames(fn func(path string, frame *Frame) bool) {
+	if f.Nodes == nil {
+		return
+	}
+	for _, iframe := range f.frames(f.Nodes) {
+		if iframe.Frame != nil && fn(iframe.Frame.history_path(), iframe.Frame) {
+			iframe.Frame.walk_frames(fn)
+		}
+	}
+}
+
+// load_entry loads the document of a history entry, from the response
+// kept in it if there is one. A form submission is not sent again
+// without asking.
+func (f *Frame) load_entry(entry *HistoryEntry) {
+	if entry.response != nil {
+		f.start_loading()
+		f.load_response(entry.response)
+		f.url = entry.url
+	} else if entry.payload != nil {
+		f.start_loading()
+		f.load_response(NewResubmitPage(entry.url))
+		f.resubmit_payload = entry.payload
+	} else {
+		f.Load(entry.url, nil)
+		if f.url != nil {
+			entry.url, entry.response = f.url, f.cached_response()
+		}
+	}
+}
+
+// cached_response is the response to keep in history. Error pages and
+// interstitials are not kept, since coming back to them has to try or
+// ask again.
+func (f *Frame) cached_response() *u.Response {
+	if f.error_page || f.resubmit_payload != nil {
+		return nil
+	}
+	return f.response
+}
+
+func (f *Frame) restore_state(entry *HistoryEntry) {
+	f.restore_form_state(entry.form_state)
+	f.restored_scroll = entry.scroll
+	f.needs_scroll_restore = true
+	f.SetNeedsRender()
+}
+
+func (f *Frame) restore_scroll() {
+	f.needs_scroll_restore = false
+	f.needs_fragment_scroll = false
+	f.scroll = f.clamp_scroll(f.restored_scroll)
+	f.scroll_changed_in_frame = true
+	f.tab.SetNeedsPaint()
+}
+
+// form_controls lists the controls whose state is kept in history.
+// Passwords and chosen files are not kept.
+func (f *Frame) form_controls() []*HtmlNode {
+	controls := []*HtmlNode{}
+	for _, node := range TreeToList(f.Nodes) {
+		element, ok := node.Token.(ElementToken)
+		if ok && slices.Contains([]string{"input", "textarea", "select"}, element.Tag) && !is_input(node, "password", "file") {
+			controls = append(controls, node)
+		}
+	}
+	return controls
+}
+
+// form_state lists the values of all form controls in document order.
+// Checkboxes and radio buttons are "on" when checked, selects keep the
+// index of the selected option.
+func (f *Frame) form_state() []string {
+	state := []string{}
+	for _, control := range f.form_controls() {
+		switch {
+		case is_checkable(control):
+			value := ""
+			if is_checked(control) {
+				value = "on"
+			}
+			state = append(state, value)
+		case is_element(control, "textarea"):
+			state = append(state, textarea_value(control))
+		case is_element(control, "select"):
+			state = append(state, strconv.Itoa(slices.Index(options(control), selected_option(control))))
+		default:
+			state = append(state, control.Token.(ElementToken).Attributes["value"])
+		}
+	}
+	return state
+}
+
+func (f *Frame) restore_form_state(state []string) {
+	for i, control := range f.form_controls() {
+		if i >= len(state) {
+			break
+		}
+		attributes := control.Token.(ElementToken).Attributes
+		switch {
+		case is_checkable(control):
+			set_checked(control, state[i] != "")
+		case is_element(control, "textarea"):
+			set_textarea_value(control, state[i])
+		case is_element(control, "select"):
+			options := options(control)
+			if index, err := strconv.Atoi(state[i]); err == nil && index >= 0 && index < len(options) {
+				select_option(control, options[index])
+			}
+		default:
+			// an input without a value attribute is cleared on first keypress
+			if _, ok := attributes["value"]; ok || state[i] != "" {
+				attributes["value"] = state[i]
+			}
+		}
+	}
+}
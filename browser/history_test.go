@@ -0,0 +1,155 @@
+package browser
+
+import (
+	u "gowser/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func findElement(frame *Frame, tag string) *HtmlNode {
+	for _, node := range TreeToList(frame.Nodes) {
+		if elt, ok := node.Token.(ElementToken); ok && elt.Tag == tag {
+			return node
+		}
+	}
+	return nil
+}
+
+func TestHistoryBackForward(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/a", "text/html", `<input name=q>`+strings.Repeat("<p>filler</p>", 100))
+	fetcher.Add("http://example.org/b", "text/html", `<p>b</p>`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/a"))
+
+	runTask(tab, func() {
+		tab.root_frame.scroll = 250
+		findElement(tab.root_frame, "input").Token.(ElementToken).Attributes["value"] = "typed"
+	})
+	loadPage(tab, mustURL(t, "http://example.org/b"))
+	if tab.history.can_go_forward() || !tab.history.can_go_back() {
+		t.Fatal("Expected to be able to go back only")
+	}
+
+	runTask(tab, tab.go_back)
+	frame := tab.root_frame
+	if tab.url.String() != "http://example.org/a" {
+		t.Fatalf("Expected to be back at a, got %s", tab.url)
+	}
+	if len(fetcher.Requests()) != 2 {
+		t.Errorf("Expected page to be restored without fetching, got %v", fetcher.Requests())
+	}
+	if value := findElement(frame, "input").Token.(ElementToken).Attributes["value"]; value != "typed" {
+		t.Errorf("Expected form state to be restored, got '%s'", value)
+	}
+	if !frame.needs_scroll_restore || frame.restored_scroll != 250 {
+		t.Errorf("Expected scroll position 250 to be restored, got %v", frame.restored_scroll)
+	}
+
+	runTask(tab, tab.go_forward)
+	if tab.url.String() != "http://example.org/b" || len(fetcher.Requests()) != 2 {
+		t.Errorf("Expected to go forward to b without fetching, got %s and %v", tab.url, fetcher.Requests())
+	}
+
+	// a new navigation drops the forward entries
+	runTask(tab, tab.go_back)
+	loadPage(tab, mustURL(t, "http://example.org/b"))
+	if tab.history.can_go_forward() || len(tab.history.entries) != 2 {
+		t.Errorf("Expected forward entries to be dropped, got %d entries", len(tab.history.entries))
+	}
+}
+
+func TestHistoryNoRepost(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<p>start</p>`)
+	fetcher.AddExchange(&u.Exchange{Method: "POST", URL: "http://example.org/submit", Payload: "a=1", Status: 200, Body: []byte("<p>thanks</p>")})
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+	runTask(tab, func() {
+		tab.Load(mustURL(t, "http://example.org/submit"), &u.Payload{Method: "POST", ContentType: "application/x-www-form-urlencoded", Body: "a=1"})
+	})
+	runTask(tab, tab.go_back)
+	runTask(tab, tab.go_forward)
+
+	expected := []string{"GET http://example.org/", "POST http://example.org/submit"}
+	if !slices.Equal(fetcher.Requests(), expected) {
+		t.Fatalf("Expected the POST to be restored from history, got %v", fetcher.Requests())
+	}
+
+	// without the response, going forward has to ask first
+	tab.history.entries[1].response = nil
+	runTask(tab, tab.go_back)
+	runTask(tab, tab.go_forward)
+	if !slices.Equal(fetcher.Requests(), expected) {
+		t.Fatalf("Expected no POST without asking, got %v", fetcher.Requests())
+	}
+	resubmit := findElement(tab.root_frame, "a")
+	if resubmit == nil || resubmit.Token.(ElementToken).Attributes["id"] != "resubmit" {
+		t.Fatal("Expected a prompt to resubmit the form")
+	}
+	runTask(tab, func() { tab.root_frame.activate_element(resubmit) })
+	expected = append(expected, "POST http://example.org/submit")
+	if !slices.Equal(fetcher.Requests(), expected) {
+		t.Errorf("Expected the form to be posted again, got %v", fetcher.Requests())
+	}
+	if tab.history.current().response == nil {
+		t.Error("Expected the new response to be kept in history")
+	}
+}
+
+func TestHistoryDropsResponses(t *testing.T) {
+	max_responses := MAX_HISTORY_RESPONSES
+	MAX_HISTORY_RESPONSES = 1
+	t.Cleanup(func() { MAX_HISTORY_RESPONSES = max_responses })
+	fetcher := u.NewMemoryFetcher()
+	for _, page := range []string{"a", "b", "c"} {
+		fetcher.Add("http://example.org/"+page, "text/html", "<p>"+page+"</p>")
+	}
+	tab := newTestTab(t, fetcher)
+	for _, page := range []string{"a", "b", "c"} {
+		loadPage(tab, mustURL(t, "http://example.org/"+page))
+	}
+	if tab.history.entries[0].response != nil || tab.history.entries[1].response == nil {
+		t.Fatal("Expected only the entries next to the current one to keep their documents")
+	}
+
+	runTask(tab, tab.go_back)
+	runTask(tab, tab.go_back)
+	expected := []string{"GET http://example.org/a", "GET http://example.org/b", "GET http://example.org/c", "GET http://example.org/a"}
+	if !slices.Equal(fetcher.Requests(), expected) || documentText(tab.root_frame) != "a" {
+		t.Errorf("Expected a to be fetched again, got %v", fetcher.Requests())
+	}
+}
+
+func TestHistoryIframe(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<iframe src="one"></iframe>`)
+	fetcher.Add("http://example.org/one", "text/html", `<a href="two">next</a>`)
+	fetcher.Add("http://example.org/two", "text/html", `<p>two</p>`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	root := tab.root_frame
+	iframe := findElement(root, "iframe").Frame
+	runTask(tab, func() { iframe.activate_element(findElement(iframe, "a")) })
+	if tab.root_frame != root || iframe.url.String() != "http://example.org/two" {
+		t.Fatalf("Expected only the iframe to navigate, got %s", iframe.url)
+	}
+	if len(tab.history.entries) != 2 || tab.url.String() != "http://example.org/" {
+		t.Fatalf("Expected an iframe history entry, got %d entries", len(tab.history.entries))
+	}
+
+	runTask(tab, tab.go_back)
+	if tab.root_frame != root || iframe.url.String() != "http://example.org/one" {
+		t.Errorf("Expected the iframe to go back, got %s", iframe.url)
+	}
+	runTask(tab, tab.go_forward)
+	if iframe.url.String() != "http://example.org/two" {
+		t.Errorf("Expected the iframe to go forward, got %s", iframe.url)
+	}
+	expected := []string{"GET http://example.org/", "GET http://example.org/one", "GET http://example.org/two"}
+	if !slices.Equal(fetcher.Requests(), expected) {
+		t.Errorf("Expected iframe documents to come from history, got %v", fetcher.Requests())
+	}
+}
@@ -32,6 +32,11 @@ type HtmlNode struct {
 	LayoutObject *LayoutNode
 	Image        image.Image
 	Frame        *Frame
+	// a script that was run already, or must never run because innerHTML
+	// inserted it
+	ScriptStarted bool
+	// the path of the file chosen for a file input, only the user sets it
+	FilePath string
 }
 
 func NewNode(token Token, parent *HtmlNode) *HtmlNode {
@@ -81,14 +86,14 @@ func GetTabIndex(node *HtmlNode) int {
 }
 
 func IsFocusable(node *HtmlNode) bool {
-	if GetTabIndex(node) <= 0 {
+	if GetTabIndex(node) <= 0 || is_input(node, "hidden") {
 		return false
 	} else if _, ok := node.Token.(ElementToken).Attributes["tabindex"]; ok {
 		return true
 	} else if _, ok := node.Token.(ElementToken).Attributes["contenteditable"]; ok {
 		return true
 	} else {
-		return slices.Contains([]string{"input", "button", "a"}, node.Token.(ElementToken).Tag)
+		return slices.Contains([]string{"input", "button", "a", "textarea", "select"}, node.Token.(ElementToken).Tag)
 	}
 }
 
@@ -1,6 +1,7 @@
 package browser
 
 import (
+	"maps"
 	"slices"
 	"strings"
 )
@@ -12,172 +13,545 @@ var (
 	HEAD_TAGS = []string{
 		"base", "basefont", "bgsound", "noscript",
 		"link", "meta", "title", "style", "script"}
+	HEADING_TAGS    = []string{"h1", "h2", "h3", "h4", "h5", "h6"}
+	FORMATTING_TAGS = []string{
+		"a", "b", "big", "code", "em", "font", "i", "nobr", "s",
+		"small", "strike", "strong", "tt", "u"}
+	// start tags that close an open p
+	CLOSES_P_TAGS = []string{
+		"address", "article", "aside", "blockquote", "center", "details",
+		"dialog", "dir", "div", "dl", "fieldset", "figcaption", "figure",
+		"footer", "header", "hgroup", "main", "menu", "nav", "ol", "p",
+		"search", "section", "summary", "ul", "h1", "h2", "h3", "h4", "h5",
+		"h6", "pre", "listing", "form", "table", "hr", "xmp", "plaintext",
+		"li", "dd", "dt"}
+	// elements that end where their parent or next sibling starts
+	IMPLIED_END_TAGS = []string{
+		"dd", "dt", "li", "optgroup", "option", "p", "rb", "rp", "rt", "rtc"}
+	SPECIAL_TAGS = []string{
+		"address", "applet", "area", "article", "aside", "base", "basefont",
+		"bgsound", "blockquote", "body", "br", "button", "caption", "center",
+		"col", "colgroup", "dd", "details", "dir", "div", "dl", "dt", "embed",
+		"fieldset", "figcaption", "figure", "footer", "form", "frame",
+		"frameset", "h1", "h2", "h3", "h4", "h5", "h6", "head", "header",
+		"hgroup", "hr", "html", "iframe", "img", "input", "keygen", "li",
+		"link", "listing", "main", "marquee", "menu", "meta", "nav",
+		"noembed", "noframes", "noscript", "object", "ol", "p", "param",
+		"plaintext", "pre", "script", "search", "section", "select", "source",
+		"style", "summary", "table", "tbody", "td", "template", "textarea",
+		"tfoot", "th", "thead", "title", "tr", "track", "ul", "wbr", "xmp"}
+	// end tags that close everything inside their element
+	BLOCK_TAGS = []string{
+		"address", "article", "aside", "blockquote", "button", "center",
+		"details", "dialog", "dir", "div", "dl", "fieldset", "figcaption",
+		"figure", "footer", "header", "hgroup", "listing", "main", "menu",
+		"nav", "ol", "pre", "search", "section", "select", "summary", "ul"}
+	TABLE_SECTION_TAGS = []string{"tbody", "thead", "tfoot"}
+	TABLE_CELL_TAGS    = []string{"td", "th"}
+
+	// an element is in scope if it is open and none of these is open
+	// inside it
+	DEFAULT_SCOPE = []string{
+		"applet", "caption", "html", "table", "td", "th", "marquee",
+		"object", "template"}
+	LIST_ITEM_SCOPE = append([]string{"ol", "ul"}, DEFAULT_SCOPE...)
+	BUTTON_SCOPE    = append([]string{"button"}, DEFAULT_SCOPE...)
+	TABLE_SCOPE     = []string{"html", "table", "template"}
 )
 
 type HTMLParser struct {
-	body       string
+	tokenizer  *HTMLTokenizer
 	unfinished []*HtmlNode
-	inPre      bool
+	// the list of active formatting elements, nil marks where a table
+	// cell starts
+	formatting []*HtmlNode
 }
 
 func NewHTMLParser(body string) *HTMLParser {
 	return &HTMLParser{
-		body:       body,
+		tokenizer:  NewHTMLTokenizer(body),
 		unfinished: []*HtmlNode{},
 	}
 }
 
 func (p *HTMLParser) Parse() *HtmlNode {
-	buffer := strings.Builder{}
-	inTag := false
-	for _, char := range p.body {
-		if char == '<' {
-			inTag = true
-			if buffer.Len() > 0 {
-				p.add_text(buffer.String())
-				buffer.Reset()
-			}
-		} else if char == '>' {
-			inTag = false
-			p.add_tag(buffer.String())
-			buffer.Reset()
-		} else {
-			buffer.WriteRune(char)
+	for {
+		p.tokenizer.allow_cdata = p.in_foreign_content()
+		token := p.tokenizer.next()
+		switch token.kind {
+		case CHARACTER_TOKEN:
+			p.add_text(token.data)
+		case START_TAG_TOKEN:
+			p.add_tag(token.name, token.attributes)
+			p.switch_tokenizer(token.name)
+		case END_TAG_TOKEN:
+			p.add_tag("/"+token.name, nil)
+		case EOF_TOKEN:
+			return p.finish()
 		}
+		// comments and doctypes are not part of the tree
 	}
-	if !inTag && buffer.Len() > 0 {
-		p.add_text(buffer.String())
+}
+
+// switch_tokenizer makes the tokenizer read the content of elements like
+// script and textarea as text.
+func (p *HTMLParser) switch_tokenizer(tag string) {
+	switch tag {
+	case "title", "textarea":
+		p.tokenizer.switch_to(rcdata_state)
+	case "style", "xmp", "iframe", "noembed", "noframes":
+		p.tokenizer.switch_to(rawtext_state)
+	case "script":
+		p.tokenizer.switch_to(script_data_state)
+	case "plaintext":
+		p.tokenizer.switch_to(plaintext_state)
 	}
-	return p.finish()
+}
+
+func (p *HTMLParser) in_foreign_content() bool {
+	for _, node := range p.unfinished {
+		if tag := node.Token.(ElementToken).Tag; tag == "svg" || tag == "math" {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *HTMLParser) add_text(text string) {
-	if !p.inPre && strings.TrimSpace(text) == "" {
+	if !p.is_open("pre") && strings.TrimSpace(text) == "" {
 		return
 	}
 	p.implicit_tags("")
-	parent := p.unfinished[len(p.unfinished)-1]
+	p.reconstruct_formatting()
+	parent := p.current()
+	if element, ok := parent.Token.(ElementToken); ok && element.Tag == "textarea" && len(parent.Children) == 0 {
+		// a newline right after <textarea> isn't part of its value
+		text = strings.TrimPrefix(text, "\n")
+		if text == "" {
+			return
+		}
+	}
 	node := NewNode(NewTextToken(text), parent)
 	parent.Children = append(parent.Children, node)
 }
 
-func (p *HTMLParser) add_tag(tag string) {
-	tag, attributes := p.get_attributes(tag)
-	if strings.HasPrefix(tag, "!") {
+// add_tag adds an element for a start tag, or closes one for an end tag
+// like "/p".
+func (p *HTMLParser) add_tag(tag string, attributes map[string]string) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	if (tag == "html" || tag == "body") && p.is_open(tag) {
+		// attributes of a repeated tag go to the open element
+		for _, node := range p.unfinished {
+			if element := node.Token.(ElementToken); element.Tag == tag {
+				for name, value := range attributes {
+					if _, ok := element.Attributes[name]; !ok {
+						element.Attributes[name] = value
+					}
+				}
+			}
+		}
 		return
 	}
 	p.implicit_tags(tag)
 
 	if strings.HasPrefix(tag, "/") {
-		if tag == "/pre" {
-			p.inPre = false
+		p.end_tag(tag[1:])
+		return
+	}
+
+	p.close_implied(tag)
+	if tag == "a" {
+		// links don't nest
+		if a := p.find_formatting("a"); a != nil {
+			p.adoption_agency("a")
+			p.remove_formatting(a)
+			if i := slices.Index(p.unfinished, a); i >= 0 {
+				p.unfinished = slices.Delete(p.unfinished, i, i+1)
+			}
+		}
+	} else if tag == "nobr" && p.in_scope([]string{"nobr"}, DEFAULT_SCOPE) {
+		p.adoption_agency("nobr")
+	}
+	if !slices.Contains(CLOSES_P_TAGS, tag) && !slices.Contains(HEAD_TAGS, tag) &&
+		!slices.Contains([]string{"html", "head", "body", "caption", "colgroup", "col", "tbody", "thead", "tfoot", "tr", "td", "th"}, tag) {
+		p.reconstruct_formatting()
+	}
+
+	node := p.insert(tag, attributes)
+	if slices.Contains(VOID_TAGS, tag) {
+		p.pop()
+	} else if slices.Contains(FORMATTING_TAGS, tag) {
+		p.push_formatting(node)
+	} else if slices.Contains(TABLE_CELL_TAGS, tag) {
+		p.formatting = append(p.formatting, nil)
+	}
+}
+
+// close_implied closes the elements that a start tag ends, like an open
+// paragraph before a div or the previous item before a li.
+func (p *HTMLParser) close_implied(tag string) {
+	switch {
+	case tag == "li":
+		p.close_list_item([]string{"li"})
+	case tag == "dd" || tag == "dt":
+		p.close_list_item([]string{"dd", "dt"})
+	case tag == "option" || tag == "optgroup":
+		if p.current_tag() == "option" {
+			p.pop()
+		}
+		if tag == "optgroup" && p.current_tag() == "optgroup" {
+			p.pop()
+		}
+	case slices.Contains(TABLE_CELL_TAGS, tag):
+		p.close_table_elements(TABLE_CELL_TAGS)
+	case tag == "tr":
+		p.close_table_elements(TABLE_CELL_TAGS, []string{"tr"})
+	case slices.Contains(TABLE_SECTION_TAGS, tag):
+		p.close_table_elements(TABLE_CELL_TAGS, []string{"tr"}, TABLE_SECTION_TAGS)
+	}
+	if slices.Contains(CLOSES_P_TAGS, tag) && p.in_scope([]string{"p"}, BUTTON_SCOPE) {
+		p.close_element([]string{"p"})
+	}
+	if slices.Contains(HEADING_TAGS, tag) && slices.Contains(HEADING_TAGS, p.current_tag()) {
+		// headings don't nest
+		p.pop()
+	}
+}
+
+// close_list_item closes the open li, or dd or dt, unless it is outside
+// of another block.
+func (p *HTMLParser) close_list_item(tags []string) {
+	for i := len(p.unfinished) - 1; i >= 0; i-- {
+		tag := p.unfinished[i].Token.(ElementToken).Tag
+		if slices.Contains(tags, tag) {
+			p.close_element([]string{tag})
+			return
 		}
-		if len(p.unfinished) == 1 {
+		if slices.Contains(SPECIAL_TAGS, tag) && !slices.Contains([]string{"address", "div", "p"}, tag) {
 			return
 		}
-		node := p.unfinished[len(p.unfinished)-1]
-		p.unfinished = p.unfinished[:len(p.unfinished)-1] // pop
-		parent := p.unfinished[len(p.unfinished)-1]
-		parent.Children = append(parent.Children, node)
-	} else if slices.Contains(VOID_TAGS, tag) {
-		parent := p.unfinished[len(p.unfinished)-1]
-		node := NewNode(NewElementToken(tag, attributes), parent)
+	}
+}
+
+// close_table_elements closes the open cell, row or section of the
+// current table, for each group of tags in turn.
+func (p *HTMLParser) close_table_elements(groups ...[]string) {
+	for _, tags := range groups {
+		if p.in_scope(tags, TABLE_SCOPE) {
+			p.close_element(tags)
+		}
+	}
+}
+
+func (p *HTMLParser) end_tag(tag string) {
+	switch {
+	case tag == "html" || tag == "body":
+		// everything stays open until the end of the document
+	case tag == "p":
+		if !p.in_scope([]string{"p"}, BUTTON_SCOPE) {
+			// </p> without <p> is an empty paragraph
+			p.insert("p", map[string]string{})
+		}
+		p.close_element([]string{"p"})
+	case tag == "li":
+		if p.in_scope([]string{"li"}, LIST_ITEM_SCOPE) {
+			p.close_element([]string{"li"})
+		}
+	case slices.Contains(BLOCK_TAGS, tag) || tag == "dd" || tag == "dt":
+		if p.in_scope([]string{tag}, DEFAULT_SCOPE) {
+			p.close_element([]string{tag})
+		}
+	case slices.Contains(HEADING_TAGS, tag):
+		if p.in_scope(HEADING_TAGS, DEFAULT_SCOPE) {
+			p.close_element(HEADING_TAGS)
+		}
+	case slices.Contains(TABLE_CELL_TAGS, tag) || tag == "tr" || tag == "table" ||
+		slices.Contains(TABLE_SECTION_TAGS, tag):
+		if p.in_scope([]string{tag}, TABLE_SCOPE) {
+			p.close_element([]string{tag})
+		}
+	case slices.Contains(FORMATTING_TAGS, tag):
+		p.adoption_agency(tag)
+	default:
+		p.any_other_end_tag(tag)
+	}
+}
+
+// any_other_end_tag closes the innermost element with the tag, unless a
+// special element like div is open inside it.
+func (p *HTMLParser) any_other_end_tag(tag string) {
+	for i := len(p.unfinished) - 1; i >= 0; i-- {
+		node_tag := p.unfinished[i].Token.(ElementToken).Tag
+		if node_tag == tag {
+			p.generate_implied_end_tags(tag)
+			for len(p.unfinished) > i {
+				p.pop()
+			}
+			return
+		}
+		if slices.Contains(SPECIAL_TAGS, node_tag) {
+			return
+		}
+	}
+}
+
+// close_element pops elements up to and including the innermost one with
+// one of tags.
+func (p *HTMLParser) close_element(tags []string) {
+	p.generate_implied_end_tags(tags...)
+	for len(p.unfinished) > 1 {
+		if node := p.pop(); slices.Contains(tags, node.Token.(ElementToken).Tag) {
+			return
+		}
+	}
+}
+
+func (p *HTMLParser) generate_implied_end_tags(except ...string) {
+	for tag := p.current_tag(); slices.Contains(IMPLIED_END_TAGS, tag) && !slices.Contains(except, tag); tag = p.current_tag() {
+		p.pop()
+	}
+}
+
+func (p *HTMLParser) current() *HtmlNode {
+	return p.unfinished[len(p.unfinished)-1]
+}
+
+func (p *HTMLParser) current_tag() string {
+	if len(p.unfinished) == 0 {
+		return ""
+	}
+	return p.current().Token.(ElementToken).Tag
+}
+
+// insert adds an element to the current node and opens it.
+func (p *HTMLParser) insert(tag string, attributes map[string]string) *HtmlNode {
+	var parent *HtmlNode
+	if len(p.unfinished) > 0 {
+		parent = p.current()
+	}
+	node := NewNode(NewElementToken(tag, attributes), parent)
+	if parent != nil {
 		parent.Children = append(parent.Children, node)
-	} else {
-		if tag == "pre" {
-			p.inPre = true
+	}
+	p.unfinished = append(p.unfinished, node)
+	return node
+}
+
+func (p *HTMLParser) pop() *HtmlNode {
+	node := p.current()
+	p.unfinished = p.unfinished[:len(p.unfinished)-1]
+	if slices.Contains(TABLE_CELL_TAGS, node.Token.(ElementToken).Tag) {
+		p.clear_formatting_to_marker()
+	}
+	return node
+}
+
+func (p *HTMLParser) is_open(tag string) bool {
+	return slices.ContainsFunc(p.unfinished, func(node *HtmlNode) bool {
+		return node.Token.(ElementToken).Tag == tag
+	})
+}
+
+// in_scope is true if an element with one of tags is open, and no
+// boundary element is open inside of it.
+func (p *HTMLParser) in_scope(tags []string, boundaries []string) bool {
+	for i := len(p.unfinished) - 1; i >= 0; i-- {
+		tag := p.unfinished[i].Token.(ElementToken).Tag
+		if slices.Contains(tags, tag) {
+			return true
 		}
-		var parent *HtmlNode
-		if len(p.unfinished) == 0 {
-			parent = nil
-		} else {
-			parent = p.unfinished[len(p.unfinished)-1]
+		if slices.Contains(boundaries, tag) {
+			return false
 		}
-		node := NewNode(NewElementToken(tag, attributes), parent)
-		p.unfinished = append(p.unfinished, node)
 	}
+	return false
 }
 
 func (p *HTMLParser) finish() *HtmlNode {
 	if len(p.unfinished) == 0 {
 		p.implicit_tags("")
 	}
-	for len(p.unfinished) > 1 {
-		node := p.unfinished[len(p.unfinished)-1]
-		p.unfinished = p.unfinished[:len(p.unfinished)-1] // pop
-		parent := p.unfinished[len(p.unfinished)-1]
-		parent.Children = append(parent.Children, node)
+	root := p.unfinished[0]
+	p.unfinished = nil
+	return root
+}
+
+// push_formatting adds a formatting element to the list of active
+// formatting elements, which keeps at most three identical ones.
+func (p *HTMLParser) push_formatting(node *HtmlNode) {
+	element := node.Token.(ElementToken)
+	identical := []int{}
+	for i := len(p.formatting) - 1; i >= 0 && p.formatting[i] != nil; i-- {
+		other := p.formatting[i].Token.(ElementToken)
+		if other.Tag == element.Tag && maps.Equal(other.Attributes, element.Attributes) {
+			identical = append(identical, i)
+		}
 	}
-	node := p.unfinished[len(p.unfinished)-1]
-	p.unfinished = p.unfinished[:len(p.unfinished)-1] // pop
-	return node
+	if len(identical) >= 3 {
+		earliest := identical[len(identical)-1]
+		p.formatting = slices.Delete(p.formatting, earliest, earliest+1)
+	}
+	p.formatting = append(p.formatting, node)
 }
 
-func isWhitespace(char rune) bool {
-	switch char {
-	case ' ', '\t', '\n', '\v', '\f', '\r':
-		return true
-	default:
-		return false
+func (p *HTMLParser) clear_formatting_to_marker() {
+	for len(p.formatting) > 0 {
+		entry := p.formatting[len(p.formatting)-1]
+		p.formatting = p.formatting[:len(p.formatting)-1]
+		if entry == nil {
+			return
+		}
 	}
 }
 
-func (p *HTMLParser) get_attributes(text string) (string, map[string]string) {
-	attributes := make(map[string]string)
+func (p *HTMLParser) remove_formatting(node *HtmlNode) {
+	if i := slices.Index(p.formatting, node); i >= 0 {
+		p.formatting = slices.Delete(p.formatting, i, i+1)
+	}
+}
 
-	split := strings.FieldsFunc(text, isWhitespace)
-	if len(split) == 0 {
-		return "", attributes
+// find_formatting returns the last active formatting element with tag in
+// the current table cell, or nil.
+func (p *HTMLParser) find_formatting(tag string) *HtmlNode {
+	for i := len(p.formatting) - 1; i >= 0 && p.formatting[i] != nil; i-- {
+		if p.formatting[i].Token.(ElementToken).Tag == tag {
+			return p.formatting[i]
+		}
 	}
+	return nil
+}
 
-	tag := strings.ToLower(split[0])
-	if len(split) == 1 {
-		return tag, attributes
+// reconstruct_formatting opens again the formatting elements that were
+// closed implicitly, so that in <p><b>a<p>b both a and b are bold.
+func (p *HTMLParser) reconstruct_formatting() {
+	i := len(p.formatting) - 1
+	if i < 0 || p.formatting[i] == nil || slices.Contains(p.unfinished, p.formatting[i]) {
+		return
+	}
+	for i > 0 && p.formatting[i-1] != nil && !slices.Contains(p.unfinished, p.formatting[i-1]) {
+		i--
 	}
+	for ; i < len(p.formatting); i++ {
+		element := p.formatting[i].Token.(ElementToken)
+		p.formatting[i] = p.insert(element.Tag, maps.Clone(element.Attributes))
+	}
+}
 
-	rest := ""
-	if len(text) > len(split[0]) {
-		rest = strings.TrimSpace(text[len(split[0]):])
+// adoption_agency handles the end tag of a formatting element, following
+// the adoption agency algorithm of the HTML standard. For misnested tags
+// like <b>1<p>2</b>3</p> the part of the formatting element inside the
+// block is moved into a copy of it: <b>1</b><p><b>2</b>3</p>.
+func (p *HTMLParser) adoption_agency(tag string) {
+	if p.current_tag() == tag && !slices.Contains(p.formatting, p.current()) {
+		p.pop()
+		return
 	}
+	for range 8 {
+		formatting_element := p.find_formatting(tag)
+		if formatting_element == nil {
+			p.any_other_end_tag(tag)
+			return
+		}
+		stack_index := slices.Index(p.unfinished, formatting_element)
+		if stack_index < 0 {
+			p.remove_formatting(formatting_element)
+			return
+		}
+		if !p.in_scope([]string{tag}, DEFAULT_SCOPE) {
+			return
+		}
 
-	attr_str := rest
-	start := 0
-	cur := 0
-	for {
-		for start < len(attr_str) && isWhitespace(rune(attr_str[start])) {
-			start++
-		}
-		for cur < len(attr_str) && attr_str[cur] != '=' {
-			cur++
-		}
-		key := strings.ToLower(attr_str[start:cur])
-		cur++
-		start = cur // skip =
-		if cur < len(attr_str) && (attr_str[cur] == '\'' || attr_str[cur] == '"') {
-			quot := attr_str[cur]
-			cur++ // skip quot
-			for cur < len(attr_str) && attr_str[cur] != quot {
-				cur++
+		furthest_block_index := -1
+		for i := stack_index + 1; i < len(p.unfinished); i++ {
+			if slices.Contains(SPECIAL_TAGS, p.unfinished[i].Token.(ElementToken).Tag) {
+				furthest_block_index = i
+				break
 			}
-			val := attr_str[start+1 : cur]
-			attributes[key] = val
-			cur++ // skip quot
-			start = cur
-		} else if cur < len(attr_str) && !isWhitespace(rune(attr_str[cur])) {
-			for cur < len(attr_str) && !isWhitespace(rune(attr_str[cur])) {
-				cur++
+		}
+		if furthest_block_index < 0 {
+			for p.pop() != formatting_element {
 			}
-			val := attr_str[start:cur]
-			attributes[key] = val
-			start = cur
-		} else {
-			if key != "" && key != "/" {
-				attributes[key] = ""
+			p.remove_formatting(formatting_element)
+			return
+		}
+		furthest_block := p.unfinished[furthest_block_index]
+		common_ancestor := p.unfinished[stack_index-1]
+		bookmark := slices.Index(p.formatting, formatting_element)
+
+		// copy the formatting elements between the formatting element
+		// and the furthest block into the block
+		node_index := furthest_block_index
+		last_node := furthest_block
+		for counter := 1; ; counter++ {
+			node_index--
+			node := p.unfinished[node_index]
+			if node == formatting_element {
+				break
 			}
-			break
+			formatting_index := slices.Index(p.formatting, node)
+			if counter > 3 && formatting_index >= 0 {
+				p.remove_formatting(node)
+				if formatting_index < bookmark {
+					bookmark--
+				}
+				formatting_index = -1
+			}
+			if formatting_index < 0 {
+				p.unfinished = slices.Delete(p.unfinished, node_index, node_index+1)
+				continue
+			}
+			element := node.Token.(ElementToken)
+			node = NewNode(NewElementToken(element.Tag, maps.Clone(element.Attributes)), nil)
+			p.formatting[formatting_index] = node
+			p.unfinished[node_index] = node
+			if last_node == furthest_block {
+				bookmark = formatting_index + 1
+			}
+			append_child(node, last_node)
+			last_node = node
+		}
+		append_child(common_ancestor, last_node)
+
+		element := formatting_element.Token.(ElementToken)
+		new_element := NewNode(NewElementToken(element.Tag, maps.Clone(element.Attributes)), nil)
+		for _, child := range slices.Clone(furthest_block.Children) {
+			append_child(new_element, child)
+		}
+		append_child(furthest_block, new_element)
+
+		formatting_index := slices.Index(p.formatting, formatting_element)
+		p.formatting = slices.Insert(p.formatting, bookmark, new_element)
+		if formatting_index >= bookmark {
+			formatting_index++
 		}
+		p.formatting = slices.Delete(p.formatting, formatting_index, formatting_index+1)
+		p.unfinished = slices.Delete(p.unfinished, stack_index, stack_index+1)
+		furthest_block_index = slices.Index(p.unfinished, furthest_block)
+		p.unfinished = slices.Insert(p.unfinished, furthest_block_index+1, new_element)
+	}
+}
+
+// append_child moves child to the end of the children of parent.
+func append_child(parent *HtmlNode, child *HtmlNode) {
+	if old := child.Parent; old != nil {
+		if i := slices.Index(old.Children, child); i >= 0 {
+			old.Children = slices.Delete(old.Children, i, i+1)
+		}
+	}
+	child.Parent = parent
+	parent.Children = append(parent.Children, child)
+}
+
+// get_attributes splits the text of a start tag like `a href="/"` into
+// the tag name and its attributes.
+func (p *HTMLParser) get_attributes(text string) (string, map[string]string) {
+	token := NewHTMLTokenizer("<" + text + ">").next()
+	if token.kind != START_TAG_TOKEN {
+		return "", map[string]string{}
 	}
-	return tag, attributes
+	return token.name, token.attributes
 }
 
 func (p *HTMLParser) implicit_tags(tag string) {
@@ -187,17 +561,17 @@ func (p *HTMLParser) implicit_tags(tag string) {
 			open_tags = append(open_tags, node.Token.(ElementToken).Tag)
 		}
 		if len(open_tags) == 0 && tag != "html" {
-			p.add_tag("html")
+			p.add_tag("html", nil)
 		} else if len(open_tags) == 1 && open_tags[0] == "html" &&
 			!slices.Contains([]string{"head", "body", "/html"}, tag) {
 			if slices.Contains(HEAD_TAGS, tag) {
-				p.add_tag("head")
+				p.add_tag("head", nil)
 			} else {
-				p.add_tag("body")
+				p.add_tag("body", nil)
 			}
 		} else if len(open_tags) == 2 && open_tags[0] == "html" &&
 			open_tags[1] == "head" && !slices.Contains(append(HEAD_TAGS, "/head"), tag) {
-			p.add_tag("/head")
+			p.add_tag("/head", nil)
 		} else {
 			break
 		}
@@ -166,3 +166,81 @@ line   3
 		t.Errorf("expected preserved whitespace, got:\n%q\nwant:\n%q", text, expected)
 	}
 }
+
+// treeString renders the elements and text of the body compactly.
+func treeString(node *HtmlNode) string {
+	switch token := node.Token.(type) {
+	case TextToken:
+		return token.Text
+	case ElementToken:
+		children := ""
+		for _, child := range node.Children {
+			children += treeString(child)
+		}
+		if token.Tag == "html" || token.Tag == "body" {
+			return children
+		}
+		return "<" + token.Tag + ">" + children + "</" + token.Tag + ">"
+	}
+	return ""
+}
+
+func TestImpliedEndTags(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`<p>a<p>b`, `<p>a</p><p>b</p>`},
+		{`<p>a<div>b</div>c`, `<p>a</p><div>b</div>c`},
+		{`<p>a</p></p>`, `<p>a</p><p></p>`},
+		{`<h1>a<h2>b</h1>c`, `<h1>a</h1><h2>b</h2>c`},
+		{`<ul><li>a<li>b</ul>`, `<ul><li>a</li><li>b</li></ul>`},
+		{`<ul><li>a<ul><li>b</ul><li>c</ul>`, `<ul><li>a<ul><li>b</li></ul></li><li>c</li></ul>`},
+		{`<li>a<div><li>b`, `<li>a<div></div></li><li>b</li>`},
+		{`<dl><dt>a<dd>b<dt>c</dl>`, `<dl><dt>a</dt><dd>b</dd><dt>c</dt></dl>`},
+		{`<select><option>a<option>b<optgroup><option>c<optgroup>d</select>`,
+			`<select><option>a</option><option>b</option><optgroup><option>c</option></optgroup><optgroup>d</optgroup></select>`},
+		{`<table><tr><td>a<td>b<tr><th>c</table>d`,
+			`<table><tr><td>a</td><td>b</td></tr><tr><th>c</th></tr></table>d`},
+		{`<table><thead><tr><td>a<tbody><tr><td>b</table>`,
+			`<table><thead><tr><td>a</td></tr></thead><tbody><tr><td>b</td></tr></tbody></table>`},
+		{`<div><span>a</div>b`, `<div><span>a</span></div>b`},
+		{`<div>a</span>b</div>`, `<div>ab</div>`},
+		{`<p>a</body>b`, `<p>ab</p>`},
+		{`<pre>a<div>b</div></pre>c`, `<pre>a<div>b</div></pre>c`},
+	}
+	for _, test := range tests {
+		root := NewHTMLParser(test.input).Parse()
+		if got := treeString(root); got != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.input, got, test.want)
+		}
+		if issues := ValidateParentChildRelationships(root); len(issues) > 0 {
+			t.Errorf("Parse(%q) has broken parent pointers:\n%s", test.input, strings.Join(issues, "\n"))
+		}
+	}
+}
+
+func TestAdoptionAgency(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`<b><i>a</b>b</i>`, `<b><i>a</i></b><i>b</i>`},
+		{`<b>a<p>b</b>c</p>`, `<b>a</b><p><b>b</b>c</p>`},
+		{`<a>a<div>b<a>c</a>d</div>e`, `<a>a</a><div><a>b</a><a>c</a>d</div>e`},
+		{`<p><b>a</p><p>b`, `<p><b>a</b></p><p><b>b</b></p>`},
+		{`<b>a<div><i>b</b>c</i></div>`, `<b>a</b><div><b><i>b</i></b><i>c</i></div>`},
+		{`<table><tr><td><b>a</td><td>b</table>`, `<table><tr><td><b>a</b></td><td>b</td></tr></table>`},
+		{`<b>a</i>b</b>`, `<b>ab</b>`},
+		{`<b><b><b><b>a</b></b></b></b><p>b`, `<b><b><b><b>a</b></b></b></b><p>b</p>`},
+	}
+	for _, test := range tests {
+		root := NewHTMLParser(test.input).Parse()
+		if got := treeString(root); got != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.input, got, test.want)
+		}
+		if issues := ValidateParentChildRelationships(root); len(issues) > 0 {
+			t.Errorf("Parse(%q) has broken parent pointers:\n%s", test.input, strings.Join(issues, "\n"))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	u "gowser/url"
+	"maps"
+	"slices"
+	"strings"
+)
+
+var (
+	// elements whose text is not escaped, the parser doesn't decode it
+	RAW_TEXT_TAGS = []string{
+		"style", "script", "xmp", "iframe", "noembed", "noframes",
+		"plaintext", "noscript"}
+	TEXT_ESCAPER = strings.NewReplacer(
+		"&", "&amp;", "\u00a0", "&nbsp;", "<", "&lt;", ">", "&gt;")
+	ATTRIBUTE_ESCAPER = strings.NewReplacer(
+		"&", "&amp;", "\u00a0", "&nbsp;", "\"", "&quot;", "<", "&lt;", ">", "&gt;")
+)
+
+// InnerHTML serializes the children of n as HTML, the way the innerHTML
+// getter does.
+func (n *HtmlNode) InnerHTML() string {
+	out := strings.Builder{}
+	for _, child := range n.Children {
+		serialize(child, &out)
+	}
+	return out.String()
+}
+
+// OuterHTML serializes n and its children as HTML.
+func (n *HtmlNode) OuterHTML() string {
+	out := strings.Builder{}
+	serialize(n, &out)
+	return out.String()
+}
+
+func serialize(node *HtmlNode, out *strings.Builder) {
+	switch token := node.Token.(type) {
+	case TextToken:
+		if parent, ok := node.Parent.Token.(ElementToken); ok && slices.Contains(RAW_TEXT_TAGS, parent.Tag) {
+			out.WriteString(token.Text)
+		} else {
+			out.WriteString(TEXT_ESCAPER.Replace(token.Text))
+		}
+	case ElementToken:
+		out.WriteString("<" + token.Tag)
+		// the parser doesn't keep the order of attributes
+		for _, name := range slices.Sorted(maps.Keys(token.Attributes)) {
+			out.WriteString(" " + name + "=\"" + ATTRIBUTE_ESCAPER.Replace(token.Attributes[name]) + "\"")
+		}
+		out.WriteString(">")
+		if slices.Contains(VOID_TAGS, token.Tag) {
+			return
+		}
+		for _, child := range node.Children {
+			serialize(child, out)
+		}
+		out.WriteString("</" + token.Tag + ">")
+	}
+}
+
+// DumpDOM loads the document at url and returns its DOM as HTML, as the
+// parser built it and without running scripts.
+func DumpDOM(fetcher u.Fetcher, url *u.URL) (string, error) {
+	response, err := fetcher.Fetch(context.Background(), url, nil, nil)
+	if err = check_status(response, err); err != nil {
+		return "", fmt.Errorf("loading %s failed: %w", url, err)
+	}
+	text, _ := decode_document(response.Headers, response.Body)
+	return NewHTMLParser(text).Parse().OuterHTML(), nil
+}
@@ -0,0 +1,74 @@
+package browser
+
+import (
+	"fmt"
+	u "gowser/url"
+	"testing"
+)
+
+func TestSerializeHTML(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`<p>Hello <b>world</b></p>`, `<p>Hello <b>world</b></p>`},
+		{`<p>a &lt; b &amp;&amp; c &gt; d&nbsp;e</p>`, `<p>a &lt; b &amp;&amp; c &gt; d&nbsp;e</p>`},
+		{`<a title='say "hi" & <go>' href=x>`, `<a href="x" title="say &quot;hi&quot; &amp; &lt;go&gt;"></a>`},
+		{`<p>a<br>b<img src=c.png></p>`, `<p>a<br>b<img src="c.png"></p>`},
+		{`<script>if (a < b && c) x = "&amp;"</script>`, `<script>if (a < b && c) x = "&amp;"</script>`},
+		{`<style>p > a { content: "&" }</style>`, `<style>p > a { content: "&" }</style>`},
+		{`<textarea><b>&amp;</b></textarea>`, `<textarea>&lt;b&gt;&amp;&lt;/b&gt;</textarea>`},
+		{`<ul><li>a<li>b</ul>`, `<ul><li>a</li><li>b</li></ul>`},
+	}
+	for _, test := range tests {
+		body := NewHTMLParser(test.input).Parse().Children[0]
+		if got := body.InnerHTML(); got != test.want {
+			t.Errorf("Serializing %q gave %q, want %q", test.input, got, test.want)
+		}
+	}
+
+	root := NewHTMLParser(`<p>x</p>`).Parse()
+	if got := root.OuterHTML(); got != `<html><body><p>x</p></body></html>` {
+		t.Errorf("Expected the whole document, got %q", got)
+	}
+}
+
+func TestInnerHTMLGetters(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", `<div id=a class="x y"><p>1 &lt; 2</p><hr></div>`)
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "http://example.org/"))
+
+	frame := tab.root_frame
+	var handle int
+	runTask(tab, func() {
+		for _, node := range TreeToList(frame.Nodes) {
+			if element, ok := node.Token.(ElementToken); ok && element.Tag == "div" {
+				handle = frame.js.get_handle(node)
+			}
+		}
+	})
+	inner := evalScript(t, tab, fmt.Sprintf("_innerHTML_get(%d, %d)", handle, frame.window_id))
+	if inner != `<p>1 &lt; 2</p><hr>` {
+		t.Errorf("Unexpected innerHTML %q", inner)
+	}
+	outer := evalScript(t, tab, fmt.Sprintf("_outerHTML_get(%d, %d)", handle, frame.window_id))
+	if outer != `<div class="x y" id="a"><p>1 &lt; 2</p><hr></div>` {
+		t.Errorf("Unexpected outerHTML %q", outer)
+	}
+}
+
+func TestDumpDOM(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html; charset=windows-1252", "<title>caf\xe9</title><p>one<p>two")
+	html, err := DumpDOM(fetcher, mustURL(t, "http://example.org/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<html><head><title>café</title></head><body><p>one</p><p>two</p></body></html>`; html != want {
+		t.Errorf("Expected %q, got %q", want, html)
+	}
+	if _, err := DumpDOM(fetcher, mustURL(t, "http://example.org/missing")); err == nil {
+		t.Error("Expected an error for a missing page")
+	}
+}
@@ -0,0 +1,946 @@
+package browser
+
+import (
+	"strings"
+)
+
+// The tokenizer follows the tokenization section of the HTML standard,
+// https://html.spec.whatwg.org/multipage/parsing.html#tokenization. Parse
+// errors are not reported, and the tokens are only what the tree needs:
+// public and system identifiers of a doctype are skipped.
+
+type tokenizer_state int
+
+const (
+	data_state tokenizer_state = iota
+	rcdata_state
+	rawtext_state
+	script_data_state
+	plaintext_state
+	tag_open_state
+	end_tag_open_state
+	tag_name_state
+	// the end tag states are shared by RCDATA, RAWTEXT and script data,
+	// text_state says which one to go back to
+	text_less_than_sign_state
+	text_end_tag_open_state
+	text_end_tag_name_state
+	script_data_less_than_sign_state
+	script_data_escape_start_state
+	script_data_escape_start_dash_state
+	script_data_escaped_state
+	script_data_escaped_dash_state
+	script_data_escaped_dash_dash_state
+	script_data_escaped_less_than_sign_state
+	script_data_double_escape_start_state
+	script_data_double_escaped_state
+	script_data_double_escaped_dash_state
+	script_data_double_escaped_dash_dash_state
+	script_data_double_escaped_less_than_sign_state
+	script_data_double_escape_end_state
+	before_attribute_name_state
+	attribute_name_state
+	after_attribute_name_state
+	before_attribute_value_state
+	attribute_value_double_quoted_state
+	attribute_value_single_quoted_state
+	attribute_value_unquoted_state
+	after_attribute_value_quoted_state
+	self_closing_start_tag_state
+	bogus_comment_state
+	markup_declaration_open_state
+	comment_start_state
+	comment_start_dash_state
+	comment_state
+	comment_end_dash_state
+	comment_end_state
+	comment_end_bang_state
+	doctype_state
+	before_doctype_name_state
+	doctype_name_state
+	after_doctype_name_state
+	cdata_section_state
+	cdata_section_bracket_state
+	cdata_section_end_state
+	character_reference_state
+	named_character_reference_state
+	ambiguous_ampersand_state
+	numeric_character_reference_state
+	hexadecimal_character_reference_start_state
+	decimal_character_reference_start_state
+	hexadecimal_character_reference_state
+	decimal_character_reference_state
+)
+
+const eof = -1
+
+// the longest name of a character reference, CounterClockwiseContourIntegral;
+const LONGEST_CHARACTER_REFERENCE = 32
+
+var (
+	// numeric references to C1 controls mean the windows-1252 character
+	C1_CHARACTER_REFERENCES = map[int]rune{
+		0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†',
+		0x87: '‡', 0x88: 'ˆ', 0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ',
+		0x8E: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•',
+		0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™', 0x9A: 'š', 0x9B: '›',
+		0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+	}
+)
+
+type html_token_kind int
+
+const (
+	CHARACTER_TOKEN html_token_kind = iota
+	START_TAG_TOKEN
+	END_TAG_TOKEN
+	COMMENT_TOKEN
+	DOCTYPE_TOKEN
+	EOF_TOKEN
+)
+
+type html_token struct {
+	kind         html_token_kind
+	name         string // of a tag or doctype
+	data         string // characters or comment text
+	attributes   map[string]string
+	self_closing bool
+}
+
+type HTMLTokenizer struct {
+	input      []rune
+	pos        int
+	state      tokenizer_state
+	text_state tokenizer_state
+	// CDATA sections are only allowed in SVG and MathML content
+	allow_cdata bool
+
+	text           strings.Builder
+	tag            *html_token
+	attr_name      strings.Builder
+	attr_value     strings.Builder
+	has_attr       bool
+	comment        strings.Builder
+	temp           strings.Builder
+	last_start_tag string
+	// where a character reference is read from, and its value so far
+	return_state   tokenizer_state
+	character_code int
+	queue          []html_token
+	done           bool
+}
+
+func NewHTMLTokenizer(input string) *HTMLTokenizer {
+	// newlines are normalized before tokenizing
+	input = strings.ReplaceAll(input, "\r\n", "\n")
+	input = strings.ReplaceAll(input, "\r", "\n")
+	return &HTMLTokenizer{input: []rune(input)}
+}
+
+// switch_to changes the state for the content of the element that was
+// just opened, for elements like script whose content is not markup.
+func (t *HTMLTokenizer) switch_to(state tokenizer_state) {
+	t.state = state
+	t.text_state = state
+}
+
+// next returns the next token, runs of characters are returned as one.
+func (t *HTMLTokenizer) next() html_token {
+	for len(t.queue) == 0 {
+		if t.done {
+			return html_token{kind: EOF_TOKEN}
+		}
+		t.step()
+	}
+	token := t.queue[0]
+	t.queue = t.queue[1:]
+	return token
+}
+
+func (t *HTMLTokenizer) consume() rune {
+	t.pos++
+	if t.pos > len(t.input) {
+		return eof
+	}
+	return t.input[t.pos-1]
+}
+
+func (t *HTMLTokenizer) reconsume(state tokenizer_state) {
+	t.pos--
+	t.state = state
+}
+
+// lookahead consumes s if the input continues with it.
+func (t *HTMLTokenizer) lookahead(s string, fold bool) bool {
+	runes := []rune(s)
+	if t.pos+len(runes) > len(t.input) {
+		return false
+	}
+	next := string(t.input[t.pos : t.pos+len(runes)])
+	if next == s || fold && strings.EqualFold(next, s) {
+		t.pos += len(runes)
+		return true
+	}
+	return false
+}
+
+func (t *HTMLTokenizer) emit_char(c rune) {
+	t.text.WriteRune(c)
+}
+
+func (t *HTMLTokenizer) emit_chars(s string) {
+	t.text.WriteString(s)
+}
+
+func (t *HTMLTokenizer) emit(token html_token) {
+	if t.text.Len() > 0 {
+		t.queue = append(t.queue, html_token{kind: CHARACTER_TOKEN, data: t.text.String()})
+		t.text.Reset()
+	}
+	t.queue = append(t.queue, token)
+}
+
+func (t *HTMLTokenizer) emit_eof() {
+	t.emit(html_token{kind: EOF_TOKEN})
+	t.done = true
+}
+
+func (t *HTMLTokenizer) start_tag(kind html_token_kind) {
+	t.tag = &html_token{kind: kind, attributes: map[string]string{}}
+	t.has_attr = false
+}
+
+func (t *HTMLTokenizer) start_attribute() {
+	t.finish_attribute()
+	t.has_attr = true
+	t.attr_name.Reset()
+	t.attr_value.Reset()
+}
+
+// finish_attribute adds the attribute to the tag, unless the tag already
+// has one with that name.
+func (t *HTMLTokenizer) finish_attribute() {
+	if !t.has_attr {
+		return
+	}
+	t.has_attr = false
+	if _, ok := t.tag.attributes[t.attr_name.String()]; !ok {
+		t.tag.attributes[t.attr_name.String()] = t.attr_value.String()
+	}
+}
+
+func (t *HTMLTokenizer) emit_tag() {
+	t.finish_attribute()
+	t.state = data_state
+	if t.tag.kind == START_TAG_TOKEN {
+		t.last_start_tag = t.tag.name
+	} else {
+		// end tags have no attributes
+		t.tag.attributes = map[string]string{}
+		t.tag.self_closing = false
+	}
+	t.emit(*t.tag)
+}
+
+func (t *HTMLTokenizer) emit_comment() {
+	t.emit(html_token{kind: COMMENT_TOKEN, data: t.comment.String()})
+}
+
+// appropriate_end_tag is true for the end tag of the element whose text
+// is being tokenized.
+func (t *HTMLTokenizer) appropriate_end_tag() bool {
+	return t.last_start_tag != "" && t.tag.name == t.last_start_tag
+}
+
+func is_ascii_alpha(c rune) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func to_ascii_lower(c rune) rune {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func is_tag_whitespace(c rune) bool {
+	return c == '\t' || c == '\n' || c == '\f' || c == ' '
+}
+
+func (t *HTMLTokenizer) step() {
+	c := t.consume()
+	switch t.state {
+	case data_state:
+		switch c {
+		case '&':
+			t.start_character_reference()
+		case '<':
+			t.state = tag_open_state
+		case eof:
+			t.emit_eof()
+		default:
+			t.emit_char(c)
+		}
+
+	case rcdata_state, rawtext_state, script_data_state, plaintext_state:
+		switch {
+		case c == '&' && t.state == rcdata_state:
+			t.start_character_reference()
+		case c == '<' && t.state == script_data_state:
+			t.state = script_data_less_than_sign_state
+		case c == '<' && t.state != plaintext_state:
+			t.state = text_less_than_sign_state
+		case c == 0:
+			t.emit_char('�')
+		case c == eof:
+			t.emit_eof()
+		default:
+			t.emit_char(c)
+		}
+
+	case tag_open_state:
+		switch {
+		case c == '!':
+			t.state = markup_declaration_open_state
+		case c == '/':
+			t.state = end_tag_open_state
+		case is_ascii_alpha(c):
+			t.start_tag(START_TAG_TOKEN)
+			t.reconsume(tag_name_state)
+		case c == '?':
+			t.comment.Reset()
+			t.reconsume(bogus_comment_state)
+		case c == eof:
+			t.emit_char('<')
+			t.emit_eof()
+		default:
+			t.emit_char('<')
+			t.reconsume(data_state)
+		}
+
+	case end_tag_open_state:
+		switch {
+		case is_ascii_alpha(c):
+			t.start_tag(END_TAG_TOKEN)
+			t.reconsume(tag_name_state)
+		case c == '>':
+			t.state = data_state
+		case c == eof:
+			t.emit_chars("</")
+			t.emit_eof()
+		default:
+			t.comment.Reset()
+			t.reconsume(bogus_comment_state)
+		}
+
+	case tag_name_state:
+		switch {
+		case is_tag_whitespace(c):
+			t.state = before_attribute_name_state
+		case c == '/':
+			t.state = self_closing_start_tag_state
+		case c == '>':
+			t.emit_tag()
+		case c == 0:
+			t.tag.name += "�"
+		case c == eof:
+			t.emit_eof()
+		default:
+			t.tag.name += string(to_ascii_lower(c))
+		}
+
+	case text_less_than_sign_state:
+		if c == '/' {
+			t.temp.Reset()
+			t.state = text_end_tag_open_state
+		} else {
+			t.emit_char('<')
+			t.reconsume(t.text_state)
+		}
+
+	case text_end_tag_open_state:
+		if is_ascii_alpha(c) {
+			t.start_tag(END_TAG_TOKEN)
+			t.reconsume(text_end_tag_name_state)
+		} else {
+			t.emit_chars("</")
+			t.reconsume(t.text_state)
+		}
+
+	case text_end_tag_name_state:
+		switch {
+		case is_tag_whitespace(c) && t.appropriate_end_tag():
+			t.state = before_attribute_name_state
+		case c == '/' && t.appropriate_end_tag():
+			t.state = self_closing_start_tag_state
+		case c == '>' && t.appropriate_end_tag():
+			t.emit_tag()
+		case is_ascii_alpha(c):
+			t.tag.name += string(to_ascii_lower(c))
+			t.temp.WriteRune(c)
+		default:
+			t.emit_chars("</" + t.temp.String())
+			t.reconsume(t.text_state)
+		}
+
+	case script_data_less_than_sign_state:
+		t.text_state = script_data_state
+		switch c {
+		case '/':
+			t.temp.Reset()
+			t.state = text_end_tag_open_state
+		case '!':
+			t.emit_chars("<!")
+			t.state = script_data_escape_start_state
+		default:
+			t.emit_char('<')
+			t.reconsume(script_data_state)
+		}
+
+	case script_data_escape_start_state, script_data_escape_start_dash_state:
+		if c == '-' {
+			t.emit_char('-')
+			if t.state == script_data_escape_start_state {
+				t.state = script_data_escape_start_dash_state
+			} else {
+				t.state = script_data_escaped_dash_dash_state
+			}
+		} else {
+			t.reconsume(script_data_state)
+		}
+
+	case script_data_escaped_state, script_data_escaped_dash_state, script_data_escaped_dash_dash_state:
+		switch {
+		case c == '-' && t.state == script_data_escaped_state:
+			t.emit_char('-')
+			t.state = script_data_escaped_dash_state
+		case c == '-':
+			t.emit_char('-')
+			t.state = script_data_escaped_dash_dash_state
+		case c == '<':
+			t.state = script_data_escaped_less_than_sign_state
+		case c == '>' && t.state == script_data_escaped_dash_dash_state:
+			t.emit_char('>')
+			t.state = script_data_state
+		case c == eof:
+			t.emit_eof()
+		default:
+			if c == 0 {
+				c = '�'
+			}
+			t.emit_char(c)
+			t.state = script_data_escaped_state
+		}
+
+	case script_data_escaped_less_than_sign_state:
+		t.text_state = script_data_escaped_state
+		switch {
+		case c == '/':
+			t.temp.Reset()
+			t.state = text_end_tag_open_state
+		case is_ascii_alpha(c):
+			t.temp.Reset()
+			t.emit_char('<')
+			t.reconsume(script_data_double_escape_start_state)
+		default:
+			t.emit_char('<')
+			t.reconsume(script_data_escaped_state)
+		}
+
+	case script_data_double_escape_start_state, script_data_double_escape_end_state:
+		// <script> inside an escaped script starts a double escape,
+		// </script> ends it
+		switch {
+		case is_tag_whitespace(c) || c == '/' || c == '>':
+			t.emit_char(c)
+			double_escaped := t.state == script_data_double_escape_start_state
+			if t.temp.String() != "script" {
+				double_escaped = !double_escaped
+			}
+			if double_escaped {
+				t.state = script_data_double_escaped_state
+			} else {
+				t.state = script_data_escaped_state
+			}
+		case is_ascii_alpha(c):
+			t.temp.WriteRune(to_ascii_lower(c))
+			t.emit_char(c)
+		case t.state == script_data_double_escape_start_state:
+			t.reconsume(script_data_escaped_state)
+		default:
+			t.reconsume(script_data_double_escaped_state)
+		}
+
+	case script_data_double_escaped_state, script_data_double_escaped_dash_state, script_data_double_escaped_dash_dash_state:
+		switch {
+		case c == '-' && t.state == script_data_double_escaped_state:
+			t.emit_char('-')
+			t.state = script_data_double_escaped_dash_state
+		case c == '-':
+			t.emit_char('-')
+			t.state = script_data_double_escaped_dash_dash_state
+		case c == '<':
+			t.emit_char('<')
+			t.state = script_data_double_escaped_less_than_sign_state
+		case c == '>' && t.state == script_data_double_escaped_dash_dash_state:
+			t.emit_char('>')
+			t.state = script_data_state
+		case c == eof:
+			t.emit_eof()
+		default:
+			if c == 0 {
+				c = '�'
+			}
+			t.emit_char(c)
+			t.state = script_data_double_escaped_state
+		}
+
+	case script_data_double_escaped_less_than_sign_state:
+		if c == '/' {
+			t.temp.Reset()
+			t.emit_char('/')
+			t.state = script_data_double_escape_end_state
+		} else {
+			t.reconsume(script_data_double_escaped_state)
+		}
+
+	case before_attribute_name_state:
+		switch {
+		case is_tag_whitespace(c):
+		case c == '/' || c == '>' || c == eof:
+			t.reconsume(after_attribute_name_state)
+		case c == '=':
+			t.start_attribute()
+			t.attr_name.WriteRune(c)
+			t.state = attribute_name_state
+		default:
+			t.start_attribute()
+			t.reconsume(attribute_name_state)
+		}
+
+	case attribute_name_state:
+		switch {
+		case is_tag_whitespace(c) || c == '/' || c == '>' || c == eof:
+			t.reconsume(after_attribute_name_state)
+		case c == '=':
+			t.state = before_attribute_value_state
+		case c == 0:
+			t.attr_name.WriteRune('�')
+		default:
+			t.attr_name.WriteRune(to_ascii_lower(c))
+		}
+
+	case after_attribute_name_state:
+		switch {
+		case is_tag_whitespace(c):
+		case c == '/':
+			t.state = self_closing_start_tag_state
+		case c == '=':
+			t.state = before_attribute_value_state
+		case c == '>':
+			t.emit_tag()
+		case c == eof:
+			t.emit_eof()
+		default:
+			t.start_attribute()
+			t.reconsume(attribute_name_state)
+		}
+
+	case before_attribute_value_state:
+		switch {
+		case is_tag_whitespace(c):
+		case c == '"':
+			t.state = attribute_value_double_quoted_state
+		case c == '\'':
+			t.state = attribute_value_single_quoted_state
+		case c == '>':
+			t.emit_tag()
+		default:
+			t.reconsume(attribute_value_unquoted_state)
+		}
+
+	case attribute_value_double_quoted_state, attribute_value_single_quoted_state:
+		switch {
+		case c == '"' && t.state == attribute_value_double_quoted_state,
+			c == '\'' && t.state == attribute_value_single_quoted_state:
+			t.state = after_attribute_value_quoted_state
+		case c == '&':
+			t.start_character_reference()
+		case c == 0:
+			t.attr_value.WriteRune('�')
+		case c == eof:
+			t.emit_eof()
+		default:
+			t.attr_value.WriteRune(c)
+		}
+
+	case attribute_value_unquoted_state:
+		switch {
+		case is_tag_whitespace(c):
+			t.state = before_attribute_name_state
+		case c == '&':
+			t.start_character_reference()
+		case c == '>':
+			t.emit_tag()
+		case c == 0:
+			t.attr_value.WriteRune('�')
+		case c == eof:
+			t.emit_eof()
+		default:
+			t.attr_value.WriteRune(c)
+		}
+
+	case after_attribute_value_quoted_state:
+		switch {
+		case is_tag_whitespace(c):
+			t.state = before_attribute_name_state
+		case c == '/':
+			t.state = self_closing_start_tag_state
+		case c == '>':
+			t.emit_tag()
+		case c == eof:
+			t.emit_eof()
+		default:
+			t.reconsume(before_attribute_name_state)
+		}
+
+	case self_closing_start_tag_state:
+		switch c {
+		case '>':
+			t.tag.self_closing = true
+			t.emit_tag()
+		case eof:
+			t.emit_eof()
+		default:
+			t.reconsume(before_attribute_name_state)
+		}
+
+	case bogus_comment_state:
+		switch c {
+		case '>':
+			t.state = data_state
+			t.emit_comment()
+		case eof:
+			t.emit_comment()
+			t.emit_eof()
+		case 0:
+			t.comment.WriteRune('�')
+		default:
+			t.comment.WriteRune(c)
+		}
+
+	case markup_declaration_open_state:
+		t.pos--
+		t.comment.Reset()
+		switch {
+		case t.lookahead("--", false):
+			t.state = comment_start_state
+		case t.lookahead("doctype", true):
+			t.state = doctype_state
+		case t.allow_cdata && t.lookahead("[CDATA[", false):
+			t.state = cdata_section_state
+		default:
+			t.state = bogus_comment_state
+		}
+
+	case comment_start_state:
+		switch c {
+		case '-':
+			t.state = comment_start_dash_state
+		case '>':
+			t.state = data_state
+			t.emit_comment()
+		default:
+			t.reconsume(comment_state)
+		}
+
+	case comment_start_dash_state, comment_end_dash_state:
+		switch c {
+		case '-':
+			t.state = comment_end_state
+		case '>':
+			if t.state == comment_start_dash_state {
+				t.state = data_state
+				t.emit_comment()
+				break
+			}
+			t.comment.WriteRune('-')
+			t.reconsume(comment_state)
+		case eof:
+			t.emit_comment()
+			t.emit_eof()
+		default:
+			t.comment.WriteRune('-')
+			t.reconsume(comment_state)
+		}
+
+	case comment_state:
+		switch c {
+		case '-':
+			t.state = comment_end_dash_state
+		case 0:
+			t.comment.WriteRune('�')
+		case eof:
+			t.emit_comment()
+			t.emit_eof()
+		default:
+			t.comment.WriteRune(c)
+		}
+
+	case comment_end_state:
+		switch c {
+		case '>':
+			t.state = data_state
+			t.emit_comment()
+		case '!':
+			t.state = comment_end_bang_state
+		case '-':
+			t.comment.WriteRune('-')
+		case eof:
+			t.emit_comment()
+			t.emit_eof()
+		default:
+			t.comment.WriteString("--")
+			t.reconsume(comment_state)
+		}
+
+	case comment_end_bang_state:
+		switch c {
+		case '-':
+			t.comment.WriteString("--!")
+			t.state = comment_end_dash_state
+		case '>':
+			t.state = data_state
+			t.emit_comment()
+		case eof:
+			t.emit_comment()
+			t.emit_eof()
+		default:
+			t.comment.WriteString("--!")
+			t.reconsume(comment_state)
+		}
+
+	case doctype_state:
+		t.tag = &html_token{kind: DOCTYPE_TOKEN}
+		if c == eof {
+			t.emit(*t.tag)
+			t.emit_eof()
+		} else {
+			t.reconsume(before_doctype_name_state)
+		}
+
+	case before_doctype_name_state, doctype_name_state:
+		switch {
+		case is_tag_whitespace(c) && t.state == before_doctype_name_state:
+		case is_tag_whitespace(c):
+			t.state = after_doctype_name_state
+		case c == '>':
+			t.state = data_state
+			t.emit(*t.tag)
+		case c == eof:
+			t.emit(*t.tag)
+			t.emit_eof()
+		case c == 0:
+			t.tag.name += "�"
+			t.state = doctype_name_state
+		default:
+			t.tag.name += string(to_ascii_lower(c))
+			t.state = doctype_name_state
+		}
+
+	case after_doctype_name_state:
+		// public and system identifiers, a '>' ends the doctype even
+		// inside quotes
+		switch c {
+		case '>':
+			t.state = data_state
+			t.emit(*t.tag)
+		case eof:
+			t.emit(*t.tag)
+			t.emit_eof()
+		}
+
+	case cdata_section_state:
+		switch c {
+		case ']':
+			t.state = cdata_section_bracket_state
+		case eof:
+			t.emit_eof()
+		default:
+			t.emit_char(c)
+		}
+
+	case cdata_section_bracket_state:
+		if c == ']' {
+			t.state = cdata_section_end_state
+		} else {
+			t.emit_char(']')
+			t.reconsume(cdata_section_state)
+		}
+
+	case cdata_section_end_state:
+		switch c {
+		case ']':
+			t.emit_char(']')
+		case '>':
+			t.state = data_state
+		default:
+			t.emit_chars("]]")
+			t.reconsume(cdata_section_state)
+		}
+
+	case character_reference_state:
+		switch {
+		case is_ascii_alphanumeric(c):
+			t.reconsume(named_character_reference_state)
+		case c == '#':
+			t.temp.WriteRune(c)
+			t.state = numeric_character_reference_state
+		default:
+			t.flush_character_reference()
+			t.reconsume(t.return_state)
+		}
+
+	case named_character_reference_state:
+		t.pos--
+		name, value := t.match_character_reference()
+		if name == "" {
+			t.state = ambiguous_ampersand_state
+			t.flush_character_reference()
+			break
+		}
+		t.temp.WriteString(name)
+		t.pos += len([]rune(name))
+		var next rune = eof
+		if t.pos < len(t.input) {
+			next = t.input[t.pos]
+		}
+		// legacy references without semicolon are kept in attributes
+		// when they could be part of a URL query, like ?a=1&copy=2
+		if !strings.HasSuffix(name, ";") && t.in_attribute() && (next == '=' || is_ascii_alphanumeric(next)) {
+			t.flush_character_reference()
+		} else {
+			t.temp.Reset()
+			t.temp.WriteString(value)
+			t.flush_character_reference()
+		}
+		t.state = t.return_state
+
+	case ambiguous_ampersand_state:
+		if is_ascii_alphanumeric(c) {
+			if t.in_attribute() {
+				t.attr_value.WriteRune(c)
+			} else {
+				t.emit_char(c)
+			}
+		} else {
+			t.reconsume(t.return_state)
+		}
+
+	case numeric_character_reference_state:
+		t.character_code = 0
+		if c == 'x' || c == 'X' {
+			t.temp.WriteRune(c)
+			t.state = hexadecimal_character_reference_start_state
+		} else {
+			t.reconsume(decimal_character_reference_start_state)
+		}
+
+	case hexadecimal_character_reference_start_state, decimal_character_reference_start_state:
+		if is_ascii_hex_digit(c) && t.state == hexadecimal_character_reference_start_state {
+			t.reconsume(hexadecimal_character_reference_state)
+		} else if '0' <= c && c <= '9' {
+			t.reconsume(decimal_character_reference_state)
+		} else {
+			t.flush_character_reference()
+			t.reconsume(t.return_state)
+		}
+
+	case hexadecimal_character_reference_state, decimal_character_reference_state:
+		base := 10
+		if t.state == hexadecimal_character_reference_state {
+			base = 16
+		}
+		if digit := hex_digit_value(c); digit >= 0 && digit < base {
+			// anything above the largest code point is replaced anyway
+			t.character_code = min(t.character_code*base+digit, 0x110000)
+			break
+		}
+		t.temp.Reset()
+		t.temp.WriteRune(numeric_character_reference(t.character_code))
+		t.flush_character_reference()
+		if c == ';' {
+			t.state = t.return_state
+		} else {
+			t.reconsume(t.return_state)
+		}
+	}
+}
+
+func (t *HTMLTokenizer) start_character_reference() {
+	t.return_state = t.state
+	t.temp.Reset()
+	t.temp.WriteRune('&')
+	t.state = character_reference_state
+}
+
+func (t *HTMLTokenizer) in_attribute() bool {
+	switch t.return_state {
+	case attribute_value_double_quoted_state, attribute_value_single_quoted_state, attribute_value_unquoted_state:
+		return true
+	}
+	return false
+}
+
+// flush_character_reference adds what is in the temporary buffer to the
+// attribute value or text the reference is in.
+func (t *HTMLTokenizer) flush_character_reference() {
+	if t.in_attribute() {
+		t.attr_value.WriteString(t.temp.String())
+	} else {
+		t.emit_chars(t.temp.String())
+	}
+	t.temp.Reset()
+}
+
+// match_character_reference finds the longest name of a character
+// reference the input continues with.
+func (t *HTMLTokenizer) match_character_reference() (string, string) {
+	for length := min(LONGEST_CHARACTER_REFERENCE, len(t.input)-t.pos); length > 0; length-- {
+		name := string(t.input[t.pos : t.pos+length])
+		if value, ok := NAMED_CHARACTER_REFERENCES[name]; ok {
+			return name, value
+		}
+	}
+	return "", ""
+}
+
+func numeric_character_reference(code int) rune {
+	if replacement, ok := C1_CHARACTER_REFERENCES[code]; ok {
+		return replacement
+	}
+	if code == 0 || code > 0x10FFFF || 0xD800 <= code && code <= 0xDFFF {
+		return '�'
+	}
+	return rune(code)
+}
+
+func is_ascii_alphanumeric(c rune) bool {
+	return is_ascii_alpha(c) || '0' <= c && c <= '9'
+}
+
+func is_ascii_hex_digit(c rune) bool {
+	return hex_digit_value(c) >= 0
+}
+
+func hex_digit_value(c rune) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	case 'A' <= c && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
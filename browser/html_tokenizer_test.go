@@ -0,0 +1,142 @@
+package browser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// tokenize renders the tokens of input compactly, e.g. <p class=a>, "text",
+// </p>, <!--comment--> and <!doctype html>.
+func tokenize(input string) []string {
+	tokenizer := NewHTMLTokenizer(input)
+	parser := &HTMLParser{tokenizer: tokenizer}
+	out := []string{}
+	for {
+		token := tokenizer.next()
+		switch token.kind {
+		case CHARACTER_TOKEN:
+			out = append(out, fmt.Sprintf("%q", token.data))
+		case START_TAG_TOKEN:
+			attributes := []string{}
+			for name, value := range token.attributes {
+				attributes = append(attributes, " "+name+"="+value)
+			}
+			slices.Sort(attributes)
+			closing := ""
+			if token.self_closing {
+				closing = "/"
+			}
+			out = append(out, "<"+token.name+strings.Join(attributes, "")+closing+">")
+			parser.switch_tokenizer(token.name)
+		case END_TAG_TOKEN:
+			out = append(out, "</"+token.name+">")
+		case COMMENT_TOKEN:
+			out = append(out, "<!--"+token.data+"-->")
+		case DOCTYPE_TOKEN:
+			out = append(out, "<!doctype "+token.name+">")
+		case EOF_TOKEN:
+			return out
+		}
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`<p>Hello</p>`, []string{"<p>", `"Hello"`, "</p>"}},
+		{`<A HREF="x">`, []string{"<a href=x>"}},
+		{`<a title="1 > 0">x</a>`, []string{"<a title=1 > 0>", `"x"`, "</a>"}},
+		{`<a title='"' b=c d>`, []string{`<a b=c d= title=">`}},
+		{`<a x=1 x=2>`, []string{"<a x=1>"}},
+		{`<br/><img src=a />`, []string{"<br/>", "<img src=a/>"}},
+		{`<!-- <p>not a tag</p> -->x`, []string{"<!-- <p>not a tag</p> -->", `"x"`}},
+		{`<!---->a<!-->b`, []string{"<!---->", `"a"`, "<!---->", `"b"`}},
+		{`<!--a--!>b`, []string{"<!--a-->", `"b"`}},
+		{`<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN">x`, []string{"<!doctype html>", `"x"`}},
+		{`<?xml version="1.0"?>x`, []string{"<!--?xml version=\"1.0\"?-->", `"x"`}},
+		{`<![CDATA[x]]>`, []string{"<!--[CDATA[x]]-->"}},
+		{`a < b <3 </`, []string{`"a < b <3 </"`}},
+		{`</>x</ p>`, []string{`"x"`, "<!-- p-->"}},
+		{"a\r\nb\rc", []string{`"a\nb\nc"`}},
+		{`<script>if (a < b && c > d) x = "</p>";</script>`,
+			[]string{"<script>", `"if (a < b && c > d) x = \"</p>\";"`, "</script>"}},
+		{`<script><!-- <script>x</script> --></script>`,
+			[]string{"<script>", `"<!-- <script>x</script> -->"`, "</script>"}},
+		{`<script></SCRIPT >x`, []string{"<script>", "</script>", `"x"`}},
+		{`<textarea><b>bold</b></textarea>`, []string{"<textarea>", `"<b>bold</b>"`, "</textarea>"}},
+		{`<style>p > a { }</style>`, []string{"<style>", `"p > a { }"`, "</style>"}},
+		{`<title>a</titles></title>`, []string{"<title>", `"a</titles>"`, "</title>"}},
+		{`<p class="unterminated`, []string{}},
+	}
+	for _, test := range tests {
+		if got := tokenize(test.input); !slices.Equal(got, test.want) {
+			t.Errorf("tokenize(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseRawText(t *testing.T) {
+	html := `<script>if (a<b) document.write("<p>")</script><p title="a>b">text<!-- <p>c</p> --></p>`
+	root := NewHTMLParser(html).Parse()
+
+	paragraphs := 0
+	var script, title, text string
+	for _, node := range TreeToList(root) {
+		if element, ok := node.Token.(ElementToken); ok && element.Tag == "p" {
+			paragraphs++
+			title = element.Attributes["title"]
+			text = node.Children[0].Token.(TextToken).Text
+		} else if ok && element.Tag == "script" {
+			script = node.Children[0].Token.(TextToken).Text
+		}
+	}
+	if paragraphs != 1 || title != "a>b" || text != "text" {
+		t.Errorf("Expected one paragraph with title a>b, got %d with %q and %q", paragraphs, title, text)
+	}
+	if script != `if (a<b) document.write("<p>")` {
+		t.Errorf("Expected the script to be kept as text, got %q", script)
+	}
+}
+
+func TestParseCDATA(t *testing.T) {
+	root := NewHTMLParser(`<svg><![CDATA[a<b]]></svg>`).Parse()
+	var text string
+	for _, node := range TreeToList(root) {
+		if token, ok := node.Token.(TextToken); ok {
+			text += token.Text
+		}
+	}
+	if text != "a<b" {
+		t.Errorf("Expected CDATA in SVG to be text, got %q", text)
+	}
+}
+
+func TestCharacterReferences(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`&amp; &lt;p&gt; a&nbsp;b`, []string{`"& <p> a\u00a0b"`}},
+		{`&#8212; &#x1F600; &#X41;&#65`, []string{`"— 😀 AA"`}},
+		{`&#0; &#xD800; &#x110000; &#99999999999999999999;`, []string{`"� � � �"`}},
+		{`&#x80;&#150;&#x81;`, []string{`"€–\u0081"`}},
+		{`&NotEqualTilde; &CounterClockwiseContourIntegral;`, []string{`"≂̸ ∳"`}},
+		// legacy references work without semicolon, others don't
+		{`&copy 2024 &ampx &notin; &notin &hellip`, []string{`"© 2024 &x ∉ ¬in &hellip"`}},
+		{`&; & x &# &#x; &bogus;`, []string{`"&; & x &# &#x; &bogus;"`}},
+		{`<a href="?a=1&copy=2&amp;b&lt=3" title='&lt;&#39;&quot x'>`, []string{`<a href=?a=1&copy=2&b&lt=3 title=<'" x>`}},
+		{`<a title=&amp;&gt>`, []string{"<a title=&>>"}},
+		{`<textarea>&lt;b&gt;</textarea>`, []string{"<textarea>", `"<b>"`, "</textarea>"}},
+		{`<script>a &amp;&amp; b</script>`, []string{"<script>", `"a &amp;&amp; b"`, "</script>"}},
+		{`<!-- &amp; -->`, []string{"<!-- &amp; -->"}},
+	}
+	for _, test := range tests {
+		if got := tokenize(test.input); !slices.Equal(got, test.want) {
+			t.Errorf("tokenize(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"golang.org/x/image/font"
 )
@@ -142,6 +143,9 @@ func (l *BlockLayout) Layout() {
 				if element, ok := child.Token.(ElementToken); ok && slices.Contains([]string{"head", "style", "script"}, element.Tag) {
 					continue
 				}
+				if is_input(child, "hidden") {
+					continue
+				}
 				next := NewLayoutNode(NewBlockLayout(), child, l.wrap, previous, l.wrap.Frame)
 				children = append(children, next)
 				previous = next
@@ -233,7 +237,7 @@ func (l *BlockLayout) PaintEffects(cmds []Command) []Command {
 }
 
 func (d *BlockLayout) ShouldPaint() bool {
-	if _, ok := d.wrap.Node.Token.(TextToken); ok || !slices.Contains([]string{"input", "button", "img", "iframe"}, d.wrap.Node.Token.(ElementToken).Tag) {
+	if _, ok := d.wrap.Node.Token.(TextToken); ok || !slices.Contains(append([]string{"img", "iframe"}, WIDGET_TAGS...), d.wrap.Node.Token.(ElementToken).Tag) {
 		return true
 	}
 	return false
@@ -252,7 +256,7 @@ func (l *BlockLayout) layout_mode() string {
 				return "block"
 			}
 		}
-		if len(l.wrap.Node.Children) > 0 || slices.Contains([]string{"input", "img", "iframe"}, l.wrap.Node.Token.(ElementToken).Tag) {
+		if len(l.wrap.Node.Children) > 0 || slices.Contains(append([]string{"img", "iframe"}, WIDGET_TAGS...), l.wrap.Node.Token.(ElementToken).Tag) {
 			return "inline"
 		} else {
 			return "block"
@@ -291,7 +295,9 @@ func (l *BlockLayout) recurse(node *HtmlNode) {
 		element, _ := node.Token.(ElementToken)
 		if element.Tag == "br" {
 			l.new_line()
-		} else if element.Tag == "input" || element.Tag == "button" {
+		} else if is_input(node, "hidden") {
+			// not rendered
+		} else if slices.Contains(WIDGET_TAGS, element.Tag) {
 			l.input(node)
 		} else if element.Tag == "img" {
 			l.image(node)
@@ -314,7 +320,7 @@ func (l *BlockLayout) word(node *HtmlNode, word string) {
 
 func (l *BlockLayout) input(node *HtmlNode) {
 	zoom := l.wrap.Zoom.Read(l.wrap.Children)
-	w := dpx(INPUT_WIDTH_PX, zoom)
+	w := dpx(control_width(node), zoom)
 	l.add_inline_child(node, w, "input", "", l.wrap.Frame)
 }
 
@@ -640,10 +646,10 @@ func (l *InputLayout) Layout() {
 	l.EmbedLayout.Layout()
 
 	zoom := l.wrap.Zoom.Read(l.wrap.Width)
-	l.wrap.Width.Set(dpx(INPUT_WIDTH_PX, zoom))
+	l.wrap.Width.Set(dpx(control_width(l.wrap.Node), zoom))
 
 	font := l.wrap.Font.Read(l.wrap.Height)
-	l.wrap.Height.Set(fnt.Linespace(font))
+	l.wrap.Height.Set(fnt.Linespace(font) * float64(control_rows(l.wrap.Node)))
 
 	height := l.wrap.Height.Read(l.wrap.Ascent)
 	l.wrap.Ascent.Set(height)
@@ -663,13 +669,31 @@ func (l *InputLayout) Paint() []Command {
 		if err != nil {
 			actualRadius = 0 // Default radius size if parsing fails
 		}
+		if is_input(l.wrap.Node, "radio") {
+			actualRadius = l.wrap.Width.Get() / 2
+		}
 		rect := NewDrawRRect(l.wrap.self_rect(), actualRadius, bgcolor)
 		cmds = append(cmds, rect)
 	}
 
+	color := l.wrap.Node.Style["color"].Get()
+	if is_checkable(l.wrap.Node) {
+		return append(cmds, l.paint_check(color)...)
+	} else if l.wrap.Node.Token.(ElementToken).Tag == "textarea" {
+		return append(cmds, l.paint_textarea(color)...)
+	}
+
 	var text string
 	if l.wrap.Node.Token.(ElementToken).Tag == "input" {
-		text = l.wrap.Node.Token.(ElementToken).Attributes["value"]
+		text = input_value(l.wrap.Node)
+		if is_input(l.wrap.Node, "password") {
+			text = strings.Repeat("*", utf8.RuneCountInString(text))
+		}
+	} else if l.wrap.Node.Token.(ElementToken).Tag == "select" {
+		if option := selected_option(l.wrap.Node); option != nil {
+			text = option_label(option)
+		}
+		cmds = append(cmds, l.paint_arrow(color)...)
 	} else if l.wrap.Node.Token.(ElementToken).Tag == "button" {
 		if len(l.wrap.Node.Children) == 1 {
 			if txt, ok := l.wrap.Node.Children[0].Token.(TextToken); ok {
@@ -682,16 +706,62 @@ func (l *InputLayout) Paint() []Command {
 		}
 	}
 
-	color := l.wrap.Node.Style["color"].Get()
 	cmds = append(cmds, NewDrawText(l.wrap.X.Get(), l.wrap.Y.Get(), text, l.wrap.Font.Get(), color))
 
-	if l.wrap.Node.Token.(ElementToken).IsFocused && l.wrap.Node.Token.(ElementToken).Tag == "input" {
+	if l.wrap.Node.Token.(ElementToken).IsFocused && is_text_input(l.wrap.Node) {
 		cmds = append(cmds, NewDrawCursor(l.wrap, fnt.Measure(l.wrap.Font.Get(), text)))
 	}
 
 	return cmds
 }
 
+// paint_check draws the mark of a checked checkbox or radio button.
+func (l *InputLayout) paint_check(color string) []Command {
+	cmds := []Command{NewDrawOutline(l.wrap.self_rect(), color, 1)}
+	if !is_checked(l.wrap.Node) {
+		return cmds
+	}
+	x, y := l.wrap.X.Get(), l.wrap.Y.Get()
+	w, h := l.wrap.Width.Get(), l.wrap.Height.Get()
+	if is_input(l.wrap.Node, "radio") {
+		dot := rect.NewRect(x+w/4, y+h/2-w/4, x+w*3/4, y+h/2+w/4)
+		return append(cmds, NewDrawRRect(dot, w/4, color))
+	}
+	return append(cmds,
+		NewDrawLine(x+w*0.2, y+h*0.5, x+w*0.4, y+h*0.7, color, 2),
+		NewDrawLine(x+w*0.4, y+h*0.7, x+w*0.8, y+h*0.3, color, 2))
+}
+
+// paint_textarea draws as many of the last lines of a textarea as fit.
+func (l *InputLayout) paint_textarea(color string) []Command {
+	cmds := []Command{}
+	font := l.wrap.Font.Get()
+	lines := strings.Split(textarea_value(l.wrap.Node), "\n")
+	first := max(len(lines)-control_rows(l.wrap.Node), 0)
+	y := l.wrap.Y.Get()
+	for _, line := range lines[first:] {
+		cmds = append(cmds, NewDrawText(l.wrap.X.Get(), y, line, font, color))
+		y += fnt.Linespace(font)
+	}
+	if l.wrap.Node.Token.(ElementToken).IsFocused {
+		x := l.wrap.X.Get() + fnt.Measure(font, lines[len(lines)-1])
+		y -= fnt.Linespace(font)
+		cmds = append(cmds, NewDrawLine(x, y, x, y+fnt.Linespace(font), "red", 1))
+	}
+	return cmds
+}
+
+// paint_arrow draws the arrow at the end of a select.
+func (l *InputLayout) paint_arrow(color string) []Command {
+	right := l.wrap.X.Get() + l.wrap.Width.Get()
+	middle := l.wrap.Y.Get() + l.wrap.Height.Get()/2
+	size := l.wrap.Height.Get() / 6
+	return []Command{
+		NewDrawLine(right-4*size, middle-size, right-3*size, middle+size, color, 1),
+		NewDrawLine(right-3*size, middle+size, right-2*size, middle-size, color, 1),
+	}
+}
+
 func (l *InputLayout) PaintEffects(cmds []Command) []Command {
 	cmds = paint_visual_effects(l.wrap.Node, cmds, l.wrap.self_rect())
 	paint_outline(l.wrap.Node, &cmds, l.wrap.self_rect(), l.wrap.Zoom.Get())
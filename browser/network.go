@@ -0,0 +1,57 @@
+package browser
+
+import (
+	"fmt"
+	"gowser/task"
+	u "gowser/url"
+	"image"
+)
+
+var (
+	// stands in for images that are still loading
+	LOADING_IMAGE image.Image = image.NewRGBA(image.Rect(0, 0, 1, 1))
+)
+
+// fetch loads url on its own goroutine, so that all subresources of a
+// document load in parallel. The result is handed to callback as a task
+// on the tab's thread, unless the frame navigated away in the meantime.
+// Every redirect is passed through check, see redirect_check.
+func (f *Frame) fetch(url *u.URL, referrer *u.URL, check func(*u.URL) error, callback func(*u.Response, error)) {
+	ctx := f.ctx
+	request_ctx := u.WithRedirectCheck(ctx, check)
+	f.tab.pending_loads.Add(1)
+	go func() {
+		defer f.tab.pending_loads.Add(-1)
+		response, err := f.tab.browser.Fetcher.Fetch(request_ctx, url, referrer, nil)
+		if ctx.Err() != nil {
+			return
+		}
+		task := task.NewTask(func(i ...interface{}) {
+			if ctx.Err() == nil {
+				callback(response, err)
+			}
+		}, url)
+		f.tab.TaskRunner.ScheduleTask(task)
+	}()
+}
+
+// redirect_check checks where a request is redirected to against the same
+// CSP directive as the request itself, like script-src for a script.
+func (f *Frame) redirect_check(directive, nonce string) func(*u.URL) error {
+	csp := f.csp
+	return func(url *u.URL) error {
+		if !csp.allows_url(directive, url, nonce) {
+			return fmt.Errorf("redirect to %s blocked by CSP", url)
+		}
+		return nil
+	}
+}
+
+// check_status turns HTTP errors into errors, since the error page a
+// server sends along is no use as a script, stylesheet or image.
+func check_status(response *u.Response, err error) error {
+	if err == nil && response.Status >= 400 {
+		return fmt.Errorf("%d %s", response.Status, response.Reason)
+	}
+	return err
+}
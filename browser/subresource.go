@@ -0,0 +1,350 @@
+package browser
+
+import (
+	"bytes"
+	"fmt"
+	"gowser/task"
+	u "gowser/url"
+	"image"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// the MIME types a script element may give for JavaScript, scripts
+	// of other types, like data blocks or templates, are not run
+	JAVASCRIPT_TYPES = []string{
+		"application/ecmascript", "application/javascript", "application/x-ecmascript",
+		"application/x-javascript", "text/ecmascript", "text/javascript", "text/javascript1.0",
+		"text/javascript1.1", "text/javascript1.2", "text/javascript1.3", "text/javascript1.4",
+		"text/javascript1.5", "text/jscript", "text/livescript", "text/x-ecmascript", "text/x-javascript",
+	}
+)
+
+// load_subresources loads the scripts, style sheets, images and iframes
+// inside nodes, both for a new document and for nodes that scripts
+// insert later.
+func (f *Frame) load_subresources(nodes *HtmlNode) {
+	f.load_scripts(f.scripts(nodes))
+	f.load_style_sheets(f.style_sheets(nodes))
+	f.load_images(f.images(nodes))
+	f.load_iframes(f.frames(nodes))
+}
+
+// nodes_inserted loads what a script added to the document. It runs as
+// a task of its own, so that nodes the script takes out again right away
+// are not loaded.
+func (f *Frame) nodes_inserted(nodes []*HtmlNode) {
+	task := task.NewTask(func(i ...interface{}) {
+		if f.Nodes == nil {
+			return
+		}
+		document := TreeToList(f.Nodes)
+		for _, node := range nodes {
+			if slices.Contains(document, node) {
+				f.load_subresources(node)
+			}
+		}
+		f.update_rules()
+	}, nodes)
+	f.tab.TaskRunner.ScheduleTask(task)
+}
+
+// nodes_removed stops the iframes among nodes that a script took out of
+// the document, and forgets their style sheets.
+func (f *Frame) nodes_removed(nodes []*HtmlNode) {
+	for _, node := range nodes {
+		for _, iframe := range f.frames(node) {
+			if iframe.Frame != nil {
+				iframe.Frame.teardown()
+				iframe.Frame = nil
+			}
+		}
+		for _, style_sheet := range f.style_sheets(node) {
+			delete(f.sheet_rules, style_sheet)
+		}
+	}
+}
+
+// load_scripts fetches scripts in parallel but runs them in order, inline
+// ones included.
+func (f *Frame) load_scripts(scripts []*HtmlNode) {
+	url := f.url
+	script_names := make([]string, len(scripts))
+	script_bodies := make([]*string, len(scripts))
+	script_done := make([]bool, len(scripts))
+	next_script := 0
+	run_scripts := func() {
+		for ; next_script < len(scripts) && script_done[next_script]; next_script++ {
+			if script_bodies[next_script] == nil {
+				continue
+			}
+			script := script_names[next_script]
+			start := time.Now()
+			f.tab.browser.measure.Time("eval_" + script)
+			f.js.Run(script, *script_bodies[next_script], f.window_id)
+			f.tab.browser.measure.Stop("eval_" + script)
+			fmt.Println("Eval "+script+" took:", time.Since(start))
+		}
+	}
+	for i, script := range scripts {
+		script.ScriptStarted = true
+		script_done[i] = true
+		element := script.Token.(ElementToken)
+		nonce := element.Attributes["nonce"]
+		src, external := element.Attributes["src"]
+		if !external {
+			script_names[i] = fmt.Sprintf("%s (inline script %d)", url, i)
+			if !f.csp.allows_inline("script-src", nonce) {
+				fmt.Println("Blocked inline script due to CSP")
+				continue
+			}
+			body := inline_text(script)
+			script_bodies[i] = &body
+			continue
+		}
+		script_url, err := url.Resolve(src)
+		if err != nil {
+			fmt.Println("Resolving URL failed:", err.Error())
+			continue
+		}
+		script_names[i] = script_url.String()
+		if !f.csp.allows_url("script-src", script_url, nonce) {
+			fmt.Println("Blocked script", script_url, "due to CSP")
+			continue
+		}
+		fmt.Println("Loading script:", script_url)
+		script_done[i] = false
+		f.fetch(script_url, url, f.redirect_check("script-src", nonce), func(response *u.Response, err error) {
+			if err = check_status(response, err); err != nil {
+				fmt.Println("Error loading script:", err)
+			} else {
+				body := decode_subresource(response, f.encoding, false)
+				script_bodies[i] = &body
+			}
+			script_done[i] = true
+			run_scripts()
+		})
+	}
+	run_scripts()
+}
+
+// load_style_sheets parses inline style sheets right away and fetches
+// the others, each is applied as soon as it is there.
+func (f *Frame) load_style_sheets(style_sheets []*HtmlNode) {
+	url := f.url
+	for _, style_sheet := range style_sheets {
+		element := style_sheet.Token.(ElementToken)
+		nonce := element.Attributes["nonce"]
+		if element.Tag == "style" {
+			if !f.csp.allows_inline("style-src", nonce) {
+				fmt.Println("Blocked inline style sheet due to CSP")
+				continue
+			}
+			f.sheet_rules[style_sheet] = NewCSSParser(inline_text(style_sheet)).Parse()
+			continue
+		}
+		style_url, err := url.Resolve(element.Attributes["href"])
+		if err != nil {
+			fmt.Println("Resolving URL failed:", err.Error())
+			continue
+		}
+		if !f.csp.allows_url("style-src", style_url, nonce) {
+			fmt.Println("Blocked stylesheet", style_url, "due to CSP")
+			continue
+		}
+		fmt.Println("Loading stylesheet:", style_url)
+		f.fetch(style_url, url, f.redirect_check("style-src", nonce), func(response *u.Response, err error) {
+			if err = check_status(response, err); err != nil {
+				fmt.Println("Error loading stylesheet:", err)
+				return
+			}
+			f.sheet_rules[style_sheet] = NewCSSParser(decode_subresource(response, f.encoding, true)).Parse()
+			f.update_rules()
+		})
+	}
+	f.update_rules()
+}
+
+// update_rules puts the rules of the style sheets in the document in
+// document order, which is their order in the cascade.
+func (f *Frame) update_rules() {
+	sheets := [][]Rule{DEFAULT_STYLE_SHEET}
+	if f.Nodes != nil {
+		for _, style_sheet := range f.style_sheets(f.Nodes) {
+			sheets = append(sheets, f.sheet_rules[style_sheet])
+		}
+	}
+	f.rules = slices.Concat(sheets...)
+	f.SetNeedsRender()
+}
+
+func (f *Frame) load_images(images []*HtmlNode) {
+	url := f.url
+	for _, img := range images {
+		img.Image = LOADING_IMAGE
+		elt, _ := img.Token.(ElementToken)
+		src := elt.Attributes["src"]
+		image_url, err := url.Resolve(src)
+		if err != nil {
+			fmt.Println("Resolving URL failed:", err.Error())
+			img.Image = BROKEN_IMAGE
+			continue
+		}
+		if !f.allowed_request("img-src", image_url) {
+			fmt.Println("Blocked image", image_url, "due to CSP")
+			img.Image = BROKEN_IMAGE
+			continue
+		}
+		fmt.Println("Loading image:", image_url)
+		f.fetch(image_url, url, f.redirect_check("img-src", ""), func(response *u.Response, err error) {
+			if err = check_status(response, err); err != nil {
+				fmt.Println("Error loading image:", err)
+				img.Image = BROKEN_IMAGE
+			} else if image, _, err := image.Decode(bytes.NewReader(response.Body)); err != nil {
+				fmt.Println("Error decoding image:", err)
+				img.Image = BROKEN_IMAGE
+			} else {
+				img.Image = image
+			}
+			f.image_changed(img)
+		})
+	}
+}
+
+// load_iframes creates a frame for each iframe and loads it.
+func (f *Frame) load_iframes(iframes []*HtmlNode) {
+	for _, iframe := range iframes {
+		elt, _ := iframe.Token.(ElementToken)
+		src := elt.Attributes["src"]
+		iframe_url, err := f.url.Resolve(src)
+		if err != nil {
+			fmt.Println("Resolving URL failed:", err.Error())
+			continue
+		}
+		if !f.allowed_request("frame-src", iframe_url) {
+			fmt.Println("Blocked iframe", iframe_url, "due to CSP")
+			iframe.Frame = nil
+			continue
+		}
+		child := NewFrame(f.tab, f, iframe)
+		iframe.Frame = child
+		// iframes inserted later are not part of the history entry
+		if entry := f.tab.restoring_entry(child); !f.Loaded && entry != nil && entry.url != nil {
+			// coming back through history, show what the iframe showed then
+			if entry.response != nil || entry.payload != nil {
+				child.load_entry(entry)
+				continue
+			}
+			iframe_url = entry.url
+		}
+		child.start_loading()
+		fmt.Println("Loading iframe:", iframe_url)
+		child.fetch(iframe_url, nil, f.redirect_check("frame-src", ""), func(response *u.Response, err error) {
+			if response := child.handle_load_error(iframe_url, response, err); response != nil {
+				child.load_response(response)
+			}
+		})
+	}
+}
+
+// teardown stops all loads and scripts of a frame that is no longer shown,
+// and of the frames inside it.
+func (f *Frame) teardown() {
+	f.teardown_frames()
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.Loaded = false
+	delete(f.tab.window_id_to_frame, f.window_id)
+	if f.tab.focused_frame == f {
+		f.tab.focused_frame = nil
+		f.tab.focus = nil
+	}
+}
+
+// teardown_frames tears down the frames of the iframes in the document.
+func (f *Frame) teardown_frames() {
+	if f.Nodes == nil {
+		return
+	}
+	for _, iframe := range f.frames(f.Nodes) {
+		if iframe.Frame != nil {
+			iframe.Frame.teardown()
+		}
+	}
+}
+
+// image_changed lays out img again after its image finished loading.
+func (f *Frame) image_changed(img *HtmlNode) {
+	obj := img.LayoutObject
+	if obj == nil {
+		return
+	}
+	obj.Width.Mark()
+	obj.Height.Mark()
+	for obj != nil {
+		if _, isBlock := obj.Layout.(*BlockLayout); isBlock {
+			obj.Children.Mark()
+			break
+		}
+		obj = obj.Parent
+	}
+	f.SetNeedsRender()
+}
+
+// allowed_request checks a request against the fetch directive of the
+// content security policy, like img-src.
+func (f *Frame) allowed_request(directive string, url *u.URL) bool {
+	return f.csp.allows_url(directive, url, "")
+}
+
+// scripts returns the script elements to run, external and inline. A
+// script runs only once.
+func (f *Frame) scripts(nodes *HtmlNode) []*HtmlNode {
+	scripts := []*HtmlNode{}
+	for _, node := range TreeToList(nodes) {
+		if element, ok := node.Token.(ElementToken); ok && element.Tag == "script" && !node.ScriptStarted &&
+			is_javascript(element.Attributes["type"]) {
+			scripts = append(scripts, node)
+		}
+	}
+	return scripts
+}
+
+// is_javascript checks the type attribute of a script, a missing or
+// empty one means JavaScript.
+func is_javascript(script_type string) bool {
+	script_type = strings.ToLower(strings.TrimSpace(script_type))
+	return script_type == "" || slices.Contains(JAVASCRIPT_TYPES, script_type)
+}
+
+// style_sheets returns the link elements of external style sheets and
+// the style elements, in document order.
+func (f *Frame) style_sheets(nodes *HtmlNode) []*HtmlNode {
+	style_sheets := []*HtmlNode{}
+	for _, node := range TreeToList(nodes) {
+		element, ok := node.Token.(ElementToken)
+		if !ok {
+			continue
+		}
+		if _, has_href := element.Attributes["href"]; element.Tag == "link" && element.Attributes["rel"] == "stylesheet" && has_href {
+			style_sheets = append(style_sheets, node)
+		} else if element.Tag == "style" {
+			style_sheets = append(style_sheets, node)
+		}
+	}
+	return style_sheets
+}
+
+// inline_text is the text inside a script or style element.
+func inline_text(node *HtmlNode) string {
+	text := strings.Builder{}
+	for _, child := range node.Children {
+		if token, ok := child.Token.(TextToken); ok {
+			text.WriteString(token.Text)
+		}
+	}
+	return text.String()
+}
@@ -3,11 +3,14 @@ package browser
 import (
 	"bytes"
 	"cmp"
+	"context"
 	"fmt"
 	u "gowser/url"
 	"image"
 	"math"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -41,7 +44,9 @@ type Tab struct {
 
 	url           *u.URL
 	tab_height    float64
-	history       []*u.URL
+	history       *SessionHistory
+	document      int           // counts the documents loaded in the root frame
+	restoring     *HistoryEntry // the entry while going back or forward
 	focus         *HtmlNode
 	focused_frame *Frame
 	// needs_raf_callbacks   bool
@@ -65,49 +70,91 @@ type Tab struct {
 	zoom               float64
 
 	window_id_to_frame map[int]*Frame
+	next_window_id     int
 	origin_to_js       map[string]*JSContext
+
+	// ctx lives as long as the tab, load_ctx until the next navigation
+	ctx           context.Context
+	cancel        context.CancelFunc
+	load_lock     *sync.Mutex
+	load_ctx      context.Context
+	load_cancel   context.CancelFunc
+	pending_loads atomic.Int32
 }
 
 func NewTab(browser *Browser, tab_height float64) *Tab {
 	tab := &Tab{
 		tab_height:         tab_height,
-		history:            make([]*u.URL, 0),
+		history:            NewSessionHistory(),
 		browser:            browser,
 		dark_mode:          browser.dark_mode,
 		window_id_to_frame: make(map[int]*Frame),
 		zoom:               1.0,
 		origin_to_js:       make(map[string]*JSContext),
+		load_lock:          &sync.Mutex{},
 	}
+	tab.ctx, tab.cancel = context.WithCancel(context.Background())
+	tab.load_ctx, tab.load_cancel = context.WithCancel(tab.ctx)
 	tab.TaskRunner = NewTaskRunner(tab)
 	tab.TaskRunner.StartThread()
 	return tab
 }
 
-func (t *Tab) Load(url *u.URL, payload string) {
+func (t *Tab) Load(url *u.URL, payload *u.Payload) {
+	t.save_history_state()
+	t.restoring = nil
+	t.CancelLoads()
 	t.loaded = false
-	t.history = append(t.history, url)
 	t.TaskRunner.ClearPendingTasks()
+	if t.root_frame != nil {
+		t.root_frame.teardown()
+	}
 	t.root_frame = NewFrame(t, nil, nil)
 	t.root_frame.Load(url, payload)
+	t.url = url
+	if t.root_frame.url != nil {
+		// record where redirects took us
+		t.url = t.root_frame.url
+	}
+	t.document++
+	t.history.push(&HistoryEntry{
+		document: t.document,
+		url:      t.url,
+		payload:  payload,
+		response: t.root_frame.cached_response(),
+		frames:   map[string]*HistoryEntry{},
+	})
 	t.root_frame.frame_width = WIDTH
 	t.root_frame.frame_height = t.tab_height
 	t.loaded = true
 }
 
+// CancelLoads aborts everything the current page is still loading. It is
+// safe to call from the browser thread ahead of a navigation.
+func (t *Tab) CancelLoads() {
+	t.load_lock.Lock()
+	defer t.load_lock.Unlock()
+	t.load_cancel()
+	t.load_ctx, t.load_cancel = context.WithCancel(t.ctx)
+}
+
+func (t *Tab) load_context() context.Context {
+	t.load_lock.Lock()
+	defer t.load_lock.Unlock()
+	return t.load_ctx
+}
+
+// Close cancels all loads of the tab and stops its task thread.
+func (t *Tab) Close() {
+	t.cancel()
+	t.TaskRunner.SetNeedsQuit()
+}
+
 func (t *Tab) click(x, y float64) {
 	t.Render()
 	t.root_frame.click(x, y)
 }
 
-func (t *Tab) go_back() {
-	if len(t.history) > 1 {
-		t.history = t.history[:len(t.history)-1] // pop
-		back := t.history[len(t.history)-1]
-		t.history = t.history[:len(t.history)-1] // pop
-		t.Load(back, "")
-	}
-}
-
 func (t *Tab) Render() {
 	t.browser.measure.Time("render")
 
@@ -178,6 +225,17 @@ func (t *Tab) run_animation_frame(scroll *float64) {
 		t.focused_frame.needs_focus_scroll = false
 	}
 
+	for _, frame := range t.window_id_to_frame {
+		if !frame.Loaded {
+			continue
+		}
+		if frame.needs_scroll_restore {
+			frame.restore_scroll()
+		} else if frame.needs_fragment_scroll {
+			frame.scroll_to_fragment()
+		}
+	}
+
 	for _, frame := range t.window_id_to_frame {
 		if frame == t.root_frame {
 			continue
@@ -260,7 +318,7 @@ func (t *Tab) enter() {
 		if t.focused_frame != nil {
 			frame = t.focused_frame
 		}
-		frame.activate_element(t.focus)
+		frame.enter()
 	}
 }
 
@@ -327,8 +385,7 @@ func paint_tree(layout_object *LayoutNode, displayList *[]Command) {
 	*displayList = append(*displayList, cmds...)
 }
 
-func (t *Tab) get_js(url *u.URL) *JSContext {
-	origin := url.Origin()
+func (t *Tab) get_js(origin string) *JSContext {
 	if _, found := t.origin_to_js[origin]; !found {
 		t.origin_to_js[origin] = NewJSContext(t, origin)
 	}
@@ -337,5 +394,8 @@ func (t *Tab) get_js(url *u.URL) *JSContext {
 
 func (t *Tab) post_message(message string, target_window_id int) {
 	frame := t.window_id_to_frame[target_window_id]
+	if frame == nil {
+		return
+	}
 	frame.js.dispatch_post_message(message, target_window_id)
 }
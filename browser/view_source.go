@@ -0,0 +1,218 @@
+package browser
+
+import (
+	"fmt"
+	u "gowser/url"
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// whose content is shown as text up to their end tag
+	VIEW_SOURCE_TEXT_TAGS = append([]string{"title", "textarea"}, RAW_TEXT_TAGS...)
+	VIEW_SOURCE_CSS       = `
+pre { background-color: white; font-family: 'Courier New'; }
+.gutter { color: gray; background-color: #eeeeee; }
+.tag { color: purple; }
+.attribute-name { color: #994500; }
+.attribute-value { color: #1a1aa6; }
+.comment { color: #236e25; font-style: italic; }
+.doctype { color: gray; font-style: italic; }
+.text { color: black; }
+
+@media (prefers-color-scheme: dark) {
+  pre { background-color: #1e1e1e; }
+  .gutter { color: #858585; background-color: #333333; }
+  .tag { color: #569cd6; }
+  .attribute-name { color: #9cdcfe; }
+  .attribute-value { color: #ce9178; }
+  .comment { color: #6a9955; }
+  .doctype { color: #808080; }
+  .text { color: #d4d4d4; }
+}
+`
+)
+
+// A source_span is a piece of source code and the class it is
+// highlighted with, none for the spaces and punctuation inside tags.
+type source_span struct {
+	class string
+	text  string
+}
+
+// NewViewSourcePage shows the source of response with line numbers, HTML
+// is highlighted.
+func NewViewSourcePage(url *u.URL, response *u.Response) *u.Response {
+	if page_url, err := u.NewURL("view-source:" + response.URL.String()); err == nil {
+		// show where redirects took us
+		url = page_url
+	}
+	text, _ := decode_document(response.Headers, response.Body)
+	spans := []source_span{{"text", text}}
+	if content_type := response.Headers["content-type"]; content_type == "" || strings.HasPrefix(content_type, "text/html") {
+		spans = highlight_html(text)
+	}
+
+	lines := [][]source_span{{}}
+	for _, span := range spans {
+		for i, line := range strings.Split(span.text, "\n") {
+			if i > 0 {
+				lines = append(lines, []source_span{})
+			}
+			if line != "" {
+				lines[len(lines)-1] = append(lines[len(lines)-1], source_span{span.class, line})
+			}
+		}
+	}
+
+	out := "<!doctype html>"
+	out += "<title>" + html.EscapeString(url.String()) + "</title>"
+	out += "<style>" + VIEW_SOURCE_CSS + "</style>"
+	out += "<pre>"
+	width := len(strconv.Itoa(len(lines)))
+	for i, line := range lines {
+		if i > 0 {
+			out += "\n"
+		}
+		out += fmt.Sprintf("<span class=gutter>%*d </span>", width, i+1)
+		for _, span := range line {
+			if span.class == "" {
+				out += html.EscapeString(span.text)
+			} else {
+				out += "<span class=" + span.class + ">" + html.EscapeString(span.text) + "</span>"
+			}
+		}
+	}
+	out += "</pre>"
+	return &u.Response{
+		URL:     url,
+		Status:  response.Status,
+		Reason:  response.Reason,
+		Headers: map[string]string{"content-type": "text/html; charset=utf-8"},
+		Body:    []byte(out),
+	}
+}
+
+// highlight_html splits HTML source into tags, attribute names and
+// values, comments and text. It only has to find where each starts, so
+// unlike HTMLTokenizer it keeps the source as it is.
+func highlight_html(source string) []source_span {
+	spans := []source_span{}
+	add := func(class, text string) {
+		if text == "" {
+			return
+		}
+		if last := len(spans) - 1; last >= 0 && spans[last].class == class {
+			spans[last].text += text
+		} else {
+			spans = append(spans, source_span{class, text})
+		}
+	}
+	// index of the first of chars in s, or len(s)
+	index_any := func(s string, chars string) int {
+		if i := strings.IndexAny(s, chars); i != -1 {
+			return i
+		}
+		return len(s)
+	}
+
+	text_tag := ""
+	for len(source) > 0 {
+		switch {
+		case text_tag != "":
+			end := strings.Index(strings.ToLower(source), "</"+text_tag)
+			if end == -1 {
+				end = len(source)
+			}
+			add("text", source[:end])
+			source = source[end:]
+			text_tag = ""
+		case strings.HasPrefix(source, "<!--"):
+			end := strings.Index(source[4:], "-->")
+			if end == -1 {
+				end = len(source)
+			} else {
+				end += 4 + len("-->")
+			}
+			add("comment", source[:end])
+			source = source[end:]
+		case strings.HasPrefix(source, "<!") || strings.HasPrefix(source, "<?"):
+			end := min(index_any(source, ">")+1, len(source))
+			class := "comment"
+			if strings.HasPrefix(strings.ToLower(source), "<!doctype") {
+				class = "doctype"
+			}
+			add(class, source[:end])
+			source = source[end:]
+		case source[0] == '<' && len(source) > 1 && is_ascii_alpha(rune(source[1])),
+			strings.HasPrefix(source, "</") && len(source) > 2 && is_ascii_alpha(rune(source[2])):
+			name_end := index_any(source[1:], " \t\n\f/>") + 1
+			if source[1] == '/' {
+				name_end = index_any(source[2:], " \t\n\f/>") + 2
+			}
+			add("tag", source[:name_end])
+			name := strings.ToLower(source[1:name_end])
+			source = source[name_end:]
+			source = highlight_attributes(source, add)
+			if slices.Contains(VIEW_SOURCE_TEXT_TAGS, name) {
+				text_tag = name
+			}
+		default:
+			end := index_any(source[1:], "<") + 1
+			add("text", source[:end])
+			source = source[end:]
+		}
+	}
+	return spans
+}
+
+// highlight_attributes adds the attributes and the end of a tag, and
+// returns the source after it.
+func highlight_attributes(source string, add func(class, text string)) string {
+	for len(source) > 0 {
+		if strings.HasPrefix(source, ">") || strings.HasPrefix(source, "/>") {
+			end := strings.Index(source, ">") + 1
+			add("tag", source[:end])
+			return source[end:]
+		}
+		trimmed := strings.TrimLeft(source, " \t\n\f")
+		add("", source[:len(source)-len(trimmed)])
+		source = trimmed
+		if len(source) == 0 || strings.HasPrefix(source, ">") || strings.HasPrefix(source, "/>") {
+			continue
+		} else if source[0] == '/' {
+			add("", "/")
+			source = source[1:]
+			continue
+		}
+		name_end := strings.IndexAny(source[1:], " \t\n\f/=>") + 1
+		if name_end == 0 {
+			name_end = len(source)
+		}
+		add("attribute-name", source[:name_end])
+		source = source[name_end:]
+
+		trimmed = strings.TrimLeft(source, " \t\n\f")
+		if !strings.HasPrefix(trimmed, "=") {
+			continue
+		}
+		trimmed = strings.TrimLeft(trimmed[1:], " \t\n\f")
+		add("", source[:len(source)-len(trimmed)])
+		source = trimmed
+		value_end := strings.IndexAny(source, " \t\n\f>")
+		if strings.HasPrefix(source, "\"") || strings.HasPrefix(source, "'") {
+			value_end = strings.IndexByte(source[1:], source[0]) + 2
+			if value_end == 1 {
+				value_end = -1
+			}
+		}
+		if value_end == -1 {
+			value_end = len(source)
+		}
+		add("attribute-value", source[:value_end])
+		source = source[value_end:]
+	}
+	return source
+}
@@ -0,0 +1,81 @@
+package browser
+
+import (
+	"fmt"
+	u "gowser/url"
+	"slices"
+	"testing"
+)
+
+func TestHighlightHTML(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`<p class="a b">Hi</p>`, []string{`tag:<p`, `: `, `attribute-name:class`, `:=`, `attribute-value:"a b"`,
+			`tag:>`, `text:Hi`, `tag:</p>`}},
+		{`<!DOCTYPE html><!-- <p> -->x`, []string{`doctype:<!DOCTYPE html>`, `comment:<!-- <p> -->`, `text:x`}},
+		{`<input disabled value = 'x' data-y=1/>`, []string{`tag:<input`, `: `, `attribute-name:disabled`, `: `,
+			`attribute-name:value`, `: = `, `attribute-value:'x'`, `: `, `attribute-name:data-y`, `:=`,
+			`attribute-value:1/`, `tag:>`}},
+		{`<br /><script>if (a<b) x = "<p>"</script>`, []string{`tag:<br`, `: `, `tag:/><script>`,
+			`text:if (a<b) x = "<p>"`, `tag:</script>`}},
+		{`a < b &amp; <3`, []string{`text:a < b &amp; <3`}},
+		{`<a href="x`, []string{`tag:<a`, `: `, `attribute-name:href`, `:=`, `attribute-value:"x`}},
+	}
+	for _, test := range tests {
+		got := []string{}
+		for _, span := range highlight_html(test.input) {
+			got = append(got, span.class+":"+span.text)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("highlight_html(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestViewSource(t *testing.T) {
+	fetcher := u.NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", "<p id=x>Hello</p>\n<!-- bye -->\n<script>alert(1)</script>")
+	tab := newTestTab(t, fetcher)
+	loadPage(tab, mustURL(t, "view-source:http://example.org/"))
+
+	if requests := fetcher.Requests(); !slices.Equal(requests, []string{"GET http://example.org/"}) {
+		t.Errorf("Expected only the source to be requested, got %v", requests)
+	}
+	if tab.url.String() != "view-source:http://example.org/" {
+		t.Errorf("Expected the view-source URL to be shown, got %s", tab.url)
+	}
+	frame := tab.root_frame
+	source := ""
+	for _, node := range TreeToList(frame.Nodes) {
+		if token, ok := node.Token.(TextToken); ok && isInPre(node) {
+			source += token.Text
+		}
+	}
+	if source != "1 <p id=x>Hello</p>\n2 <!-- bye -->\n3 <script>alert(1)</script>" {
+		t.Errorf("Expected the source with line numbers, got %q", source)
+	}
+	classes := map[string]string{}
+	for _, node := range TreeToList(frame.Nodes) {
+		if element, ok := node.Token.(ElementToken); ok && element.Tag == "span" {
+			classes[element.Attributes["class"]] += inline_text(node)
+		}
+	}
+	want := map[string]string{"gutter": "1 2 3 ", "tag": "<p></p><script></script>", "attribute-name": "id",
+		"attribute-value": "x", "text": "Helloalert(1)", "comment": "<!-- bye -->"}
+	if fmt.Sprint(classes) != fmt.Sprint(want) {
+		t.Errorf("Expected highlighted spans %v, got %v", want, classes)
+	}
+	light, dark := 0, 0
+	for _, rule := range frame.rules[len(DEFAULT_STYLE_SHEET):] {
+		if rule.Media == "dark" {
+			dark++
+		} else {
+			light++
+		}
+	}
+	if light == 0 || dark != light {
+		t.Errorf("Expected dark mode colors for every rule, got %d and %d rules", light, dark)
+	}
+}
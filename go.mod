@@ -9,6 +9,7 @@ require (
 	github.com/mazznoer/csscolorparser v0.1.6
 	github.com/veandco/go-sdl2 v0.4.40
 	golang.org/x/image v0.27.0
+	golang.org/x/text v0.25.0
 	gopkg.in/olebedev/go-duktape.v3 v3.0.0-20210326210528-650f7c854440
 )
 
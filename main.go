@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"gowser/browser"
 	u "gowser/url"
@@ -10,20 +11,63 @@ import (
 )
 
 func main() {
-	err := sdl.Init(sdl.INIT_EVENTS)
-	if err != nil {
-		panic("Could not init sdl")
+	cache_dir := flag.String("cache-dir", "", "directory to store the HTTP cache in, memory only if empty")
+	cookie_file := flag.String("cookie-file", "", "file to keep cookies in between runs, memory only if empty")
+	ca_bundle := flag.String("ca-bundle", "", "PEM file with extra CA certificates to trust")
+	record := flag.String("record", "", "append every request and response to this JSONL file")
+	replay := flag.String("replay", "", "serve requests from a file written by -record instead of the network")
+	dump_dom := flag.Bool("dump-dom", false, "print the parsed DOM of the URL as HTML and exit")
+	flag.Parse()
+	if *cache_dir != "" {
+		u.CACHE = u.NewCache(*cache_dir)
+	}
+	if *cookie_file != "" {
+		u.COOKIE_JAR = u.NewCookieJar(*cookie_file)
+	}
+	if *ca_bundle != "" {
+		if err := u.LoadCABundle(*ca_bundle); err != nil {
+			panic("Could not load CA bundle: " + err.Error())
+		}
 	}
 
 	url_str := "https://browser.engineering/"
-	if len(os.Args) > 1 {
-		url_str = os.Args[1]
+	if flag.NArg() > 0 {
+		url_str = flag.Arg(0)
 	}
-	browser := browser.NewBrowser()
 	url, err := u.NewURL(url_str)
 	if err != nil {
 		panic("Invalid url: " + err.Error())
 	}
+	var fetcher u.Fetcher = &u.NetworkFetcher{}
+	if *replay != "" {
+		fetcher, err = u.LoadRecording(*replay)
+		if err != nil {
+			panic("Could not load recording: " + err.Error())
+		}
+	}
+	if *record != "" {
+		fetcher, err = u.NewRecordingFetcher(fetcher, *record)
+		if err != nil {
+			panic("Could not record: " + err.Error())
+		}
+	}
+	if *dump_dom {
+		html, err := browser.DumpDOM(fetcher, url)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(html)
+		os.Exit(0)
+	}
+
+	// only the window needs SDL, -dump-dom runs without a display
+	err = sdl.Init(sdl.INIT_EVENTS)
+	if err != nil {
+		panic("Could not init sdl")
+	}
+	browser := browser.NewBrowser()
+	browser.Fetcher = fetcher
 	browser.NewTab(url)
 	browser.CompositeRasterAndDraw()
 	mainloop(browser)
@@ -68,6 +112,8 @@ func mainloop(browser *browser.Browser) {
 							browser.ToggleDarkMode()
 						} else if e.Keysym.Sym == sdl.K_LEFT {
 							browser.GoBack()
+						} else if e.Keysym.Sym == sdl.K_RIGHT {
+							browser.GoForward()
 						} else if e.Keysym.Sym == sdl.K_l {
 							browser.FocusAddressbar()
 						} else if e.Keysym.Sym == sdl.K_t {
@@ -77,6 +123,12 @@ func mainloop(browser *browser.Browser) {
 							} else {
 								browser.NewTab(new_url)
 							}
+						} else if e.Keysym.Sym == sdl.K_w {
+							if !browser.CloseTab() {
+								browser.HandleQuit()
+								sdl.Quit()
+								os.Exit(0)
+							}
 						} else if e.Keysym.Sym == sdl.K_TAB {
 							browser.CycleTabs()
 						} else if e.Keysym.Sym == sdl.K_q {
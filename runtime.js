@@ -7,6 +7,15 @@ window.document = {
     }
 }
 
+Object.defineProperty(window.document, 'cookie', {
+    get: function () {
+        return _cookie_get(window._id);
+    },
+    set: function (s) {
+        _cookie_set(window._id, s.toString());
+    }
+});
+
 window.Node = function(handle) { this.handle = handle; }
 
 window.Node.prototype.getAttribute = function (attr) {
@@ -37,11 +46,20 @@ window.Node.prototype.addEventListener = function (type, listener) {
 }
 
 Object.defineProperty(window.Node.prototype, 'innerHTML', {
+    get: function () {
+        return _innerHTML_get(this.handle, window._id);
+    },
     set: function (s) {
         _innerHTML_set(this.handle, s.toString(), window._id);
     }
 });
 
+Object.defineProperty(window.Node.prototype, 'outerHTML', {
+    get: function () {
+        return _outerHTML_get(this.handle, window._id);
+    }
+});
+
 Object.defineProperty(window.Node.prototype, 'style', {
     set: function(s) {
         _style_set(this.handle, s.toString(), window._id);
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"crypto/rand"
 	"fmt"
+	u "gowser/url"
 	"html"
 	"io"
 	"net"
@@ -77,7 +78,14 @@ func handle_connection(conn net.Conn) {
 	}
 
 	var body string
-	if val, ok := headers["content-length"]; ok {
+	if val, ok := headers["transfer-encoding"]; ok && strings.EqualFold(val, "chunked") {
+		buf, _, err := u.ReadChunked(reader)
+		if err != nil {
+			panic("Failed to read request: " + err.Error())
+		}
+		body = string(buf)
+		fmt.Println("\tBody: " + body)
+	} else if val, ok := headers["content-length"]; ok {
 		length, _ := strconv.Atoi(val)
 		buf := make([]byte, length)
 		_, err := io.ReadFull(reader, buf)
@@ -102,8 +110,20 @@ func handle_connection(conn net.Conn) {
 	}
 	status, body := do_request(session, method, url, headers, body)
 
+	var encoding string
+	for _, accepted := range strings.Split(headers["accept-encoding"], ",") {
+		if strings.TrimSpace(accepted) == "gzip" {
+			encoding = "gzip"
+		}
+	}
+
 	response := "HTTP/1.1 " + status + "\r\n"
-	response += "Content-length: " + strconv.Itoa(len(body)) + "\r\n"
+	if encoding != "" {
+		response += "Content-Encoding: " + encoding + "\r\n"
+		response += "Transfer-Encoding: chunked\r\n"
+	} else {
+		response += "Content-length: " + strconv.Itoa(len(body)) + "\r\n"
+	}
 	if _, ok := headers["cookie"]; !ok {
 		template := "Set-Cookie: token=%s; SameSite=Lax\r\n"
 		response += fmt.Sprintf(template, token)
@@ -111,8 +131,17 @@ func handle_connection(conn net.Conn) {
 	csp := "default-src http://localhost:8000"
 	response += "Content-Security-Policy: " + csp + "\r\n"
 	response += "Connection: close\r\n"
-	response += "\r\n" + body
-	conn.Write([]byte(response))
+	response += "\r\n"
+	if encoding != "" {
+		encoded, err := u.EncodeContent(encoding, []byte(body))
+		if err != nil {
+			panic("Failed to encode response: " + err.Error())
+		}
+		conn.Write([]byte(response))
+		u.WriteChunked(conn, encoded, u.CHUNK_SIZE)
+	} else {
+		conn.Write([]byte(response + body))
+	}
 	// closed by defer
 }
 
@@ -0,0 +1,188 @@
+package url
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	CACHE = NewCache("")
+
+	// headers that are never stored, nor taken from a 304 response
+	UNCACHED_HEADERS = []string{"content-length", "transfer-encoding", "content-encoding", "connection", "set-cookie"}
+)
+
+type CacheEntry struct {
+	Status  int
+	Reason  string
+	Headers map[string]string
+	Body    []byte
+	Stored  time.Time
+}
+
+// Cache is an HTTP cache for GET responses. Entries live in memory and,
+// if dir is set, are also written to disk so they survive a restart.
+type Cache struct {
+	lock    *sync.Mutex
+	entries map[string]*CacheEntry
+	dir     string
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{
+		lock:    &sync.Mutex{},
+		entries: make(map[string]*CacheEntry),
+		dir:     dir,
+	}
+}
+
+func (c *Cache) Get(key string) *CacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		return entry
+	}
+	if c.dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.file_path(key))
+	if err != nil {
+		return nil
+	}
+	entry := &CacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		fmt.Println("Ignoring corrupt cache entry for", key)
+		return nil
+	}
+	c.entries[key] = entry
+	return entry
+}
+
+func (c *Cache) Put(key string, entry *CacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[key] = entry
+	if c.dir == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(c.dir, 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(c.file_path(key), data, 0600)
+	}
+	if err != nil {
+		fmt.Println("Failed to write cache entry:", err)
+	}
+}
+
+func (c *Cache) Remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, key)
+	if c.dir != "" {
+		os.Remove(c.file_path(key))
+	}
+}
+
+func (c *Cache) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	clear(c.entries)
+	if c.dir != "" {
+		files, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}
+}
+
+func (c *Cache) file_path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:])+".json")
+}
+
+// update stores or revalidates the entry for key with a fresh network
+// response and returns the response the caller should see.
+func (c *Cache) update(key string, response *Response, cached *CacheEntry) *Response {
+	directives := ParseCacheControl(response.Headers["cache-control"])
+	if response.Status == 304 && cached != nil {
+		headers := maps.Clone(cached.Headers)
+		for header, value := range response.Headers {
+			if !slices.Contains(UNCACHED_HEADERS, header) {
+				headers[header] = value
+			}
+		}
+		entry := &CacheEntry{Status: cached.Status, Reason: cached.Reason, Headers: headers, Body: cached.Body, Stored: time.Now()}
+		c.Put(key, entry)
+		return entry.response(response.URL)
+	}
+
+	// whatever was stored before is outdated now, keeping it would have
+	// it revalidated with old validators
+	_, no_store := directives["no-store"]
+	_, has_max_age := directives["max-age"]
+	_, has_etag := response.Headers["etag"]
+	_, has_last_modified := response.Headers["last-modified"]
+	if no_store || response.Status != 200 || !(has_max_age || has_etag || has_last_modified) {
+		c.Remove(key)
+		return response
+	}
+	headers := maps.Clone(response.Headers)
+	maps.DeleteFunc(headers, func(header, _ string) bool {
+		return slices.Contains(UNCACHED_HEADERS, header)
+	})
+	c.Put(key, &CacheEntry{
+		Status:  response.Status,
+		Reason:  response.Reason,
+		Headers: headers,
+		Body:    response.Body,
+		Stored:  time.Now(),
+	})
+	return response
+}
+
+// fresh reports whether the entry can be used without asking the server.
+func (e *CacheEntry) fresh() bool {
+	directives := ParseCacheControl(e.Headers["cache-control"])
+	if _, ok := directives["no-cache"]; ok {
+		return false
+	}
+	max_age, err := strconv.Atoi(directives["max-age"])
+	if err != nil {
+		return false
+	}
+	age, err := strconv.Atoi(e.Headers["age"])
+	if err != nil {
+		age = 0
+	}
+	current_age := time.Duration(age)*time.Second + time.Since(e.Stored)
+	return current_age < time.Duration(max_age)*time.Second
+}
+
+func (e *CacheEntry) response(url *URL) *Response {
+	return &Response{URL: url, Status: e.Status, Reason: e.Reason, Headers: maps.Clone(e.Headers), Body: e.Body}
+}
+
+func ParseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(arg, "\"")
+	}
+	return directives
+}
@@ -0,0 +1,152 @@
+package url
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func withCache(t *testing.T, cache *Cache) {
+	old := CACHE
+	CACHE = cache
+	t.Cleanup(func() { CACHE = old })
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	withCache(t, NewCache(""))
+	hits := &atomic.Int32{}
+	base := startTestServer(t, func(req testRequest) string {
+		hits.Add(1)
+		return "HTTP/1.1 200 OK\r\nCache-Control: max-age=60\r\n\r\nstyle"
+	})
+	u, _ := NewURL(base + "/style.css")
+	for i := 0; i < 3; i++ {
+		response, err := u.Request(nil, nil)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err)
+		}
+		if string(response.Body) != "style" {
+			t.Errorf("Expected body 'style', got '%s'", response.Body)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Expected 1 request to the server, got %d", hits.Load())
+	}
+}
+
+func TestCacheNoStore(t *testing.T) {
+	withCache(t, NewCache(""))
+	hits := &atomic.Int32{}
+	base := startTestServer(t, func(req testRequest) string {
+		hits.Add(1)
+		return "HTTP/1.1 200 OK\r\nCache-Control: no-store, max-age=60\r\n\r\nsecret"
+	})
+	u, _ := NewURL(base + "/")
+	u.Request(nil, nil)
+	u.Request(nil, nil)
+	if hits.Load() != 2 {
+		t.Errorf("Expected 2 requests to the server, got %d", hits.Load())
+	}
+	if CACHE.Get(u.String()) != nil {
+		t.Error("Expected no-store response not to be cached")
+	}
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	withCache(t, NewCache(""))
+	var conditional []string
+	base := startTestServer(t, func(req testRequest) string {
+		switch req.path {
+		case "/etag":
+			if req.headers["if-none-match"] == "\"v1\"" {
+				conditional = append(conditional, req.path)
+				return "HTTP/1.1 304 Not Modified\r\nCache-Control: no-cache\r\n\r\n"
+			}
+			return "HTTP/1.1 200 OK\r\nCache-Control: no-cache\r\nETag: \"v1\"\r\n\r\netag body"
+		case "/modified":
+			if req.headers["if-modified-since"] == "Wed, 21 Oct 2015 07:28:00 GMT" {
+				conditional = append(conditional, req.path)
+				return "HTTP/1.1 304 Not Modified\r\n\r\n"
+			}
+			return "HTTP/1.1 200 OK\r\nLast-Modified: Wed, 21 Oct 2015 07:28:00 GMT\r\n\r\nmodified body"
+		}
+		return "HTTP/1.1 404 Not Found\r\n\r\n"
+	})
+
+	for path, body := range map[string]string{"/etag": "etag body", "/modified": "modified body"} {
+		u, _ := NewURL(base + path)
+		for i := 0; i < 2; i++ {
+			response, err := u.Request(nil, nil)
+			if err != nil {
+				t.Fatalf("Request failed: %s", err)
+			}
+			if response.Status != 200 || string(response.Body) != body {
+				t.Errorf("%s: expected 200 '%s', got %d '%s'", path, body, response.Status, response.Body)
+			}
+		}
+	}
+	if len(conditional) != 2 {
+		t.Errorf("Expected 2 conditional requests, got %v", conditional)
+	}
+}
+
+func TestCacheDisk(t *testing.T) {
+	dir := t.TempDir()
+	withCache(t, NewCache(dir))
+	hits := &atomic.Int32{}
+	base := startTestServer(t, func(req testRequest) string {
+		hits.Add(1)
+		return "HTTP/1.1 200 OK\r\nCache-Control: max-age=60\r\n\r\npersisted"
+	})
+	u, _ := NewURL(base + "/")
+	u.Request(nil, nil)
+
+	CACHE = NewCache(dir)
+	response, err := u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if string(response.Body) != "persisted" {
+		t.Errorf("Expected body 'persisted', got '%s'", response.Body)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Expected cached response to be read from disk, got %d requests", hits.Load())
+	}
+
+	CACHE.Clear()
+	if NewCache(dir).Get(u.String()) != nil {
+		t.Error("Expected Clear to remove entries from disk")
+	}
+}
+
+func TestCacheReplace(t *testing.T) {
+	withCache(t, NewCache(""))
+	responses := map[string]string{
+		"/uncacheable": "HTTP/1.1 200 OK\r\n\r\nfresh",
+		"/error":       "HTTP/1.1 500 Internal Server Error\r\n\r\n",
+	}
+	var first atomic.Bool
+	base := startTestServer(t, func(req testRequest) string {
+		if first.Load() {
+			return "HTTP/1.1 200 OK\r\nCache-Control: no-cache\r\nETag: \"v1\"\r\nSet-Cookie: session=1\r\n\r\nstale"
+		}
+		return responses[req.path]
+	})
+
+	for path := range responses {
+		u, _ := NewURL(base + path)
+		first.Store(true)
+		u.Request(nil, nil)
+		entry := CACHE.Get(u.String())
+		if entry == nil {
+			t.Fatalf("%s: expected the first response to be cached", path)
+		}
+		if _, ok := entry.Headers["set-cookie"]; ok {
+			t.Errorf("%s: expected set-cookie not to be cached", path)
+		}
+		first.Store(false)
+		u.Request(nil, nil)
+		if CACHE.Get(u.String()) != nil {
+			t.Errorf("%s: expected the stale entry to be removed", path)
+		}
+	}
+}
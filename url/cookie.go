@@ -0,0 +1,280 @@
+package url
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	COOKIE_JAR = NewCookieJar("")
+
+	COOKIE_DATE_LAYOUTS = []string{
+		time.RFC1123,
+		"Mon, 02-Jan-2006 15:04:05 MST",
+		"Monday, 02-Jan-06 15:04:05 MST",
+		time.ANSIC,
+	}
+)
+
+type Cookie struct {
+	Name     string
+	Value    string
+	Domain   string
+	HostOnly bool
+	Path     string
+	Expires  time.Time // zero for session cookies
+	Secure   bool
+	HttpOnly bool
+	SameSite string
+	Created  time.Time
+}
+
+// CookieJar stores cookies for all hosts. If file is set, persistent
+// cookies are loaded from and saved to it so they survive a restart.
+type CookieJar struct {
+	lock    *sync.Mutex
+	cookies []*Cookie
+	file    string
+}
+
+func NewCookieJar(file string) *CookieJar {
+	jar := &CookieJar{lock: &sync.Mutex{}, file: file}
+	if file == "" {
+		return jar
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return jar
+	}
+	if err := json.Unmarshal(data, &jar.cookies); err != nil {
+		fmt.Println("Ignoring corrupt cookie file:", err)
+		jar.cookies = nil
+	}
+	jar.remove_expired()
+	return jar
+}
+
+// SetCookie stores the cookie from a Set-Cookie header, or from an
+// assignment to document.cookie if from_script is set.
+func (j *CookieJar) SetCookie(u *URL, header string, from_script bool) {
+	cookie, err := ParseSetCookie(u, header)
+	if err != nil {
+		fmt.Println("Ignoring cookie:", err)
+		return
+	}
+	if from_script && cookie.HttpOnly {
+		return
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for i, old := range j.cookies {
+		if old.Name == cookie.Name && old.Domain == cookie.Domain && old.Path == cookie.Path {
+			if from_script && old.HttpOnly {
+				return
+			}
+			cookie.Created = old.Created
+			j.cookies = slices.Delete(j.cookies, i, i+1)
+			break
+		}
+	}
+	if cookie.Expires.IsZero() || cookie.Expires.After(time.Now()) {
+		j.cookies = append(j.cookies, cookie)
+	}
+	if j.file != "" {
+		j.save()
+	}
+}
+
+// Cookies returns the Cookie header value for a request to u. Requests
+// from referrer to another host leave out SameSite cookies, except lax
+// ones on GET requests.
+func (j *CookieJar) Cookies(u *URL, referrer *URL, method string) string {
+	return j.cookie_string(u, func(cookie *Cookie) bool {
+		if referrer == nil || referrer.host == u.host {
+			return true
+		}
+		switch strings.ToLower(cookie.SameSite) {
+		case "strict":
+			return false
+		case "lax":
+			return method == "GET"
+		}
+		return true
+	})
+}
+
+// ScriptCookies returns the value of document.cookie, which never
+// contains HttpOnly cookies.
+func (j *CookieJar) ScriptCookies(u *URL) string {
+	return j.cookie_string(u, func(cookie *Cookie) bool {
+		return !cookie.HttpOnly
+	})
+}
+
+func (j *CookieJar) Clear() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.cookies = nil
+	if j.file != "" {
+		os.Remove(j.file)
+	}
+}
+
+func (j *CookieJar) cookie_string(u *URL, allow func(*Cookie) bool) string {
+	if u.scheme != "http" && u.scheme != "https" {
+		return ""
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.remove_expired()
+
+	path := u.path
+	var matching []*Cookie
+	for _, cookie := range j.cookies {
+		if cookie.HostOnly && cookie.Domain != u.host {
+			continue
+		}
+		if !cookie.HostOnly && !domain_match(u.host, cookie.Domain) {
+			continue
+		}
+		if !path_match(path, cookie.Path) || (cookie.Secure && u.scheme != "https") || !allow(cookie) {
+			continue
+		}
+		matching = append(matching, cookie)
+	}
+	// longer paths first, then older cookies first
+	slices.SortStableFunc(matching, func(a, b *Cookie) int {
+		if len(a.Path) != len(b.Path) {
+			return len(b.Path) - len(a.Path)
+		}
+		return a.Created.Compare(b.Created)
+	})
+
+	pairs := make([]string, len(matching))
+	for i, cookie := range matching {
+		pairs[i] = cookie.Name + "=" + cookie.Value
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func (j *CookieJar) remove_expired() {
+	j.cookies = slices.DeleteFunc(j.cookies, func(cookie *Cookie) bool {
+		return !cookie.Expires.IsZero() && !cookie.Expires.After(time.Now())
+	})
+}
+
+func (j *CookieJar) save() {
+	var persistent []*Cookie
+	for _, cookie := range j.cookies {
+		if !cookie.Expires.IsZero() {
+			persistent = append(persistent, cookie)
+		}
+	}
+	data, err := json.Marshal(persistent)
+	if err == nil {
+		err = os.WriteFile(j.file, data, 0600)
+	}
+	if err != nil {
+		fmt.Println("Failed to save cookies:", err)
+	}
+}
+
+func ParseSetCookie(u *URL, header string) (*Cookie, error) {
+	parts := strings.Split(header, ";")
+	name, value, ok := strings.Cut(parts[0], "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("malformed cookie: %s", header)
+	}
+	cookie := &Cookie{
+		Name:     name,
+		Value:    strings.TrimSpace(value),
+		Domain:   u.host,
+		HostOnly: true,
+		Path:     default_cookie_path(u.path),
+		Created:  time.Now(),
+	}
+
+	var max_age *int
+	for _, part := range parts[1:] {
+		attr, value, _ := strings.Cut(part, "=")
+		attr, value = strings.ToLower(strings.TrimSpace(attr)), strings.TrimSpace(value)
+		switch attr {
+		case "domain":
+			domain := strings.ToLower(strings.TrimPrefix(value, "."))
+			if domain == "" {
+				continue
+			}
+			if !domain_match(u.host, domain) || (!strings.Contains(domain, ".") && domain != u.host) {
+				return nil, fmt.Errorf("domain %s not allowed for host %s", domain, u.host)
+			}
+			cookie.Domain, cookie.HostOnly = domain, domain == u.host
+		case "path":
+			if strings.HasPrefix(value, "/") {
+				cookie.Path = value
+			}
+		case "expires":
+			for _, layout := range COOKIE_DATE_LAYOUTS {
+				if expires, err := time.Parse(layout, value); err == nil {
+					cookie.Expires = expires
+					break
+				}
+			}
+		case "max-age":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				max_age = &seconds
+			}
+		case "secure":
+			cookie.Secure = true
+		case "httponly":
+			cookie.HttpOnly = true
+		case "samesite":
+			cookie.SameSite = strings.ToLower(value)
+		}
+	}
+	// Max-Age wins over Expires
+	if max_age != nil {
+		if *max_age <= 0 {
+			cookie.Expires = time.Unix(0, 0)
+		} else {
+			cookie.Expires = time.Now().Add(time.Duration(*max_age) * time.Second)
+		}
+	}
+	if cookie.Secure && u.scheme != "https" {
+		return nil, fmt.Errorf("secure cookie %s set over %s", cookie.Name, u.scheme)
+	}
+	return cookie, nil
+}
+
+func domain_match(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	// an IP address only matches itself, its last labels are no parent domain
+	is_ip := strings.HasPrefix(host, "[") || ends_in_a_number(host)
+	return !is_ip && strings.HasSuffix(host, "."+domain)
+}
+
+func path_match(path, cookie_path string) bool {
+	if path == cookie_path {
+		return true
+	}
+	return strings.HasPrefix(path, cookie_path) &&
+		(strings.HasSuffix(cookie_path, "/") || path[len(cookie_path)] == '/')
+}
+
+func default_cookie_path(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
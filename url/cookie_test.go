@@ -0,0 +1,160 @@
+package url
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func withCookieJar(t *testing.T, jar *CookieJar) {
+	old := COOKIE_JAR
+	COOKIE_JAR = jar
+	t.Cleanup(func() { COOKIE_JAR = old })
+}
+
+func TestCookieMultiplePerHost(t *testing.T) {
+	jar := NewCookieJar("")
+	u, _ := NewURL("http://example.org/")
+	jar.SetCookie(u, "a=1", false)
+	jar.SetCookie(u, "b=2", false)
+	jar.SetCookie(u, "a=3", false)
+	if got := jar.Cookies(u, nil, "GET"); got != "a=3; b=2" {
+		t.Errorf("Expected 'a=3; b=2', got '%s'", got)
+	}
+}
+
+func TestCookieDomainAndPath(t *testing.T) {
+	jar := NewCookieJar("")
+	setter, _ := NewURL("http://www.example.org/account/login")
+	jar.SetCookie(setter, "shared=1; Domain=.example.org; Path=/", false)
+	jar.SetCookie(setter, "account=2", false)
+	jar.SetCookie(setter, "evil=3; Domain=other.org", false)
+	jar.SetCookie(setter, "tld=4; Domain=org", false)
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"http://www.example.org/account/settings", "account=2; shared=1"},
+		{"http://www.example.org/account", "account=2; shared=1"},
+		{"http://www.example.org/accounts", "shared=1"},
+		{"http://api.example.org/", "shared=1"},
+		{"http://example.org/", "shared=1"},
+		{"http://notexample.org/", ""},
+		{"http://other.org/", ""},
+	}
+	for _, test := range tests {
+		u, _ := NewURL(test.url)
+		if got := jar.Cookies(u, nil, "GET"); got != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.url, test.expected, got)
+		}
+	}
+}
+
+func TestCookieDomainOfIPAddress(t *testing.T) {
+	for _, host := range []string{"10.0.0.1", "[::1]"} {
+		jar := NewCookieJar("")
+		setter, _ := NewURL("http://" + host + "/")
+		jar.SetCookie(setter, "parent=1; Domain=0.0.1", false)
+		jar.SetCookie(setter, "suffix=2; Domain=1]", false)
+		jar.SetCookie(setter, "own=3; Domain="+host, false)
+		if got := jar.Cookies(setter, nil, "GET"); got != "own=3" {
+			t.Errorf("%s: expected 'own=3', got '%s'", host, got)
+		}
+	}
+	if domain_match("20.0.0.1", "0.0.1") {
+		t.Error("Expected an IP address not to match its last labels")
+	}
+}
+
+func TestCookieExpiry(t *testing.T) {
+	jar := NewCookieJar("")
+	u, _ := NewURL("http://example.org/")
+	jar.SetCookie(u, "old=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", false)
+	jar.SetCookie(u, "session=2", false)
+	jar.SetCookie(u, "later=3; Max-Age=60; Expires=Wed, 21 Oct 2015 07:28:00 GMT", false)
+	if got := jar.Cookies(u, nil, "GET"); got != "session=2; later=3" {
+		t.Errorf("Expected 'session=2; later=3', got '%s'", got)
+	}
+	jar.SetCookie(u, "later=; Max-Age=0", false)
+	if got := jar.Cookies(u, nil, "GET"); got != "session=2" {
+		t.Errorf("Expected Max-Age=0 to delete the cookie, got '%s'", got)
+	}
+}
+
+func TestCookieSecureAndHttpOnly(t *testing.T) {
+	jar := NewCookieJar("")
+	secure, _ := NewURL("https://example.org/")
+	insecure, _ := NewURL("http://example.org/")
+	jar.SetCookie(secure, "token=1; Secure", false)
+	jar.SetCookie(insecure, "fake=1; Secure", false)
+	jar.SetCookie(secure, "session=2; HttpOnly", false)
+	jar.SetCookie(secure, "theme=dark", false)
+
+	if got := jar.Cookies(secure, nil, "GET"); got != "token=1; session=2; theme=dark" {
+		t.Errorf("Expected all cookies over https, got '%s'", got)
+	}
+	if got := jar.Cookies(insecure, nil, "GET"); got != "session=2; theme=dark" {
+		t.Errorf("Expected no Secure cookies over http, got '%s'", got)
+	}
+	if got := jar.ScriptCookies(secure); got != "token=1; theme=dark" {
+		t.Errorf("Expected HttpOnly cookies to be hidden from scripts, got '%s'", got)
+	}
+
+	jar.SetCookie(secure, "session=stolen", true)
+	jar.SetCookie(secure, "injected=1; HttpOnly", true)
+	if got := jar.Cookies(secure, nil, "GET"); got != "token=1; session=2; theme=dark" {
+		t.Errorf("Expected scripts not to touch HttpOnly cookies, got '%s'", got)
+	}
+}
+
+func TestCookieSameSite(t *testing.T) {
+	jar := NewCookieJar("")
+	u, _ := NewURL("http://example.org/")
+	other, _ := NewURL("http://other.org/")
+	jar.SetCookie(u, "lax=1; SameSite=Lax", false)
+	jar.SetCookie(u, "strict=2; SameSite=Strict", false)
+	jar.SetCookie(u, "none=3", false)
+	if got := jar.Cookies(u, other, "GET"); got != "lax=1; none=3" {
+		t.Errorf("Expected 'lax=1; none=3' for cross-site GET, got '%s'", got)
+	}
+	if got := jar.Cookies(u, other, "POST"); got != "none=3" {
+		t.Errorf("Expected 'none=3' for cross-site POST, got '%s'", got)
+	}
+	if got := jar.Cookies(u, u, "POST"); got != "lax=1; strict=2; none=3" {
+		t.Errorf("Expected all cookies for same-site POST, got '%s'", got)
+	}
+}
+
+func TestCookiePersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cookies.json")
+	u, _ := NewURL("http://example.org/")
+	jar := NewCookieJar(file)
+	jar.SetCookie(u, "persistent=1; Max-Age=3600", false)
+	jar.SetCookie(u, "session=2", false)
+
+	if got := NewCookieJar(file).Cookies(u, nil, "GET"); got != "persistent=1" {
+		t.Errorf("Expected only persistent cookies to be restored, got '%s'", got)
+	}
+}
+
+func TestRequestCookies(t *testing.T) {
+	withCookieJar(t, NewCookieJar(""))
+	base := startTestServer(t, func(req testRequest) string {
+		if req.path == "/login" {
+			return "HTTP/1.1 200 OK\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2; Path=/\r\n\r\n"
+		}
+		return "HTTP/1.1 200 OK\r\n\r\n" + req.headers["cookie"]
+	})
+	login, _ := NewURL(base + "/login")
+	if _, err := login.Request(nil, nil); err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	u, _ := NewURL(base + "/check")
+	response, err := u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if string(response.Body) != "a=1; b=2" {
+		t.Errorf("Expected both cookies to be sent, got '%s'", response.Body)
+	}
+}
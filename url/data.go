@@ -0,0 +1,83 @@
+package url
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parse_data splits a data URL into its media type and data, as in
+// https://fetch.spec.whatwg.org/#data-url-processor.
+func (u *URL) parse_data() error {
+	body := u.path
+	if u.has_query {
+		body += "?" + u.query
+	}
+	header, data, found := strings.Cut(body, ",")
+	if !found {
+		return fmt.Errorf("data URL is missing a comma: %s", u)
+	}
+
+	header = strings.TrimSpace(header)
+	if i := strings.LastIndex(header, ";"); i != -1 && strings.EqualFold(strings.TrimSpace(header[i+1:]), "base64") {
+		u.base64 = true
+		header = strings.TrimSpace(header[:i])
+	}
+
+	params := strings.Split(header, ";")
+	u.media_type = strings.ToLower(strings.TrimSpace(params[0]))
+	for _, param := range params[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(strings.TrimSpace(name), "charset") {
+			u.charset = strings.ToLower(strings.Trim(strings.TrimSpace(value), "\""))
+		}
+	}
+	if u.media_type == "" || !strings.Contains(u.media_type, "/") {
+		u.media_type = "text/plain"
+		if u.charset == "" {
+			u.charset = "us-ascii"
+		}
+	}
+
+	u.data = data
+	return nil
+}
+
+func (u *URL) request_data() (map[string]string, []byte, error) {
+	content := percent_decode(u.data)
+	if u.base64 {
+		// whitespace is allowed anywhere in base64 data and padding is optional
+		encoded := strings.Join(strings.Fields(string(content)), "")
+		encoded = strings.TrimRight(encoded, "=")
+		decoded, err := base64.RawStdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid base64 in data URL: %s", err.Error())
+		}
+		content = decoded
+	}
+
+	content_type := u.media_type
+	if u.charset != "" {
+		content_type += "; charset=" + u.charset
+	}
+	headers := map[string]string{"content-type": content_type}
+	return headers, content, nil
+}
+
+// Unlike url.PathUnescape this keeps malformed escapes as they are,
+// which is what browsers do for data URLs.
+func percent_decode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				out = append(out, byte(b))
+				i += 2
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return out
+}
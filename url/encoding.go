@@ -0,0 +1,141 @@
+package url
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	ACCEPT_ENCODING = "gzip, deflate"
+	CHUNK_SIZE      = 4096
+)
+
+// ReadChunked reads a chunked transfer-encoded body and returns it
+// together with any trailer headers sent after the last chunk.
+func ReadChunked(reader *bufio.Reader) ([]byte, map[string]string, error) {
+	var body bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read chunk size: %s", err.Error())
+		}
+		size_str, _, _ := strings.Cut(strings.TrimSpace(line), ";") // ignore chunk extensions
+		size, err := strconv.ParseInt(strings.TrimSpace(size_str), 16, 64)
+		if err != nil || size < 0 {
+			return nil, nil, fmt.Errorf("invalid chunk size: %s", strings.TrimSpace(line))
+		}
+		if size == 0 {
+			break
+		}
+		if size > MAX_BODY_SIZE-int64(body.Len()) {
+			return nil, nil, ErrBodyTooLarge
+		}
+		// the buffer grows with what arrives, not with what the size promises
+		if _, err := io.CopyN(&body, reader, size); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, nil, fmt.Errorf("failed to read chunk: %s", err.Error())
+		}
+		if crlf, err := reader.ReadString('\n'); err != nil || strings.TrimSpace(crlf) != "" {
+			return nil, nil, fmt.Errorf("missing CRLF after chunk")
+		}
+	}
+
+	trailers := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read trailers: %s", err.Error())
+		}
+		if line == "\r\n" || line == "\n" {
+			break
+		}
+		header, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, nil, fmt.Errorf("malformed trailer: %s", strings.TrimSpace(line))
+		}
+		trailers[strings.ToLower(header)] = strings.TrimSpace(value)
+	}
+	return body.Bytes(), trailers, nil
+}
+
+func WriteChunked(w io.Writer, body []byte, chunk_size int) error {
+	for len(body) > 0 {
+		n := min(chunk_size, len(body))
+		if _, err := fmt.Fprintf(w, "%x\r\n%s\r\n", n, body[:n]); err != nil {
+			return err
+		}
+		body = body[n:]
+	}
+	_, err := io.WriteString(w, "0\r\n\r\n")
+	return err
+}
+
+// DecodeContent undoes a Content-Encoding, which may list several
+// encodings in the order they were applied.
+func DecodeContent(encoding string, body []byte) ([]byte, error) {
+	encodings := strings.Split(encoding, ",")
+	slices.Reverse(encodings)
+	for _, enc := range encodings {
+		var reader io.ReadCloser
+		var err error
+		switch strings.ToLower(strings.TrimSpace(enc)) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			// deflate is supposed to be zlib-wrapped, but some servers send raw deflate
+			reader, err = zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				reader, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %s", enc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s content: %s", enc, err.Error())
+		}
+		// one more byte than allowed tells a body that is too large
+		body, err = io.ReadAll(io.LimitReader(reader, MAX_BODY_SIZE+1))
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s content: %s", enc, err.Error())
+		}
+		if int64(len(body)) > MAX_BODY_SIZE {
+			return nil, ErrBodyTooLarge
+		}
+	}
+	return body, nil
+}
+
+func EncodeContent(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		writer = gzip.NewWriter(&buf)
+	case "deflate":
+		writer = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
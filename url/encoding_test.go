@@ -0,0 +1,127 @@
+package url
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChunkedRoundTrip(t *testing.T) {
+	body := []byte(strings.Repeat("hello chunked world ", 50))
+	var buf bytes.Buffer
+	if err := WriteChunked(&buf, body, 64); err != nil {
+		t.Fatalf("WriteChunked failed: %s", err)
+	}
+	got, trailers, err := ReadChunked(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("ReadChunked failed: %s", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("Expected %q, got %q", body, got)
+	}
+	if len(trailers) != 0 {
+		t.Errorf("Expected no trailers, got %v", trailers)
+	}
+}
+
+func TestChunkedTrailersAndExtensions(t *testing.T) {
+	raw := "5;name=value\r\nhello\r\n7\r\n, world\r\n0\r\nExpires: never\r\nX-Checksum: abc\r\n\r\n"
+	got, trailers, err := ReadChunked(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("ReadChunked failed: %s", err)
+	}
+	if string(got) != "hello, world" {
+		t.Errorf("Expected 'hello, world', got %q", got)
+	}
+	if trailers["x-checksum"] != "abc" || trailers["expires"] != "never" {
+		t.Errorf("Expected trailers to be parsed, got %v", trailers)
+	}
+}
+
+func TestChunkedInvalidSize(t *testing.T) {
+	_, _, err := ReadChunked(bufio.NewReader(strings.NewReader("zz\r\nhello\r\n0\r\n\r\n")))
+	if err == nil {
+		t.Error("Expected error for invalid chunk size, but did not error")
+	}
+}
+
+func TestChunkedTooLarge(t *testing.T) {
+	_, _, err := ReadChunked(bufio.NewReader(strings.NewReader("7fffffffffffffff\r\nhello\r\n0\r\n\r\n")))
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Expected ErrBodyTooLarge for a huge chunk, got %v", err)
+	}
+	_, _, err = ReadChunked(bufio.NewReader(strings.NewReader("100000\r\nhello")))
+	if err == nil {
+		t.Error("Expected error for a chunk shorter than its size, but did not error")
+	}
+}
+
+func TestContentEncodingTooLarge(t *testing.T) {
+	max_size := MAX_BODY_SIZE
+	MAX_BODY_SIZE = 1000
+	t.Cleanup(func() { MAX_BODY_SIZE = max_size })
+
+	body := bytes.Repeat([]byte{0}, 1001)
+	for _, encoding := range []string{"gzip", "deflate"} {
+		encoded, _ := EncodeContent(encoding, body)
+		if _, err := DecodeContent(encoding, encoded); !errors.Is(err, ErrBodyTooLarge) {
+			t.Errorf("%s: expected ErrBodyTooLarge, got %v", encoding, err)
+		}
+	}
+}
+
+func TestContentEncodingRoundTrip(t *testing.T) {
+	body := []byte("<html><body>compressed</body></html>")
+	for _, encoding := range []string{"gzip", "deflate", "identity"} {
+		encoded, err := EncodeContent(encoding, body)
+		if err != nil {
+			t.Fatalf("EncodeContent(%s) failed: %s", encoding, err)
+		}
+		decoded, err := DecodeContent(encoding, encoded)
+		if err != nil {
+			t.Fatalf("DecodeContent(%s) failed: %s", encoding, err)
+		}
+		if !bytes.Equal(decoded, body) {
+			t.Errorf("%s: expected %q, got %q", encoding, body, decoded)
+		}
+	}
+
+	deflated, _ := EncodeContent("deflate", body)
+	gzipped, _ := EncodeContent("gzip", deflated)
+	decoded, err := DecodeContent("deflate, gzip", gzipped)
+	if err != nil || !bytes.Equal(decoded, body) {
+		t.Errorf("Expected stacked encodings to decode, got %q, %v", decoded, err)
+	}
+
+	if _, err := DecodeContent("br", body); err == nil {
+		t.Error("Expected error for unsupported encoding, but did not error")
+	}
+}
+
+func TestRequestChunkedGzip(t *testing.T) {
+	page := strings.Repeat("<p>Hello from a chunked, gzipped response</p>", 100)
+	base := startTestServer(t, func(req testRequest) string {
+		if !strings.Contains(req.headers["accept-encoding"], "gzip") {
+			return "HTTP/1.1 406 Not Acceptable\r\n\r\n"
+		}
+		encoded, _ := EncodeContent("gzip", []byte(page))
+		var buf bytes.Buffer
+		WriteChunked(&buf, encoded, 100)
+		response := "HTTP/1.1 200 OK\r\n"
+		response += "Transfer-Encoding: chunked\r\n"
+		response += "Content-Encoding: gzip\r\n"
+		response += "\r\n"
+		return response + buf.String()
+	})
+
+	u, _ := NewURL(base + "/")
+	response, err := u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if string(response.Body) != page {
+		t.Errorf("Expected decoded page, got %q", response.Body)
+	}
+}
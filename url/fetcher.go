@@ -0,0 +1,205 @@
+package url
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Fetcher loads the resource at url, giving up once ctx is cancelled. The
+// browser does all of its loading through a Fetcher so that tests can run
+// without a network.
+type Fetcher interface {
+	Fetch(ctx context.Context, url *URL, referrer *URL, payload *Payload) (*Response, error)
+}
+
+// NetworkFetcher fetches over the network, see URL.Request.
+type NetworkFetcher struct{}
+
+func (n *NetworkFetcher) Fetch(ctx context.Context, url *URL, referrer *URL, payload *Payload) (*Response, error) {
+	return url.RequestContext(ctx, referrer, payload)
+}
+
+// Exchange is a recorded request and the response or error it produced.
+type Exchange struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	Payload     string            `json:"payload,omitempty"`
+	ContentType string            `json:"content_type,omitempty"` // of the payload
+	FinalURL    string            `json:"final_url,omitempty"`
+	Status      int               `json:"status,omitempty"`
+	Reason      string            `json:"reason,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body,omitempty"` // base64 in the file, bodies may be binary
+	Error       string            `json:"error,omitempty"`
+}
+
+func exchange_key(method, url, payload string) string {
+	return method + " " + url + " " + payload
+}
+
+// MemoryFetcher serves canned responses and never touches the network.
+type MemoryFetcher struct {
+	lock      *sync.Mutex
+	exchanges map[string]*Exchange
+	requests  []string
+}
+
+func NewMemoryFetcher() *MemoryFetcher {
+	return &MemoryFetcher{
+		lock:      &sync.Mutex{},
+		exchanges: make(map[string]*Exchange),
+	}
+}
+
+// Add serves body with a 200 status for GET requests to url.
+func (m *MemoryFetcher) Add(url, content_type, body string) {
+	m.AddExchange(&Exchange{
+		Method:  "GET",
+		URL:     url,
+		Status:  200,
+		Reason:  "OK",
+		Headers: map[string]string{"content-type": content_type},
+		Body:    []byte(body),
+	})
+}
+
+func (m *MemoryFetcher) AddExchange(exchange *Exchange) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.exchanges[exchange_key(exchange.Method, exchange.URL, exchange.Payload)] = exchange
+}
+
+func (m *MemoryFetcher) Fetch(ctx context.Context, url *URL, referrer *URL, payload *Payload) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	method := payload.method()
+	// like on the network, the fragment is not part of the request
+	request := url.WithoutFragment().String()
+	m.lock.Lock()
+	m.requests = append(m.requests, method+" "+request)
+	exchange, ok := m.exchanges[exchange_key(method, request, payload.body())]
+	m.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no response for %s %s", method, url)
+	}
+	if exchange.Error != "" {
+		return nil, errors.New(exchange.Error)
+	}
+
+	final_url := url
+	if exchange.FinalURL != "" {
+		var err error
+		final_url, err = NewURL(exchange.FinalURL)
+		if err != nil {
+			return nil, err
+		}
+		if !final_url.has_fragment {
+			final_url.fragment, final_url.has_fragment = url.fragment, url.has_fragment
+		}
+		if err := check_redirect(ctx, final_url); err != nil {
+			return nil, err
+		}
+	}
+	return &Response{
+		URL:     final_url,
+		Status:  exchange.Status,
+		Reason:  exchange.Reason,
+		Headers: maps.Clone(exchange.Headers),
+		Body:    exchange.Body,
+	}, nil
+}
+
+// Requests returns "METHOD url" for every request made so far.
+func (m *MemoryFetcher) Requests() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return slices.Clone(m.requests)
+}
+
+// LoadRecording returns a MemoryFetcher that replays the exchanges saved
+// by a RecordingFetcher.
+func LoadRecording(file string) (*MemoryFetcher, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fetcher := NewMemoryFetcher()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		exchange := &Exchange{}
+		if err := json.Unmarshal(scanner.Bytes(), exchange); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, line, err.Error())
+		}
+		fetcher.AddExchange(exchange)
+	}
+	return fetcher, scanner.Err()
+}
+
+// RecordingFetcher passes requests on to another Fetcher and appends every
+// exchange to a JSONL file, one JSON object per line.
+type RecordingFetcher struct {
+	fetcher Fetcher
+	lock    *sync.Mutex
+	file    *os.File
+}
+
+func NewRecordingFetcher(fetcher Fetcher, file string) (*RecordingFetcher, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordingFetcher{fetcher: fetcher, lock: &sync.Mutex{}, file: f}, nil
+}
+
+func (r *RecordingFetcher) Fetch(ctx context.Context, url *URL, referrer *URL, payload *Payload) (*Response, error) {
+	response, err := r.fetcher.Fetch(ctx, url, referrer, payload)
+	if ctx.Err() != nil {
+		// a cancelled request says nothing about the server
+		return response, err
+	}
+	request := url.WithoutFragment().String()
+	exchange := &Exchange{Method: payload.method(), URL: request, Payload: payload.body()}
+	if payload != nil {
+		exchange.ContentType = payload.ContentType
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	} else {
+		if response.URL != nil && response.URL.WithoutFragment().String() != request {
+			exchange.FinalURL = response.URL.WithoutFragment().String()
+		}
+		exchange.Status = response.Status
+		exchange.Reason = response.Reason
+		exchange.Headers = response.Headers
+		exchange.Body = response.Body
+	}
+
+	data, marshal_err := json.Marshal(exchange)
+	if marshal_err == nil {
+		r.lock.Lock()
+		_, marshal_err = r.file.Write(append(data, '\n'))
+		r.lock.Unlock()
+	}
+	if marshal_err != nil {
+		fmt.Println("Failed to record exchange:", marshal_err)
+	}
+	return response, err
+}
+
+func (r *RecordingFetcher) Close() error {
+	return r.file.Close()
+}
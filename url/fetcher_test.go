@@ -0,0 +1,86 @@
+package url
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMemoryFetcher(t *testing.T) {
+	fetcher := NewMemoryFetcher()
+	fetcher.Add("http://example.org/", "text/html", "<p>hi</p>")
+	u, _ := NewURL("http://example.org/")
+	response, err := fetcher.Fetch(context.Background(), u, nil, nil)
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+	if response.Status != 200 || string(response.Body) != "<p>hi</p>" || response.Headers["content-type"] != "text/html" {
+		t.Errorf("Unexpected response: %d %v %q", response.Status, response.Headers, response.Body)
+	}
+
+	missing, _ := NewURL("http://example.org/missing")
+	if _, err := fetcher.Fetch(context.Background(), missing, nil, nil); err == nil {
+		t.Error("Expected error for unknown URL, but did not error")
+	}
+	if _, err := fetcher.Fetch(context.Background(), u, nil, &Payload{Method: "POST", Body: "a=1"}); err == nil {
+		t.Error("Expected error for POST without a response, but did not error")
+	}
+
+	expected := []string{"GET http://example.org/", "GET http://example.org/missing", "POST http://example.org/"}
+	if !slices.Equal(fetcher.Requests(), expected) {
+		t.Errorf("Expected requests %v, got %v", expected, fetcher.Requests())
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	upstream := NewMemoryFetcher()
+	upstream.Add("http://example.org/", "text/html", "<img src=/logo.png>")
+	upstream.AddExchange(&Exchange{Method: "GET", URL: "http://example.org/logo.png", Status: 200, Body: []byte{0x89, 'P', 'N', 'G', 0xff}})
+	upstream.AddExchange(&Exchange{Method: "POST", URL: "http://example.org/submit", Payload: "a=1", FinalURL: "http://example.org/done", Status: 200, Body: []byte("done")})
+
+	file := filepath.Join(t.TempDir(), "recording.jsonl")
+	recorder, err := NewRecordingFetcher(upstream, file)
+	if err != nil {
+		t.Fatalf("NewRecordingFetcher failed: %s", err)
+	}
+	requests := []struct {
+		url     string
+		payload *Payload
+	}{
+		{"http://example.org/", nil},
+		{"http://example.org/logo.png", nil},
+		{"http://example.org/submit", &Payload{Method: "POST", ContentType: "application/x-www-form-urlencoded", Body: "a=1"}},
+		{"http://example.org/missing", nil},
+	}
+	var recorded []*Response
+	for _, request := range requests {
+		u, _ := NewURL(request.url)
+		response, _ := recorder.Fetch(context.Background(), u, nil, request.payload)
+		recorded = append(recorded, response)
+	}
+	recorder.Close()
+
+	replay, err := LoadRecording(file)
+	if err != nil {
+		t.Fatalf("LoadRecording failed: %s", err)
+	}
+	for i, request := range requests {
+		u, _ := NewURL(request.url)
+		response, err := replay.Fetch(context.Background(), u, nil, request.payload)
+		if recorded[i] == nil {
+			if err == nil {
+				t.Errorf("%s: expected recorded error to be replayed", request.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: replay failed: %s", request.url, err)
+		}
+		if response.Status != recorded[i].Status || string(response.Body) != string(recorded[i].Body) ||
+			response.URL.String() != recorded[i].URL.String() {
+			t.Errorf("%s: expected %d %s %q, got %d %s %q", request.url,
+				recorded[i].Status, recorded[i].URL, recorded[i].Body, response.Status, response.URL, response.Body)
+		}
+	}
+}
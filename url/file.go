@@ -0,0 +1,100 @@
+package url
+
+import (
+	"fmt"
+	"html"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	urllib "net/url"
+)
+
+func (u *URL) file_path() (string, error) {
+	if u.host != "" && u.host != "localhost" {
+		return "", fmt.Errorf("file URLs on remote hosts are not supported: %s", u.host)
+	}
+	file_path, err := urllib.PathUnescape(u.path)
+	if err != nil {
+		return "", fmt.Errorf("invalid file path: %s", u.path)
+	}
+	// file:///C:/dir on windows, drop the slash in front of the drive letter
+	if len(file_path) >= 3 && file_path[0] == '/' && file_path[2] == ':' {
+		file_path = file_path[1:]
+	}
+	return filepath.FromSlash(file_path), nil
+}
+
+func (u *URL) request_file() (map[string]string, []byte, error) {
+	file_path, err := u.file_path()
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := os.Stat(file_path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %s", err.Error())
+	}
+
+	headers := make(map[string]string)
+	if info.IsDir() {
+		listing, err := u.directory_listing(file_path)
+		if err != nil {
+			return nil, nil, err
+		}
+		headers["content-type"] = "text/html"
+		return headers, []byte(listing), nil
+	}
+
+	content, err := os.ReadFile(file_path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %s", err.Error())
+	}
+	if content_type := mime.TypeByExtension(filepath.Ext(file_path)); content_type != "" {
+		headers["content-type"] = content_type
+	}
+	return headers, content, nil
+}
+
+func (u *URL) directory_listing(dir_path string) (string, error) {
+	entries, err := os.ReadDir(dir_path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read directory: %s", err.Error())
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	dir := u.path
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	display_dir, err := urllib.PathUnescape(dir)
+	if err != nil {
+		display_dir = dir
+	}
+
+	out := "<!doctype html>"
+	out += "<title>Index of " + html.EscapeString(display_dir) + "</title>"
+	out += "<h1>Index of " + html.EscapeString(display_dir) + "</h1>"
+	out += "<ul>"
+	if dir != "/" {
+		parent := path.Dir(strings.TrimSuffix(dir, "/"))
+		out += "<li><a href=\"" + html.EscapeString(parent) + "\">..</a></li>"
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		href := dir + urllib.PathEscape(entry.Name())
+		out += "<li><a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(name) + "</a></li>"
+	}
+	out += "</ul>"
+	return out, nil
+}
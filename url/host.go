@@ -0,0 +1,389 @@
+package url
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parse_host implements the host parser. Hosts of special URLs are
+// percent-decoded and converted to ASCII, hosts of other URLs are kept
+// opaque.
+func parse_host(input string, is_opaque bool) (string, error) {
+	if strings.HasPrefix(input, "[") {
+		if !strings.HasSuffix(input, "]") {
+			return "", errors.New("unclosed IPv6 address")
+		}
+		address, err := parse_ipv6(input[1 : len(input)-1])
+		if err != nil {
+			return "", err
+		}
+		return "[" + serialize_ipv6(address) + "]", nil
+	}
+	if is_opaque {
+		if strings.ContainsFunc(input, func(c rune) bool { return c != '%' && is_forbidden_host_code_point(c) }) {
+			return "", errors.New("forbidden host code point")
+		}
+		return percent_encode(input, in_c0_control_set), nil
+	}
+	domain := string(percent_decode(input))
+	if !utf8.ValidString(domain) {
+		domain = strings.ToValidUTF8(domain, "�")
+	}
+	ascii_domain, err := domain_to_ascii(domain)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsFunc(ascii_domain, is_forbidden_domain_code_point) {
+		return "", errors.New("forbidden domain code point")
+	}
+	if ends_in_a_number(ascii_domain) {
+		address, err := parse_ipv4(ascii_domain)
+		if err != nil {
+			return "", err
+		}
+		return serialize_ipv4(address), nil
+	}
+	return ascii_domain, nil
+}
+
+func is_forbidden_host_code_point(c rune) bool {
+	return strings.ContainsRune("\x00\t\n\r #/:<>?@[\\]^|", c)
+}
+
+func is_forbidden_domain_code_point(c rune) bool {
+	return is_forbidden_host_code_point(c) || c <= 0x1f || c == '%' || c == 0x7f
+}
+
+// domain_to_ascii lowercases the domain and punycodes labels that are not
+// ASCII. This is a small part of UTS #46, enough for most real domains.
+func domain_to_ascii(domain string) (string, error) {
+	labels := strings.Split(strings.ToLower(domain), ".")
+	for i, label := range labels {
+		ascii := true
+		for _, c := range label {
+			if c >= utf8.RuneSelf {
+				ascii = false
+			}
+		}
+		if !ascii {
+			labels[i] = "xn--" + punycode_encode([]rune(label))
+		}
+	}
+	result := strings.Join(labels, ".")
+	if result == "" {
+		return "", errors.New("empty host")
+	}
+	return result, nil
+}
+
+func ends_in_a_number(input string) bool {
+	parts := strings.Split(input, ".")
+	if parts[len(parts)-1] == "" {
+		if len(parts) == 1 {
+			return false
+		}
+		parts = parts[:len(parts)-1]
+	}
+	last := parts[len(parts)-1]
+	if last != "" && !strings.ContainsFunc(last, func(c rune) bool { return !is_ascii_digit(c) }) {
+		return true
+	}
+	_, err := parse_ipv4_number(last)
+	return err == nil
+}
+
+func parse_ipv4(input string) (uint32, error) {
+	parts := strings.Split(input, ".")
+	if parts[len(parts)-1] == "" && len(parts) > 1 {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) > 4 {
+		return 0, errors.New("too many IPv4 parts")
+	}
+	numbers := []uint64{}
+	for _, part := range parts {
+		n, err := parse_ipv4_number(part)
+		if err != nil {
+			return 0, err
+		}
+		numbers = append(numbers, n)
+	}
+	for _, n := range numbers[:len(numbers)-1] {
+		if n > 255 {
+			return 0, errors.New("IPv4 part out of range")
+		}
+	}
+	last := numbers[len(numbers)-1]
+	if last >= 1<<(8*(5-len(numbers))) {
+		return 0, errors.New("IPv4 address out of range")
+	}
+	ipv4 := last
+	for i, n := range numbers[:len(numbers)-1] {
+		ipv4 += n << (8 * (3 - i))
+	}
+	return uint32(ipv4), nil
+}
+
+func parse_ipv4_number(input string) (uint64, error) {
+	if input == "" {
+		return 0, errors.New("empty IPv4 number")
+	}
+	radix := 10
+	if len(input) >= 2 && (input[:2] == "0x" || input[:2] == "0X") {
+		input = input[2:]
+		radix = 16
+	} else if len(input) >= 2 && input[0] == '0' {
+		input = input[1:]
+		radix = 8
+	}
+	if input == "" {
+		return 0, nil
+	}
+	// with an explicit radix ParseUint does not accept underscores
+	return strconv.ParseUint(input, radix, 64)
+}
+
+func serialize_ipv4(address uint32) string {
+	parts := make([]string, 4)
+	for i := range parts {
+		parts[3-i] = strconv.Itoa(int(address % 256))
+		address /= 256
+	}
+	return strings.Join(parts, ".")
+}
+
+func parse_ipv6(input string) ([8]uint16, error) {
+	address := [8]uint16{}
+	piece_index, compress := 0, -1
+	runes := []rune(input)
+	pointer := 0
+	at := func(i int) rune {
+		if i < len(runes) {
+			return runes[i]
+		}
+		return eof
+	}
+
+	if at(pointer) == ':' {
+		if at(pointer+1) != ':' {
+			return address, errors.New("IPv6 address starts with a single colon")
+		}
+		pointer += 2
+		piece_index++
+		compress = piece_index
+	}
+	for at(pointer) != eof {
+		if piece_index == 8 {
+			return address, errors.New("too many IPv6 pieces")
+		}
+		if at(pointer) == ':' {
+			if compress != -1 {
+				return address, errors.New("multiple IPv6 compressions")
+			}
+			pointer++
+			piece_index++
+			compress = piece_index
+			continue
+		}
+		value, length := 0, 0
+		for length < 4 && is_ascii_hex_digit(at(pointer)) {
+			digit, _ := strconv.ParseUint(string(at(pointer)), 16, 8)
+			value = value*0x10 + int(digit)
+			pointer++
+			length++
+		}
+		if at(pointer) == '.' {
+			if length == 0 {
+				return address, errors.New("invalid IPv4 in IPv6 address")
+			}
+			pointer -= length
+			if piece_index > 6 {
+				return address, errors.New("too many IPv6 pieces")
+			}
+			numbers_seen := 0
+			for at(pointer) != eof {
+				ipv4_piece := -1
+				if numbers_seen > 0 {
+					if at(pointer) == '.' && numbers_seen < 4 {
+						pointer++
+					} else {
+						return address, errors.New("invalid IPv4 in IPv6 address")
+					}
+				}
+				if !is_ascii_digit(at(pointer)) {
+					return address, errors.New("invalid IPv4 in IPv6 address")
+				}
+				for is_ascii_digit(at(pointer)) {
+					number := int(at(pointer) - '0')
+					if ipv4_piece == -1 {
+						ipv4_piece = number
+					} else if ipv4_piece == 0 {
+						return address, errors.New("invalid IPv4 in IPv6 address")
+					} else {
+						ipv4_piece = ipv4_piece*10 + number
+					}
+					if ipv4_piece > 255 {
+						return address, errors.New("invalid IPv4 in IPv6 address")
+					}
+					pointer++
+				}
+				address[piece_index] = address[piece_index]*0x100 + uint16(ipv4_piece)
+				numbers_seen++
+				if numbers_seen == 2 || numbers_seen == 4 {
+					piece_index++
+				}
+			}
+			if numbers_seen != 4 {
+				return address, errors.New("invalid IPv4 in IPv6 address")
+			}
+			break
+		} else if at(pointer) == ':' {
+			pointer++
+			if at(pointer) == eof {
+				return address, errors.New("IPv6 address ends with a colon")
+			}
+		} else if at(pointer) != eof {
+			return address, errors.New("invalid IPv6 code point")
+		}
+		address[piece_index] = uint16(value)
+		piece_index++
+	}
+	if compress != -1 {
+		swaps := piece_index - compress
+		piece_index = 7
+		for piece_index != 0 && swaps > 0 {
+			address[piece_index], address[compress+swaps-1] = address[compress+swaps-1], address[piece_index]
+			piece_index--
+			swaps--
+		}
+	} else if piece_index != 8 {
+		return address, errors.New("too few IPv6 pieces")
+	}
+	return address, nil
+}
+
+// serialize_ipv6 compresses the first longest run of zero pieces.
+func serialize_ipv6(address [8]uint16) string {
+	compress, longest := -1, 1
+	for i := 0; i < 8; {
+		if address[i] != 0 {
+			i++
+			continue
+		}
+		start := i
+		for i < 8 && address[i] == 0 {
+			i++
+		}
+		if i-start > longest {
+			compress, longest = start, i-start
+		}
+	}
+	var out strings.Builder
+	ignore0 := false
+	for i, piece := range address {
+		if ignore0 && piece == 0 {
+			continue
+		}
+		ignore0 = false
+		if compress == i {
+			if i == 0 {
+				out.WriteString("::")
+			} else {
+				out.WriteString(":")
+			}
+			ignore0 = true
+			continue
+		}
+		out.WriteString(strconv.FormatUint(uint64(piece), 16))
+		if i != 7 {
+			out.WriteString(":")
+		}
+	}
+	return out.String()
+}
+
+// punycode_encode implements the encoding of RFC 3492.
+func punycode_encode(input []rune) string {
+	const (
+		base         = 36
+		tmin         = 1
+		tmax         = 26
+		skew         = 38
+		damp         = 700
+		initial_bias = 72
+		initial_n    = 128
+	)
+	digit := func(d int) byte {
+		if d < 26 {
+			return byte('a' + d)
+		}
+		return byte('0' + d - 26)
+	}
+	adapt := func(delta, num_points int, first bool) int {
+		if first {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / num_points
+		k := 0
+		for delta > ((base-tmin)*tmax)/2 {
+			delta /= base - tmin
+			k += base
+		}
+		return k + (base-tmin+1)*delta/(delta+skew)
+	}
+
+	output := []byte{}
+	for _, c := range input {
+		if c < 0x80 {
+			output = append(output, byte(c))
+		}
+	}
+	b := len(output)
+	h := b
+	if b > 0 {
+		output = append(output, '-')
+	}
+	n, delta, bias := initial_n, 0, initial_bias
+	for h < len(input) {
+		m := int(utf8.MaxRune)
+		for _, c := range input {
+			if int(c) >= n && int(c) < m {
+				m = int(c)
+			}
+		}
+		delta += (m - n) * (h + 1)
+		n = m
+		for _, c := range input {
+			if int(c) < n {
+				delta++
+			}
+			if int(c) == n {
+				q := delta
+				for k := base; ; k += base {
+					t := k - bias
+					if t < tmin {
+						t = tmin
+					} else if t > tmax {
+						t = tmax
+					}
+					if q < t {
+						break
+					}
+					output = append(output, digit(t+(q-t)%(base-t)))
+					q = (q - t) / (base - t)
+				}
+				output = append(output, digit(q))
+				bias = adapt(delta, h+1, h == b)
+				delta = 0
+				h++
+			}
+		}
+		delta++
+		n++
+	}
+	return string(output)
+}
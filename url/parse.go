@@ -0,0 +1,606 @@
+package url
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The parser follows the basic URL parser of the WHATWG URL standard,
+// https://url.spec.whatwg.org/#concept-basic-url-parser, without the
+// state override that is only needed for the setters of the JS URL API.
+
+var (
+	// default ports, -1 for none
+	SPECIAL_SCHEMES = map[string]int{
+		"ftp":   21,
+		"file":  -1,
+		"http":  80,
+		"https": 443,
+		"ws":    80,
+		"wss":   443,
+	}
+
+	ErrInvalidURL = errors.New("invalid URL")
+)
+
+type parse_state int
+
+const (
+	scheme_start_state parse_state = iota
+	scheme_state
+	no_scheme_state
+	special_relative_or_authority_state
+	path_or_authority_state
+	relative_state
+	relative_slash_state
+	special_authority_slashes_state
+	special_authority_ignore_slashes_state
+	authority_state
+	host_state
+	port_state
+	file_state
+	file_slash_state
+	file_host_state
+	path_start_state
+	path_state
+	opaque_path_state
+	query_state
+	fragment_state
+)
+
+const eof = -1
+
+// url_record is the URL while it is being parsed. Null values of the
+// standard are represented by the has_ flags and port -1.
+type url_record struct {
+	scheme       string
+	username     string
+	password     string
+	host         string
+	has_host     bool
+	port         int
+	path         []string
+	opaque       bool
+	query        string
+	has_query    bool
+	fragment     string
+	has_fragment bool
+}
+
+func (r *url_record) special() bool {
+	_, ok := SPECIAL_SCHEMES[r.scheme]
+	return ok
+}
+
+func (r *url_record) shorten_path() {
+	if r.scheme == "file" && len(r.path) == 1 && is_normalized_windows_drive_letter(r.path[0]) {
+		return
+	}
+	if len(r.path) > 0 {
+		r.path = r.path[:len(r.path)-1]
+	}
+}
+
+func parse_error(input, reason string) error {
+	return fmt.Errorf("%w: %s: %q", ErrInvalidURL, reason, input)
+}
+
+// parse_url parses input, resolving it against base if that is not nil.
+func parse_url(input string, base *url_record) (*url_record, error) {
+	original := input
+	input = strings.TrimFunc(input, func(c rune) bool { return c <= ' ' })
+	input = strings.Map(func(c rune) rune {
+		if c == '\t' || c == '\n' || c == '\r' {
+			return -1
+		}
+		return c
+	}, input)
+
+	url := &url_record{port: -1}
+	state := scheme_start_state
+	buffer := []rune{}
+	at_sign_seen, inside_brackets, password_token_seen := false, false, false
+	runes := []rune(input)
+	remaining_starts_with := func(pointer int, s string) bool {
+		return strings.HasPrefix(string(runes[min(pointer+1, len(runes)):]), s)
+	}
+
+	for pointer := 0; pointer <= len(runes); pointer++ {
+		c := rune(eof)
+		if pointer < len(runes) {
+			c = runes[pointer]
+		}
+
+		switch state {
+		case scheme_start_state:
+			if is_ascii_alpha(c) {
+				buffer = append(buffer, to_ascii_lower(c))
+				state = scheme_state
+			} else {
+				state = no_scheme_state
+				pointer--
+			}
+
+		case scheme_state:
+			if is_ascii_alphanumeric(c) || c == '+' || c == '-' || c == '.' {
+				buffer = append(buffer, to_ascii_lower(c))
+			} else if c == ':' {
+				url.scheme = string(buffer)
+				buffer = buffer[:0]
+				if url.scheme == "file" {
+					state = file_state
+				} else if url.special() && base != nil && base.scheme == url.scheme {
+					state = special_relative_or_authority_state
+				} else if url.special() {
+					state = special_authority_slashes_state
+				} else if remaining_starts_with(pointer, "/") {
+					state = path_or_authority_state
+					pointer++
+				} else {
+					url.opaque = true
+					url.path = []string{""}
+					state = opaque_path_state
+				}
+			} else {
+				buffer = buffer[:0]
+				state = no_scheme_state
+				pointer = -1
+			}
+
+		case no_scheme_state:
+			if base == nil || (base.opaque && c != '#') {
+				return nil, parse_error(original, "missing scheme")
+			} else if base.opaque && c == '#' {
+				url.scheme = base.scheme
+				url.path = slices.Clone(base.path)
+				url.opaque = true
+				url.query, url.has_query = base.query, base.has_query
+				url.fragment, url.has_fragment = "", true
+				state = fragment_state
+			} else if base.scheme != "file" {
+				state = relative_state
+				pointer--
+			} else {
+				state = file_state
+				pointer--
+			}
+
+		case special_relative_or_authority_state:
+			if c == '/' && remaining_starts_with(pointer, "/") {
+				state = special_authority_ignore_slashes_state
+				pointer++
+			} else {
+				state = relative_state
+				pointer--
+			}
+
+		case path_or_authority_state:
+			if c == '/' {
+				state = authority_state
+			} else {
+				state = path_state
+				pointer--
+			}
+
+		case relative_state:
+			url.scheme = base.scheme
+			if c == '/' || (url.special() && c == '\\') {
+				state = relative_slash_state
+			} else {
+				url.username, url.password = base.username, base.password
+				url.host, url.has_host, url.port = base.host, base.has_host, base.port
+				url.path = slices.Clone(base.path)
+				url.query, url.has_query = base.query, base.has_query
+				if c == '?' {
+					url.query, url.has_query = "", true
+					state = query_state
+				} else if c == '#' {
+					url.fragment, url.has_fragment = "", true
+					state = fragment_state
+				} else if c != eof {
+					url.query, url.has_query = "", false
+					url.shorten_path()
+					state = path_state
+					pointer--
+				}
+			}
+
+		case relative_slash_state:
+			if url.special() && (c == '/' || c == '\\') {
+				state = special_authority_ignore_slashes_state
+			} else if c == '/' {
+				state = authority_state
+			} else {
+				url.username, url.password = base.username, base.password
+				url.host, url.has_host, url.port = base.host, base.has_host, base.port
+				state = path_state
+				pointer--
+			}
+
+		case special_authority_slashes_state:
+			state = special_authority_ignore_slashes_state
+			if c == '/' && remaining_starts_with(pointer, "/") {
+				pointer++
+			} else {
+				pointer--
+			}
+
+		case special_authority_ignore_slashes_state:
+			if c != '/' && c != '\\' {
+				state = authority_state
+				pointer--
+			}
+
+		case authority_state:
+			if c == '@' {
+				if at_sign_seen {
+					buffer = append([]rune("%40"), buffer...)
+				}
+				at_sign_seen = true
+				for _, b := range buffer {
+					if b == ':' && !password_token_seen {
+						password_token_seen = true
+						continue
+					}
+					encoded := percent_encode(string(b), in_userinfo_set)
+					if password_token_seen {
+						url.password += encoded
+					} else {
+						url.username += encoded
+					}
+				}
+				buffer = buffer[:0]
+			} else if c == eof || c == '/' || c == '?' || c == '#' || (url.special() && c == '\\') {
+				if at_sign_seen && len(buffer) == 0 {
+					return nil, parse_error(original, "missing host")
+				}
+				pointer -= len(buffer) + 1
+				buffer = buffer[:0]
+				state = host_state
+			} else {
+				buffer = append(buffer, c)
+			}
+
+		case host_state:
+			if c == ':' && !inside_brackets {
+				if len(buffer) == 0 {
+					return nil, parse_error(original, "missing host")
+				}
+				host, err := parse_host(string(buffer), !url.special())
+				if err != nil {
+					return nil, parse_error(original, err.Error())
+				}
+				url.host, url.has_host = host, true
+				buffer = buffer[:0]
+				state = port_state
+			} else if c == eof || c == '/' || c == '?' || c == '#' || (url.special() && c == '\\') {
+				pointer--
+				if url.special() && len(buffer) == 0 {
+					return nil, parse_error(original, "missing host")
+				}
+				host, err := parse_host(string(buffer), !url.special())
+				if err != nil {
+					return nil, parse_error(original, err.Error())
+				}
+				url.host, url.has_host = host, true
+				buffer = buffer[:0]
+				state = path_start_state
+			} else {
+				if c == '[' {
+					inside_brackets = true
+				} else if c == ']' {
+					inside_brackets = false
+				}
+				buffer = append(buffer, c)
+			}
+
+		case port_state:
+			if is_ascii_digit(c) {
+				buffer = append(buffer, c)
+			} else if c == eof || c == '/' || c == '?' || c == '#' || (url.special() && c == '\\') {
+				if len(buffer) != 0 {
+					port, err := strconv.Atoi(string(buffer))
+					if err != nil || port > 65535 {
+						return nil, parse_error(original, "port out of range")
+					}
+					if default_port, ok := SPECIAL_SCHEMES[url.scheme]; ok && default_port == port {
+						port = -1
+					}
+					url.port = port
+					buffer = buffer[:0]
+				}
+				state = path_start_state
+				pointer--
+			} else {
+				return nil, parse_error(original, "invalid port")
+			}
+
+		case file_state:
+			url.scheme = "file"
+			url.host, url.has_host = "", true
+			if c == '/' || c == '\\' {
+				state = file_slash_state
+			} else if base != nil && base.scheme == "file" {
+				url.host, url.has_host = base.host, base.has_host
+				url.path = slices.Clone(base.path)
+				url.query, url.has_query = base.query, base.has_query
+				if c == '?' {
+					url.query, url.has_query = "", true
+					state = query_state
+				} else if c == '#' {
+					url.fragment, url.has_fragment = "", true
+					state = fragment_state
+				} else if c != eof {
+					url.query, url.has_query = "", false
+					if !starts_with_windows_drive_letter(runes[pointer:]) {
+						url.shorten_path()
+					} else {
+						url.path = nil
+					}
+					state = path_state
+					pointer--
+				}
+			} else {
+				state = path_state
+				pointer--
+			}
+
+		case file_slash_state:
+			if c == '/' || c == '\\' {
+				state = file_host_state
+			} else {
+				if base != nil && base.scheme == "file" {
+					url.host, url.has_host = base.host, base.has_host
+					if !starts_with_windows_drive_letter(runes[pointer:]) &&
+						len(base.path) > 0 && is_normalized_windows_drive_letter(base.path[0]) {
+						url.path = append(url.path, base.path[0])
+					}
+				}
+				state = path_state
+				pointer--
+			}
+
+		case file_host_state:
+			if c == eof || c == '/' || c == '\\' || c == '?' || c == '#' {
+				pointer--
+				if is_windows_drive_letter(string(buffer)) {
+					// a drive letter, not a host
+					state = path_state
+				} else if len(buffer) == 0 {
+					url.host, url.has_host = "", true
+					state = path_start_state
+				} else {
+					host, err := parse_host(string(buffer), !url.special())
+					if err != nil {
+						return nil, parse_error(original, err.Error())
+					}
+					if host == "localhost" {
+						host = ""
+					}
+					url.host, url.has_host = host, true
+					buffer = buffer[:0]
+					state = path_start_state
+				}
+			} else {
+				buffer = append(buffer, c)
+			}
+
+		case path_start_state:
+			if url.special() {
+				state = path_state
+				if c != '/' && c != '\\' {
+					pointer--
+				}
+			} else if c == '?' {
+				url.query, url.has_query = "", true
+				state = query_state
+			} else if c == '#' {
+				url.fragment, url.has_fragment = "", true
+				state = fragment_state
+			} else if c != eof {
+				state = path_state
+				if c != '/' {
+					pointer--
+				}
+			}
+
+		case path_state:
+			end_of_segment := c == eof || c == '/' || (url.special() && c == '\\')
+			if end_of_segment || c == '?' || c == '#' {
+				slash := c == '/' || (url.special() && c == '\\')
+				segment := string(buffer)
+				if is_double_dot_segment(segment) {
+					url.shorten_path()
+					if !slash {
+						url.path = append(url.path, "")
+					}
+				} else if is_single_dot_segment(segment) {
+					if !slash {
+						url.path = append(url.path, "")
+					}
+				} else {
+					if url.scheme == "file" && len(url.path) == 0 && is_windows_drive_letter(segment) {
+						segment = segment[:1] + ":"
+					}
+					url.path = append(url.path, segment)
+				}
+				buffer = buffer[:0]
+				if c == '?' {
+					url.query, url.has_query = "", true
+					state = query_state
+				} else if c == '#' {
+					url.fragment, url.has_fragment = "", true
+					state = fragment_state
+				}
+			} else {
+				buffer = append(buffer, []rune(percent_encode(string(c), in_path_set))...)
+			}
+
+		case opaque_path_state:
+			if c == '?' {
+				url.query, url.has_query = "", true
+				state = query_state
+			} else if c == '#' {
+				url.fragment, url.has_fragment = "", true
+				state = fragment_state
+			} else if c != eof {
+				url.path[0] += percent_encode(string(c), in_c0_control_set)
+			}
+
+		case query_state:
+			if c == '#' || c == eof {
+				set := in_query_set
+				if url.special() {
+					set = in_special_query_set
+				}
+				url.query += percent_encode(string(buffer), set)
+				buffer = buffer[:0]
+				if c == '#' {
+					url.fragment, url.has_fragment = "", true
+					state = fragment_state
+				}
+			} else {
+				buffer = append(buffer, c)
+			}
+
+		case fragment_state:
+			if c != eof {
+				url.fragment += percent_encode(string(c), in_fragment_set)
+			}
+		}
+	}
+	return url, nil
+}
+
+// serialize implements the URL serializer, optionally leaving out the fragment.
+func (r *url_record) serialize(exclude_fragment bool) string {
+	var out strings.Builder
+	out.WriteString(r.scheme + ":")
+	if r.has_host {
+		out.WriteString("//")
+		if r.username != "" || r.password != "" {
+			out.WriteString(r.username)
+			if r.password != "" {
+				out.WriteString(":" + r.password)
+			}
+			out.WriteString("@")
+		}
+		out.WriteString(r.host)
+		if r.port != -1 {
+			out.WriteString(":" + strconv.Itoa(r.port))
+		}
+	}
+	if r.opaque {
+		out.WriteString(r.path[0])
+	} else {
+		if !r.has_host && len(r.path) > 1 && r.path[0] == "" {
+			out.WriteString("/.")
+		}
+		for _, segment := range r.path {
+			out.WriteString("/" + segment)
+		}
+	}
+	if r.has_query {
+		out.WriteString("?" + r.query)
+	}
+	if r.has_fragment && !exclude_fragment {
+		out.WriteString("#" + r.fragment)
+	}
+	return out.String()
+}
+
+func is_ascii_alpha(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func is_ascii_digit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+func is_ascii_alphanumeric(c rune) bool {
+	return is_ascii_alpha(c) || is_ascii_digit(c)
+}
+
+func is_ascii_hex_digit(c rune) bool {
+	return is_ascii_digit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func to_ascii_lower(c rune) rune {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+func is_windows_drive_letter(s string) bool {
+	return len(s) == 2 && is_ascii_alpha(rune(s[0])) && (s[1] == ':' || s[1] == '|')
+}
+
+func is_normalized_windows_drive_letter(s string) bool {
+	return is_windows_drive_letter(s) && s[1] == ':'
+}
+
+func starts_with_windows_drive_letter(runes []rune) bool {
+	if len(runes) < 2 || !is_windows_drive_letter(string(runes[:2])) {
+		return false
+	}
+	return len(runes) == 2 || slices.Contains([]rune{'/', '\\', '?', '#'}, runes[2])
+}
+
+func is_single_dot_segment(s string) bool {
+	return s == "." || strings.EqualFold(s, "%2e")
+}
+
+func is_double_dot_segment(s string) bool {
+	switch strings.ToLower(s) {
+	case "..", ".%2e", "%2e.", "%2e%2e":
+		return true
+	}
+	return false
+}
+
+// percent-encode sets
+
+func in_c0_control_set(c rune) bool {
+	return c < 0x20 || c > 0x7e
+}
+
+func in_fragment_set(c rune) bool {
+	return in_c0_control_set(c) || c == ' ' || c == '"' || c == '<' || c == '>' || c == '`'
+}
+
+func in_query_set(c rune) bool {
+	return in_c0_control_set(c) || c == ' ' || c == '"' || c == '#' || c == '<' || c == '>'
+}
+
+func in_special_query_set(c rune) bool {
+	return in_query_set(c) || c == '\''
+}
+
+func in_path_set(c rune) bool {
+	return in_query_set(c) || c == '?' || c == '`' || c == '{' || c == '}'
+}
+
+func in_userinfo_set(c rune) bool {
+	return in_path_set(c) || c == '/' || c == ':' || c == ';' || c == '=' || c == '@' ||
+		(c >= '[' && c <= '^') || c == '|'
+}
+
+func percent_encode(s string, in_set func(rune) bool) string {
+	var out strings.Builder
+	for _, c := range s {
+		if !in_set(c) {
+			out.WriteRune(c)
+			continue
+		}
+		buf := make([]byte, utf8.UTFMax)
+		for _, b := range buf[:utf8.EncodeRune(buf, c)] {
+			fmt.Fprintf(&out, "%%%02X", b)
+		}
+	}
+	return out.String()
+}
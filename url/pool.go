@@ -0,0 +1,135 @@
+package url
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	CONNECTION_POOL = NewConnectionPool(6, 30*time.Second)
+)
+
+type Connection struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	origin     string
+	reused     bool
+	idle_since time.Time
+}
+
+// ConnectionPool keeps idle keep-alive connections around per origin and
+// limits how many connections may be open to one origin at the same time.
+type ConnectionPool struct {
+	MaxPerHost  int
+	IdleTimeout time.Duration
+
+	condition *sync.Cond
+	idle      map[string][]*Connection
+	open      map[string]int
+}
+
+func NewConnectionPool(max_per_host int, idle_timeout time.Duration) *ConnectionPool {
+	return &ConnectionPool{
+		MaxPerHost:  max_per_host,
+		IdleTimeout: idle_timeout,
+		condition:   sync.NewCond(&sync.Mutex{}),
+		idle:        make(map[string][]*Connection),
+		open:        make(map[string]int),
+	}
+}
+
+// Get returns an idle connection to origin if there is one, otherwise it
+// dials a new one, blocking while the origin is at its connection limit
+// or until ctx is cancelled.
+func (p *ConnectionPool) Get(ctx context.Context, origin string, dial func(context.Context) (net.Conn, error)) (*Connection, error) {
+	// wake up the wait below on cancellation
+	stop := context.AfterFunc(ctx, func() {
+		p.condition.L.Lock()
+		p.condition.Broadcast()
+		p.condition.L.Unlock()
+	})
+	defer stop()
+
+	p.condition.L.Lock()
+	for {
+		if err := ctx.Err(); err != nil {
+			p.condition.L.Unlock()
+			return nil, err
+		}
+		p.close_expired()
+		if idle := p.idle[origin]; len(idle) > 0 {
+			connection := idle[len(idle)-1]
+			p.idle[origin] = idle[:len(idle)-1]
+			p.condition.L.Unlock()
+			connection.reused = true
+			return connection, nil
+		}
+		if p.MaxPerHost <= 0 || p.open[origin] < p.MaxPerHost {
+			break
+		}
+		p.condition.Wait()
+	}
+	p.open[origin]++
+	p.condition.L.Unlock()
+
+	conn, err := dial(ctx)
+	if err != nil {
+		p.condition.L.Lock()
+		p.open[origin]--
+		p.condition.Broadcast()
+		p.condition.L.Unlock()
+		return nil, err
+	}
+	return &Connection{conn: conn, reader: bufio.NewReader(conn), origin: origin}, nil
+}
+
+// Put hands a connection whose response has been fully read back to the pool.
+func (p *ConnectionPool) Put(connection *Connection) {
+	p.condition.L.Lock()
+	connection.idle_since = time.Now()
+	p.close_expired()
+	p.idle[connection.origin] = append(p.idle[connection.origin], connection)
+	p.condition.Broadcast()
+	p.condition.L.Unlock()
+}
+
+// Discard closes a connection that can not be reused.
+func (p *ConnectionPool) Discard(connection *Connection) {
+	connection.conn.Close()
+	p.condition.L.Lock()
+	p.open[connection.origin]--
+	p.condition.Broadcast()
+	p.condition.L.Unlock()
+}
+
+// CloseIdle closes all idle connections, e.g. before quitting.
+func (p *ConnectionPool) CloseIdle() {
+	p.condition.L.Lock()
+	for origin, idle := range p.idle {
+		for _, connection := range idle {
+			connection.conn.Close()
+		}
+		p.open[origin] -= len(idle)
+	}
+	clear(p.idle)
+	p.condition.Broadcast()
+	p.condition.L.Unlock()
+}
+
+func (p *ConnectionPool) close_expired() {
+	for origin, idle := range p.idle {
+		alive := idle[:0]
+		for _, connection := range idle {
+			if time.Since(connection.idle_since) > p.IdleTimeout {
+				connection.conn.Close()
+				p.open[origin]--
+			} else {
+				alive = append(alive, connection)
+			}
+		}
+		p.idle[origin] = alive
+	}
+}
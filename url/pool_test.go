@@ -0,0 +1,175 @@
+package url
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startKeepAliveServer answers every request on a connection until the
+// client hangs up, or after max_requests if that is positive.
+func startKeepAliveServer(t *testing.T, max_requests int, delay time.Duration) (string, *atomic.Int32, *atomic.Int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := &atomic.Int32{}
+	active, max_active := &atomic.Int32{}, &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for n := 1; ; n++ {
+					reqline, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					for {
+						line, err := reader.ReadString('\n')
+						if err != nil || line == "\r\n" {
+							break
+						}
+					}
+					now := active.Add(1)
+					for {
+						prev := max_active.Load()
+						if now <= prev || max_active.CompareAndSwap(prev, now) {
+							break
+						}
+					}
+					time.Sleep(delay)
+					active.Add(-1)
+					body := strings.Fields(reqline)[1]
+					conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+					if max_requests > 0 && n >= max_requests {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String(), accepted, max_active
+}
+
+func withPool(t *testing.T, pool *ConnectionPool) {
+	old := CONNECTION_POOL
+	CONNECTION_POOL = pool
+	t.Cleanup(func() {
+		pool.CloseIdle()
+		CONNECTION_POOL = old
+	})
+}
+
+func TestKeepAliveReusesConnection(t *testing.T) {
+	withPool(t, NewConnectionPool(6, time.Minute))
+	base, accepted, _ := startKeepAliveServer(t, 0, 0)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		u, _ := NewURL(base + path)
+		response, err := u.Request(nil, nil)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err)
+		}
+		if string(response.Body) != path {
+			t.Errorf("Expected body '%s', got '%s'", path, response.Body)
+		}
+	}
+	if accepted.Load() != 1 {
+		t.Errorf("Expected 1 connection, got %d", accepted.Load())
+	}
+}
+
+func TestKeepAliveIdleTimeout(t *testing.T) {
+	withPool(t, NewConnectionPool(6, 10*time.Millisecond))
+	base, accepted, _ := startKeepAliveServer(t, 0, 0)
+	u, _ := NewURL(base + "/")
+	u.Request(nil, nil)
+	time.Sleep(50 * time.Millisecond)
+	u.Request(nil, nil)
+	if accepted.Load() != 2 {
+		t.Errorf("Expected idle connection to expire, got %d connections", accepted.Load())
+	}
+}
+
+func TestKeepAliveRetriesClosedConnection(t *testing.T) {
+	withPool(t, NewConnectionPool(6, time.Minute))
+	base, accepted, _ := startKeepAliveServer(t, 1, 0)
+	for i := 0; i < 3; i++ {
+		u, _ := NewURL(base + "/retry")
+		response, err := u.Request(nil, nil)
+		if err != nil {
+			t.Fatalf("Request %d failed: %s", i, err)
+		}
+		if string(response.Body) != "/retry" {
+			t.Errorf("Expected body '/retry', got '%s'", response.Body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if accepted.Load() != 3 {
+		t.Errorf("Expected a new connection per request, got %d", accepted.Load())
+	}
+}
+
+func TestKeepAliveMaxPerHost(t *testing.T) {
+	withPool(t, NewConnectionPool(2, time.Minute))
+	base, accepted, max_active := startKeepAliveServer(t, 0, 20*time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, _ := NewURL(base + "/" + strconv.Itoa(i))
+			response, err := u.Request(nil, nil)
+			if err != nil {
+				t.Errorf("Request failed: %s", err)
+			} else if string(response.Body) != "/"+strconv.Itoa(i) {
+				t.Errorf("Expected body '/%d', got '%s'", i, response.Body)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted.Load() > 2 {
+		t.Errorf("Expected at most 2 connections, got %d", accepted.Load())
+	}
+	if max_active.Load() > 2 {
+		t.Errorf("Expected at most 2 concurrent requests, got %d", max_active.Load())
+	}
+}
+
+func TestRequestContextCancel(t *testing.T) {
+	withPool(t, NewConnectionPool(1, time.Minute))
+	base, _, _ := startKeepAliveServer(t, 0, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		// the second request waits for the pool and must give up as well
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, _ := NewURL(base + "/")
+			if _, err := u.RequestContext(ctx, nil, nil); !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected context.Canceled, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Expected cancelled requests to return early, took %s", time.Since(start))
+	}
+}
@@ -0,0 +1,87 @@
+package url
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// RootCAs is nil until LoadCABundle is called, which means the system roots
+	TLS_CONFIG = &tls.Config{}
+
+	cert_exceptions      = map[string]bool{}
+	cert_exceptions_lock = &sync.Mutex{}
+
+	// wraps handshake failures other than an untrusted certificate
+	ErrTLSHandshake = errors.New("TLS handshake failed")
+)
+
+// CertificateError is returned when the certificate of Host could not be verified.
+type CertificateError struct {
+	Host string
+	Err  error
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("certificate for %s could not be verified: %s", e.Host, e.Err.Error())
+}
+
+func (e *CertificateError) Unwrap() error {
+	return e.Err
+}
+
+// LoadCABundle trusts the PEM encoded certificates in file in addition to the system roots.
+func LoadCABundle(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in %s", file)
+	}
+	TLS_CONFIG.RootCAs = pool
+	return nil
+}
+
+// AddCertException skips certificate verification for host from now on,
+// after the user chose to proceed anyway.
+func AddCertException(host string) {
+	cert_exceptions_lock.Lock()
+	defer cert_exceptions_lock.Unlock()
+	cert_exceptions[host] = true
+}
+
+func HasCertException(host string) bool {
+	cert_exceptions_lock.Lock()
+	defer cert_exceptions_lock.Unlock()
+	return cert_exceptions[host]
+}
+
+func (u *URL) tls_client(ctx context.Context, conn net.Conn) (*tls.Conn, error) {
+	config := TLS_CONFIG.Clone()
+	config.ServerName = strings.Trim(u.host, "[]") // IPv6 addresses without brackets
+	config.InsecureSkipVerify = HasCertException(u.host)
+	tls_conn := tls.Client(conn, config)
+	if err := tls_conn.HandshakeContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var verification_err *tls.CertificateVerificationError
+		if errors.As(err, &verification_err) {
+			return nil, &CertificateError{Host: u.host, Err: verification_err.Err}
+		}
+		return nil, fmt.Errorf("%w: %w", ErrTLSHandshake, err)
+	}
+	return tls_conn, nil
+}
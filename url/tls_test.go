@@ -0,0 +1,128 @@
+package url
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTLSServer serves a fixed page over TLS with a self-signed certificate
+// for localhost and returns its base URL and the certificate as PEM.
+func startTLSServer(t *testing.T) (string, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4096)
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+				conn.Write([]byte("HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nsecure"))
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return "https://localhost:" + port, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func withTLSConfig(t *testing.T) {
+	old := TLS_CONFIG
+	TLS_CONFIG = &tls.Config{}
+	withPool(t, NewConnectionPool(6, time.Minute))
+	t.Cleanup(func() {
+		TLS_CONFIG = old
+		cert_exceptions_lock.Lock()
+		clear(cert_exceptions)
+		cert_exceptions_lock.Unlock()
+	})
+}
+
+func TestTLSUnknownAuthority(t *testing.T) {
+	withTLSConfig(t)
+	base, _ := startTLSServer(t)
+	u, _ := NewURL(base + "/")
+	_, err := u.Request(nil, nil)
+	var cert_err *CertificateError
+	if !errors.As(err, &cert_err) {
+		t.Fatalf("Expected CertificateError, got %v", err)
+	}
+	if cert_err.Host != "localhost" {
+		t.Errorf("Expected host 'localhost', got '%s'", cert_err.Host)
+	}
+}
+
+func TestTLSCABundle(t *testing.T) {
+	withTLSConfig(t)
+	base, cert := startTLSServer(t)
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(file, cert, 0644)
+	if err := LoadCABundle(file); err != nil {
+		t.Fatalf("LoadCABundle failed: %s", err)
+	}
+	u, _ := NewURL(base + "/")
+	response, err := u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if string(response.Body) != "secure" {
+		t.Errorf("Expected body 'secure', got '%s'", response.Body)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0644)
+	if err := LoadCABundle(empty); err == nil {
+		t.Error("Expected error for bundle without certificates, but did not error")
+	}
+}
+
+func TestTLSCertException(t *testing.T) {
+	withTLSConfig(t)
+	base, _ := startTLSServer(t)
+	AddCertException("localhost")
+	u, _ := NewURL(base + "/")
+	response, err := u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if string(response.Body) != "secure" {
+		t.Errorf("Expected body 'secure', got '%s'", response.Body)
+	}
+}
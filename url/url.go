@@ -1,220 +1,568 @@
 package url
 
 import (
-	"bufio"
-	"crypto/tls"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
-type Param struct {
-	cookie string
-	params map[string]string
-}
-
 var (
-	COOKIE_JAR = map[string]Param{}
+	MAX_REDIRECTS   = 10
+	REDIRECT_CODES  = []int{301, 302, 303, 307, 308}
+	REQUEST_TIMEOUT = 30 * time.Second
+	// the largest body the browser accepts, before and after decoding
+	MAX_BODY_SIZE int64 = 64 << 20
+
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrBodyTooLarge     = errors.New("body too large")
+	errConnectionClosed = errors.New("connection closed")
 )
 
 type URL struct {
-	scheme string
-	host   string
-	path   string
-	port   int
+	scheme   string
+	username string
+	password string
+	host     string
+	port     int // the default port of the scheme if none is given
+	path     string
+	query    string
+	fragment string
+
+	has_host     bool
+	opaque       bool // the path of URLs like data: is not split into segments
+	has_query    bool
+	has_fragment bool
+
+	// data URLs
+	media_type string
+	charset    string
+	base64     bool
+	data       string
+
+	// view-source URLs, the URL whose source is shown
+	source *URL
 }
 
+var SUPPORTED_SCHEMES = []string{"http", "https", "file", "data", "view-source"}
+
 func NewURL(url string) (*URL, error) {
-	u := &URL{}
-	splitURL := strings.Split(url, "://")
-	if len(splitURL) < 2 {
-		return nil, fmt.Errorf("no URL scheme: %s", url)
-	}
-	u.scheme, url = splitURL[0], splitURL[1]
-	if u.scheme != "http" && u.scheme != "https" {
-		return nil, fmt.Errorf("unsupported URL scheme: %s", u.scheme)
-	}
-	if u.scheme == "http" {
-		u.port = 80
-	} else if u.scheme == "https" {
-		u.port = 443
-	}
-	if !strings.Contains(url, "/") {
-		url += "/"
-	}
-	splitPath := strings.SplitN(url, "/", 2)
-	u.host, url = splitPath[0], splitPath[1]
-	if strings.Contains(u.host, ":") {
-		hostParts := strings.Split(u.host, ":")
-		u.host = hostParts[0]
-		port, err := strconv.Atoi(hostParts[1])
-		if err != nil {
-			return nil, fmt.Errorf("invalid port in URL: %s", hostParts[1])
+	return new_url(url, nil)
+}
+
+func new_url(url string, base *URL) (*URL, error) {
+	var base_record *url_record
+	if base != nil {
+		base_record = base.record()
+	}
+	record, err := parse_url(url, base_record)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(SUPPORTED_SCHEMES, record.scheme) {
+		return nil, fmt.Errorf("unsupported URL scheme: %s", record.scheme)
+	}
+	u := record.url()
+	if u.scheme == "data" {
+		if err := u.parse_data(); err != nil {
+			return nil, err
+		}
+	} else if u.scheme == "view-source" {
+		if err := u.parse_view_source(); err != nil {
+			return nil, err
 		}
-		u.port = port
 	}
-	u.path = "/" + url
 	return u, nil
 }
 
-func (u *URL) Request(referrer *URL, payload string) (map[string]string, []byte, error) {
-	// Create connection
-	conn, err := net.Dial("tcp", u.host+":"+strconv.Itoa(u.port))
+// parse_view_source parses the URL after view-source:, which can't be
+// another view-source URL.
+func (u *URL) parse_view_source() error {
+	source := u.path
+	if u.has_query {
+		source += "?" + u.query
+	}
+	url, err := NewURL(source)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to connect to host: %s", err.Error())
+		return fmt.Errorf("invalid view-source URL: %w", err)
 	}
-	defer conn.Close()
-	if u.scheme == "https" {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: true, // For simplicity, skip TLS verification
+	if url.scheme == "view-source" {
+		return fmt.Errorf("view-source URLs can't be nested: %s", u)
+	}
+	u.source = url
+	return nil
+}
+
+func (r *url_record) url() *URL {
+	u := &URL{
+		scheme:       r.scheme,
+		username:     r.username,
+		password:     r.password,
+		host:         r.host,
+		port:         r.port,
+		query:        r.query,
+		fragment:     r.fragment,
+		has_host:     r.has_host,
+		opaque:       r.opaque,
+		has_query:    r.has_query,
+		has_fragment: r.has_fragment,
+	}
+	if u.port == -1 {
+		u.port = max(SPECIAL_SCHEMES[u.scheme], 0)
+	}
+	if r.opaque {
+		u.path = r.path[0]
+	} else if len(r.path) > 0 {
+		u.path = "/" + strings.Join(r.path, "/")
+	}
+	return u
+}
+
+func (u *URL) record() *url_record {
+	r := &url_record{
+		scheme:       u.scheme,
+		username:     u.username,
+		password:     u.password,
+		host:         u.host,
+		port:         u.port,
+		query:        u.query,
+		fragment:     u.fragment,
+		has_host:     u.has_host,
+		opaque:       u.opaque,
+		has_query:    u.has_query,
+		has_fragment: u.has_fragment,
+	}
+	if u.port == max(SPECIAL_SCHEMES[u.scheme], 0) {
+		r.port = -1
+	}
+	if u.opaque {
+		r.path = []string{u.path}
+	} else if u.path != "" {
+		r.path = strings.Split(u.path[1:], "/")
+	}
+	return r
+}
+
+type Response struct {
+	URL     *URL
+	Status  int
+	Reason  string // the reason phrase of the status line, e.g. "Not Found"
+	Headers map[string]string
+	Body    []byte
+}
+
+// A Payload is the body of a request, like a form submission, together
+// with the method and Content-Type it is sent with. Requests without one
+// are GETs.
+type Payload struct {
+	Method      string // like POST, there is no default
+	ContentType string // with its parameters, like the multipart boundary
+	Body        string
+}
+
+// method is the method payload is sent with, GET for a nil payload.
+func (p *Payload) method() string {
+	if p == nil {
+		return "GET"
+	}
+	return p.Method
+}
+
+// body is the body of payload, none for a nil payload.
+func (p *Payload) body() string {
+	if p == nil {
+		return ""
+	}
+	return p.Body
+}
+
+func (u *URL) Request(referrer *URL, payload *Payload) (*Response, error) {
+	return u.RequestContext(context.Background(), referrer, payload)
+}
+
+type redirect_check_key struct{}
+
+// WithRedirectCheck returns a context in which requests pass every URL
+// they are redirected to through check first, and fail with its error
+// instead of following the redirect.
+func WithRedirectCheck(ctx context.Context, check func(*URL) error) context.Context {
+	return context.WithValue(ctx, redirect_check_key{}, check)
+}
+
+func check_redirect(ctx context.Context, url *URL) error {
+	if check, ok := ctx.Value(redirect_check_key{}).(func(*URL) error); ok {
+		return check(url)
+	}
+	return nil
+}
+
+// RequestContext is like Request, but gives up as soon as ctx is cancelled.
+func (u *URL) RequestContext(ctx context.Context, referrer *URL, payload *Payload) (*Response, error) {
+	method := payload.method()
+	url := u
+	visited := map[string]bool{}
+	loop := false
+	for redirects := 0; ; redirects++ {
+		key := method + " " + url.String()
+		loop = loop || visited[key]
+		visited[key] = true
+
+		response, err := url.request(ctx, referrer, method, payload)
+		if err != nil {
+			return nil, err
 		}
-		conn = tls.Client(conn, tlsConfig)
-		if err := conn.(*tls.Conn).Handshake(); err != nil {
-			return nil, nil, fmt.Errorf("failed to perform TLS handshake: %s", err.Error())
+		location, ok := response.Headers["location"]
+		if !ok || !slices.Contains(REDIRECT_CODES, response.Status) {
+			return response, nil
 		}
-	}
 
-	// Create Request Header
-	method := "GET"
-	if payload != "" {
-		method = "POST"
-	}
-
-	request := method + " " + u.path + " HTTP/1.1\r\n"
-	if cookie, ok := COOKIE_JAR[u.host]; ok {
-		cookie, params := cookie.cookie, cookie.params
-		allow_cookie := true
-		if referrer != nil && GetOrDefault(params, "samesite", "none") == "lax" {
-			if method != "GET" {
-				allow_cookie = u.host == referrer.host
+		if redirects >= MAX_REDIRECTS {
+			if loop {
+				return nil, fmt.Errorf("%w: redirect loop at %s", ErrTooManyRedirects, url)
 			}
+			return nil, fmt.Errorf("%w: gave up after %d redirects at %s", ErrTooManyRedirects, redirects, url)
+		}
+		next, err := url.Resolve(location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redirect location: %s", err.Error())
+		}
+		if next.scheme != "http" && next.scheme != "https" {
+			return nil, fmt.Errorf("refusing to redirect to %s URL", next.scheme)
+		}
+		if err := check_redirect(ctx, next); err != nil {
+			return nil, err
+		}
+		// the fragment survives redirects that don't bring their own
+		if !next.has_fragment {
+			next.fragment, next.has_fragment = url.fragment, url.has_fragment
 		}
-		if allow_cookie {
-			request += "Cookie: " + cookie + "\r\n"
+		// POST becomes GET, only 307 and 308 keep the method and body
+		if response.Status == 303 || ((response.Status == 301 || response.Status == 302) && method == "POST") {
+			method = "GET"
+			payload = nil
 		}
+		url = next
 	}
-	if payload != "" {
-		length := len(payload)
-		request += "Content-Length: " + strconv.Itoa(length) + "\r\n"
+}
+
+func (u *URL) request(ctx context.Context, referrer *URL, method string, payload *Payload) (*Response, error) {
+	if u.scheme == "file" {
+		headers, body, err := u.request_file()
+		if err != nil {
+			return nil, err
+		}
+		return &Response{URL: u, Status: 200, Reason: "OK", Headers: headers, Body: body}, nil
+	} else if u.scheme == "view-source" {
+		return nil, fmt.Errorf("view-source URLs are shown by the browser, not requested: %s", u)
+	} else if u.scheme == "data" {
+		headers, body, err := u.request_data()
+		if err != nil {
+			return nil, err
+		}
+		return &Response{URL: u, Status: 200, Reason: "OK", Headers: headers, Body: body}, nil
+	}
+
+	var cached *CacheEntry
+	if method == "GET" {
+		cached = CACHE.Get(u.WithoutFragment().String())
+		if cached != nil && cached.fresh() {
+			return cached.response(u), nil
+		}
 	}
-	request += "Host: " + u.host + "\r\n"
-	request += "Connection: close\r\n"
+
+	// a server that does not answer in time counts as a failed load
+	ctx, cancel := context.WithTimeout(ctx, REQUEST_TIMEOUT)
+	defer cancel()
+
+	// Create Request Header
+	request := method + " " + u.request_target() + " HTTP/1.1\r\n"
+	if cookie := COOKIE_JAR.Cookies(u, referrer, method); cookie != "" {
+		request += "Cookie: " + cookie + "\r\n"
+	}
+	if payload != nil {
+		request += "Content-Length: " + strconv.Itoa(len(payload.Body)) + "\r\n"
+		if payload.ContentType != "" {
+			request += "Content-Type: " + payload.ContentType + "\r\n"
+		}
+	}
+	request += "Host: " + u.host_port() + "\r\n"
+	request += "Connection: keep-alive\r\n"
 	request += "User-Agent: Gowser\r\n"
+	request += "Accept-Encoding: " + ACCEPT_ENCODING + "\r\n"
+	if cached != nil {
+		if etag, ok := cached.Headers["etag"]; ok {
+			request += "If-None-Match: " + etag + "\r\n"
+		}
+		if last_modified, ok := cached.Headers["last-modified"]; ok {
+			request += "If-Modified-Since: " + last_modified + "\r\n"
+		}
+	}
 	request += "\r\n"
 
-	if payload != "" {
-		request += payload
+	request += payload.body()
+
+	for {
+		conn, err := CONNECTION_POOL.Get(ctx, u.Origin(), u.dial)
+		if err != nil {
+			return nil, err
+		}
+		response, keep_alive, err := u.exchange(ctx, conn, method, request)
+		if err != nil {
+			CONNECTION_POOL.Discard(conn)
+			// the server may have closed an idle connection, try again on a new one
+			if conn.reused && errors.Is(err, errConnectionClosed) {
+				continue
+			}
+			return nil, err
+		}
+		if keep_alive {
+			CONNECTION_POOL.Put(conn)
+		} else {
+			CONNECTION_POOL.Discard(conn)
+		}
+		if method == "GET" {
+			response = CACHE.update(u.WithoutFragment().String(), response, cached)
+		}
+		return response, nil
 	}
+}
+
+func (u *URL) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(strings.Trim(u.host, "[]"), strconv.Itoa(u.port)))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to connect to host: %w", err)
+	}
+	if u.scheme == "https" {
+		tls_conn, err := u.tls_client(ctx, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tls_conn
+	}
+	return conn, nil
+}
 
+// exchange sends the request on conn and reads the full response, reporting
+// whether the connection can be used for another request afterwards.
+func (u *URL) exchange(ctx context.Context, conn *Connection, method, request string) (*Response, bool, error) {
+	// unblock reads and writes once ctx is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		conn.conn.SetDeadline(time.Now())
+	})
+	response, keep_alive, err := u.read_exchange(conn, method, request)
+	if !stop() {
+		// the deadline is set, so the connection is no good anymore
+		keep_alive = false
+		if err != nil {
+			return nil, false, ctx.Err()
+		}
+	}
+	return response, keep_alive, err
+}
+
+func (u *URL) read_exchange(conn *Connection, method, request string) (*Response, bool, error) {
 	// Send Request Header
-	encoded := []byte(request)
-	_, err = conn.Write(encoded)
+	_, err := conn.conn.Write([]byte(request))
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to send request: %s", err.Error())
+		return nil, false, fmt.Errorf("%w: failed to send request: %s", errConnectionClosed, err.Error())
 	}
 
 	// Read Response
-	reader := bufio.NewReader(conn)
-	_, err = reader.ReadString('\n')
-	// statusline, err = reader.ReadString('\n')
+	reader := conn.reader
+	statusline, err := reader.ReadString('\n')
+	if err != nil {
+		if statusline == "" {
+			return nil, false, fmt.Errorf("%w: failed to read response: %s", errConnectionClosed, err.Error())
+		}
+		return nil, false, fmt.Errorf("failed to read response: %w", err)
+	}
+	split := strings.SplitN(strings.TrimSpace(statusline), " ", 3)
+	if len(split) < 2 || !strings.HasPrefix(split[0], "HTTP/") {
+		return nil, false, fmt.Errorf("malformed status line: %s", strings.TrimSpace(statusline))
+	}
+	version := split[0]
+	status, err := strconv.Atoi(split[1])
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read response: %s", err.Error())
+		return nil, false, fmt.Errorf("invalid status code: %s", split[1])
+	}
+	reason := ""
+	if len(split) > 2 {
+		reason = split[2]
 	}
-	// split := strings.SplitN(statusline, " ", 3)
-	// version, status, explanation := split[0], split[1], split[2]
 
 	responseHeaders := make(map[string]string)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to read response: %s", err.Error())
+			return nil, false, fmt.Errorf("failed to read response: %w", err)
 		}
 		if line == "\r\n" {
 			break
 		}
-		split := strings.SplitN(line, ":", 2)
-		header, value := split[0], split[1]
-		responseHeaders[strings.ToLower(header)] = strings.TrimSpace(value)
-	}
-
-	if cookie, ok := responseHeaders["set-cookie"]; ok {
-		params := map[string]string{}
-		if strings.Contains(cookie, ";") {
-			split := strings.SplitN(cookie, ";", 2)
-			cookie = split[0]
-			rest := split[1]
-			for _, param := range strings.Split(rest, ";") {
-				var value string
-				if strings.Contains(param, "=") {
-					split = strings.SplitN(param, "=", 2)
-					param = split[0]
-					value = split[1]
-				} else {
-					value = "true"
-				}
-				params[strings.ToLower(strings.TrimSpace(param))] = strings.ToLower(value)
-			}
+		header, value, found := strings.Cut(line, ":")
+		if !found {
+			// skip malformed lines instead of failing the response
+			continue
+		}
+		header, value = strings.ToLower(header), strings.TrimSpace(value)
+		responseHeaders[header] = value
+		// a response may set several cookies, so don't rely on the header map
+		if header == "set-cookie" {
+			COOKIE_JAR.SetCookie(u, value, false)
 		}
-		COOKIE_JAR[u.host] = Param{cookie, params}
 	}
 
-	if _, ok := responseHeaders["transfer-encoding"]; ok {
-		return nil, nil, fmt.Errorf("transfer-Encoding header found in response, unsupported")
-	}
-	if _, ok := responseHeaders["content-encoding"]; ok {
-		return nil, nil, fmt.Errorf("content-Encoding header found in response, unsupported")
+	connection := strings.ToLower(responseHeaders["connection"])
+	keep_alive := connection != "close" && (version != "HTTP/1.0" || connection == "keep-alive")
+
+	var content []byte
+	if method == "HEAD" || status/100 == 1 || status == 204 || status == 304 {
+		// no body
+	} else if encoding, ok := responseHeaders["transfer-encoding"]; ok {
+		codings := strings.Split(encoding, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return nil, false, fmt.Errorf("unsupported transfer encoding: %s", encoding)
+		}
+		body, trailers, err := ReadChunked(reader)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read response: %w", err)
+		}
+		maps.Copy(responseHeaders, trailers)
+		// any codings before chunked work just like a content encoding
+		content, err = DecodeContent(strings.Join(codings[:len(codings)-1], ","), body)
+		if err != nil {
+			return nil, false, err
+		}
+	} else if length, ok := responseHeaders["content-length"]; ok {
+		size, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || size < 0 {
+			return nil, false, fmt.Errorf("invalid content length: %s", length)
+		}
+		if size > MAX_BODY_SIZE {
+			return nil, false, fmt.Errorf("%w: content length %d", ErrBodyTooLarge, size)
+		}
+		// the buffer grows with what arrives, not with what the header promises
+		var body bytes.Buffer
+		if _, err := io.CopyN(&body, reader, size); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, false, fmt.Errorf("failed to read response: %w", err)
+		}
+		content = body.Bytes()
+	} else {
+		// the body ends when the server closes the connection
+		content, err = io.ReadAll(io.LimitReader(reader, MAX_BODY_SIZE+1))
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read response: %w", err)
+		}
+		if int64(len(content)) > MAX_BODY_SIZE {
+			return nil, false, ErrBodyTooLarge
+		}
+		keep_alive = false
 	}
 
-	content, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read response: %s", err.Error())
+	if encoding, ok := responseHeaders["content-encoding"]; ok {
+		content, err = DecodeContent(encoding, content)
+		if err != nil {
+			return nil, false, err
+		}
 	}
-	return responseHeaders, content, nil
+
+	return &Response{URL: u, Status: status, Reason: reason, Headers: responseHeaders, Body: content}, keep_alive, nil
 }
 
 func (u *URL) String() string {
-	port_part := ":" + strconv.Itoa(u.port)
-	if u.scheme == "https" && u.port == 443 {
-		port_part = ""
-	}
-	if u.scheme == "http" && u.port == 80 {
-		port_part = ""
-	}
-	return u.scheme + "://" + u.host + port_part + u.path
+	return u.record().serialize(false)
 }
 
+// Resolve parses link_url relative to u.
 func (u *URL) Resolve(link_url string) (*URL, error) {
-	if strings.Contains(link_url, "://") {
-		return NewURL(link_url)
-	}
-	if !strings.HasPrefix(link_url, "/") {
-		if i := strings.LastIndex(u.path, "/"); i != -1 {
-			dir := u.path[:i]
-			for strings.HasPrefix(link_url, "../") {
-				split := strings.SplitN(link_url, "/", 2)
-				link_url = split[1]
-				if strings.Contains(dir, "/") {
-					if i := strings.LastIndex(u.path, "/"); i != -1 {
-						dir = dir[:i]
-					}
-				}
-			}
-			link_url = dir + "/" + link_url
-		}
+	return new_url(link_url, u)
+}
+
+// Fragment returns the part after the '#', without it.
+func (u *URL) Fragment() string {
+	return u.fragment
+}
+
+func (u *URL) HasFragment() bool {
+	return u.has_fragment
+}
+
+// WithoutFragment returns a copy of u without its fragment, which is
+// what gets requested and cached.
+func (u *URL) WithoutFragment() *URL {
+	url := *u
+	url.fragment, url.has_fragment = "", false
+	return &url
+}
+
+// WithQuery returns a copy of u with its query replaced, the way a form
+// submitted with GET does.
+func (u *URL) WithQuery(query string) *URL {
+	url := *u
+	url.query, url.has_query = query, true
+	return &url
+}
+
+// request_target is the path and query sent in the request line.
+func (u *URL) request_target() string {
+	if u.has_query {
+		return u.path + "?" + u.query
 	}
-	if strings.HasPrefix(link_url, "//") {
-		return NewURL(u.scheme + ":" + link_url)
-	} else {
-		return NewURL(u.scheme + "://" + u.host + ":" + strconv.Itoa(u.port) + link_url)
+	return u.path
+}
+
+// host_port is the host with the port if that is not the default one,
+// as sent in the Host header.
+func (u *URL) host_port() string {
+	if u.port == SPECIAL_SCHEMES[u.scheme] {
+		return u.host
 	}
+	return u.host + ":" + strconv.Itoa(u.port)
 }
 
+// Each local file is an origin of its own, so that a page can't script or
+// read other files through frames or XHR.
+// Data and view-source URLs get an opaque origin, serialized as "null".
 func (u *URL) Origin() string {
+	if u.scheme == "file" {
+		return u.scheme + "://" + u.host + u.path
+	} else if u.HasOpaqueOrigin() {
+		return "null"
+	}
 	return u.scheme + "://" + u.host + ":" + strconv.Itoa(u.port)
 }
 
+// HasOpaqueOrigin is true for URLs whose origin equals no other, not even
+// their own, although it is serialized as "null".
+func (u *URL) HasOpaqueOrigin() bool {
+	return u.scheme == "data" || u.scheme == "view-source"
+}
+
+func (u *URL) Scheme() string {
+	return u.scheme
+}
+
+// ViewSource returns the URL a view-source URL shows the source of, and
+// nil for other URLs.
+func (u *URL) ViewSource() *URL {
+	return u.source
+}
+
 func GetOrDefault(m map[string]string, param, def string) string {
 	if val, ok := m[param]; ok {
 		return val
@@ -1,7 +1,19 @@
 package url
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestScheme(t *testing.T) {
@@ -100,8 +112,437 @@ func TestURLWithTrailingSlash(t *testing.T) {
 
 func TestRequest(t *testing.T) {
 	u, _ := NewURL("http://example.com/path")
-	_, response, _ := u.Request(u, "")
-	if string(response) == "" {
+	response, err := u.Request(u, nil)
+	if err != nil || string(response.Body) == "" {
 		t.Error("Expected non-empty response from Request()")
 	}
 }
+
+func TestFileURL(t *testing.T) {
+	u, err := NewURL("file:///tmp/page.html")
+	if err != nil {
+		t.Fatalf("Unexpected error for file URL: %s", err)
+	}
+	if u.scheme != "file" || u.host != "" || u.path != "/tmp/page.html" {
+		t.Errorf("Expected file URL with path '/tmp/page.html', got %q %q %q", u.scheme, u.host, u.path)
+	}
+	if u.String() != "file:///tmp/page.html" {
+		t.Errorf("Expected 'file:///tmp/page.html', got '%s'", u.String())
+	}
+	if u.Origin() != "file:///tmp/page.html" {
+		t.Errorf("Expected origin 'file:///tmp/page.html', got '%s'", u.Origin())
+	}
+	other, _ := u.Resolve("other.html")
+	if other.Origin() == u.Origin() {
+		t.Errorf("Expected other files to be cross-origin, both are '%s'", u.Origin())
+	}
+	same, _ := u.Resolve("page.html?q#f")
+	if same.Origin() != u.Origin() {
+		t.Errorf("Expected the same file to be same-origin, got '%s'", same.Origin())
+	}
+}
+
+func TestFileResolve(t *testing.T) {
+	u, _ := NewURL("file:///tmp/pages/index.html")
+	tests := map[string]string{
+		"style.css":       "file:///tmp/pages/style.css",
+		"/etc/hosts":      "file:///etc/hosts",
+		"http://a.com/x":  "http://a.com/x",
+		"sub/script.js":   "file:///tmp/pages/sub/script.js",
+		"file:///a/b.txt": "file:///a/b.txt",
+	}
+	for link, want := range tests {
+		resolved, err := u.Resolve(link)
+		if err != nil {
+			t.Errorf("Resolve(%q) failed: %s", link, err)
+		} else if resolved.String() != want {
+			t.Errorf("Resolve(%q) = '%s', want '%s'", link, resolved.String(), want)
+		}
+	}
+}
+
+func TestFileRequest(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "page.html"), []byte("<p>hello</p>"), 0644)
+	os.Mkdir(filepath.Join(dir, "sub dir"), 0755)
+
+	u, _ := NewURL("file://" + filepath.ToSlash(filepath.Join(dir, "page.html")))
+	response, err := u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if string(response.Body) != "<p>hello</p>" {
+		t.Errorf("Expected file contents, got '%s'", response.Body)
+	}
+	if !strings.HasPrefix(response.Headers["content-type"], "text/html") {
+		t.Errorf("Expected text/html content type, got '%s'", response.Headers["content-type"])
+	}
+
+	u, _ = NewURL("file://" + filepath.ToSlash(dir))
+	response, err = u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Directory request failed: %s", err)
+	}
+	listing := string(response.Body)
+	if !strings.Contains(listing, "page.html") || !strings.Contains(listing, "sub%20dir") {
+		t.Errorf("Expected directory listing with entries, got '%s'", listing)
+	}
+
+	u, _ = NewURL("file://" + filepath.ToSlash(filepath.Join(dir, "missing.html")))
+	if _, err := u.Request(nil, nil); err == nil {
+		t.Error("Expected error for missing file, but did not error")
+	}
+}
+
+func TestDataURL(t *testing.T) {
+	tests := []struct {
+		input       string
+		contentType string
+		body        string
+	}{
+		{"data:text/html,<p>hi</p>", "text/html", "<p>hi</p>"},
+		{"data:,Hello%2C%20World", "text/plain; charset=us-ascii", "Hello, World"},
+		{"data:text/plain;charset=UTF-8,caf%C3%A9", "text/plain; charset=utf-8", "café"},
+		{"data:text/plain;base64,SGVsbG8=", "text/plain", "Hello"},
+		{"data:text/plain;base64,SGVs bG8", "text/plain", "Hello"},
+		{"DATA:text/css;charset=utf-8;base64,cCB7IH0=", "text/css; charset=utf-8", "p { }"},
+		{"data:text/plain,100%zz", "text/plain", "100%zz"},
+	}
+	for _, tt := range tests {
+		u, err := NewURL(tt.input)
+		if err != nil {
+			t.Errorf("NewURL(%q) failed: %s", tt.input, err)
+			continue
+		}
+		response, err := u.Request(nil, nil)
+		if err != nil {
+			t.Errorf("Request(%q) failed: %s", tt.input, err)
+			continue
+		}
+		if response.Headers["content-type"] != tt.contentType {
+			t.Errorf("%q: expected content type '%s', got '%s'", tt.input, tt.contentType, response.Headers["content-type"])
+		}
+		if string(response.Body) != tt.body {
+			t.Errorf("%q: expected body '%s', got '%s'", tt.input, tt.body, response.Body)
+		}
+	}
+}
+
+func TestInvalidDataURL(t *testing.T) {
+	if _, err := NewURL("data:text/plain"); err == nil {
+		t.Error("Expected error for data URL without comma, but did not error")
+	}
+	u, _ := NewURL("data:image/png;base64,!!!")
+	if _, err := u.Request(nil, nil); err == nil {
+		t.Error("Expected error for invalid base64, but did not error")
+	}
+}
+
+func TestDataURLResolve(t *testing.T) {
+	base, _ := NewURL("http://example.com/index.html")
+	u, err := base.Resolve("data:image/png;base64,AAAA")
+	if err != nil || u.Scheme() != "data" {
+		t.Errorf("Expected data URL from Resolve, got %v, %v", u, err)
+	}
+	if u.Origin() != "null" || !u.HasOpaqueOrigin() {
+		t.Errorf("Expected opaque origin for data URL, got '%s'", u.Origin())
+	}
+	if _, err := u.Resolve("image.png"); err == nil {
+		t.Error("Expected error resolving relative URL against data URL, but did not error")
+	}
+	page, _ := NewURL("data:text/html,<a href=\"http://example.com/\">x</a>")
+	if page.String() != "data:text/html,<a href=\"http://example.com/\">x</a>" {
+		t.Errorf("Expected data URL to serialize unchanged, got '%s'", page.String())
+	}
+}
+
+func TestViewSourceURL(t *testing.T) {
+	u, err := NewURL("view-source:http://example.com/a?b=c#d")
+	if err != nil {
+		t.Fatalf("Failed to parse view-source URL: %s", err)
+	}
+	if u.Scheme() != "view-source" || u.ViewSource() == nil || u.ViewSource().String() != "http://example.com/a?b=c" {
+		t.Errorf("Expected source http://example.com/a?b=c, got %v", u.ViewSource())
+	}
+	if u.String() != "view-source:http://example.com/a?b=c#d" || u.Origin() != "null" {
+		t.Errorf("Unexpected view-source URL %s with origin %s", u, u.Origin())
+	}
+	if _, err := u.Request(nil, nil); err == nil {
+		t.Error("Expected error requesting a view-source URL, but did not error")
+	}
+	for _, invalid := range []string{"view-source:", "view-source:view-source:http://example.com/", "view-source:gopher://x"} {
+		if _, err := NewURL(invalid); err == nil {
+			t.Errorf("Expected error for %s, but did not error", invalid)
+		}
+	}
+	plain, _ := NewURL("http://example.com/")
+	if plain.ViewSource() != nil {
+		t.Error("Expected no source for an http URL")
+	}
+}
+
+func TestWithQuery(t *testing.T) {
+	u, _ := NewURL("http://example.com/search?old=1#top")
+	if got := u.WithQuery("q=a+b").String(); got != "http://example.com/search?q=a+b#top" {
+		t.Errorf("Expected the query to be replaced, got %s", got)
+	}
+	if u.String() != "http://example.com/search?old=1#top" {
+		t.Errorf("Expected the URL to be left alone, got %s", u)
+	}
+}
+
+func TestPayloadContentType(t *testing.T) {
+	base := startTestServer(t, func(req testRequest) string {
+		return "HTTP/1.1 200 OK\r\n\r\n" + req.headers["content-type"] + " " + req.body
+	})
+	u, _ := NewURL(base + "/submit")
+	payload := &Payload{Method: "POST", ContentType: "multipart/form-data; boundary=abc", Body: "a=1\r\n"}
+	response, err := u.Request(nil, payload)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if want := "multipart/form-data; boundary=abc a=1\r\n"; string(response.Body) != want {
+		t.Errorf("Expected %q, got %q", want, response.Body)
+	}
+}
+
+type testRequest struct {
+	method, path string
+	headers      map[string]string
+	body         string
+}
+
+// startTestServer serves raw HTTP responses produced by handler on a local port.
+func startTestServer(t *testing.T, handler func(req testRequest) string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				reqline, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				split := strings.SplitN(reqline, " ", 3)
+				req := testRequest{method: split[0], path: split[1], headers: map[string]string{}}
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == "\r\n" {
+						break
+					}
+					header, value, _ := strings.Cut(line, ":")
+					req.headers[strings.ToLower(header)] = strings.TrimSpace(value)
+				}
+				if length, err := strconv.Atoi(req.headers["content-length"]); err == nil {
+					buf := make([]byte, length)
+					io.ReadFull(reader, buf)
+					req.body = string(buf)
+				}
+				conn.Write([]byte(handler(req)))
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String()
+}
+
+func TestRedirect(t *testing.T) {
+	base := startTestServer(t, func(req testRequest) string {
+		switch req.path {
+		case "/old":
+			return "HTTP/1.1 301 Moved Permanently\r\nLocation: /new\r\n\r\n"
+		case "/new":
+			return "HTTP/1.1 302 Found\r\nLocation: /final\r\n\r\n"
+		case "/final":
+			return "HTTP/1.1 200 OK\r\n\r\n" + req.method + " final"
+		case "/submit":
+			return "HTTP/1.1 303 See Other\r\nLocation: /final\r\n\r\n"
+		case "/keep":
+			return "HTTP/1.1 307 Temporary Redirect\r\nLocation: /echo\r\n\r\n"
+		case "/echo":
+			return "HTTP/1.1 200 OK\r\n\r\n" + req.method + " " + req.body
+		}
+		return "HTTP/1.1 404 Not Found\r\n\r\n"
+	})
+
+	u, _ := NewURL(base + "/old")
+	response, err := u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if response.URL.String() != base+"/final" {
+		t.Errorf("Expected final URL '%s', got '%s'", base+"/final", response.URL)
+	}
+	if response.Status != 200 || string(response.Body) != "GET final" {
+		t.Errorf("Expected 200 'GET final', got %d '%s'", response.Status, response.Body)
+	}
+
+	u, _ = NewURL(base + "/submit")
+	form := &Payload{Method: "POST", ContentType: "application/x-www-form-urlencoded", Body: "a=1"}
+	response, err = u.Request(nil, form)
+	if err != nil || string(response.Body) != "GET final" {
+		t.Errorf("Expected POST to become GET on 303, got %v '%s'", err, response.Body)
+	}
+
+	u, _ = NewURL(base + "/keep")
+	response, err = u.Request(nil, form)
+	if err != nil || string(response.Body) != "POST a=1" {
+		t.Errorf("Expected POST to be kept on 307, got %v '%s'", err, response.Body)
+	}
+
+	// the method doesn't depend on there being a body
+	u, _ = NewURL(base + "/echo")
+	response, err = u.Request(nil, &Payload{Method: "POST"})
+	if err != nil || string(response.Body) != "POST " {
+		t.Errorf("Expected an empty POST, got %v '%s'", err, response.Body)
+	}
+	response, err = u.Request(nil, &Payload{Method: "PUT", Body: "x"})
+	if err != nil || string(response.Body) != "PUT x" {
+		t.Errorf("Expected a PUT, got %v '%s'", err, response.Body)
+	}
+}
+
+func TestRedirectLoop(t *testing.T) {
+	base := startTestServer(t, func(req testRequest) string {
+		if req.path == "/a" {
+			return "HTTP/1.1 302 Found\r\nLocation: /b\r\n\r\n"
+		}
+		return "HTTP/1.1 302 Found\r\nLocation: /a\r\n\r\n"
+	})
+	u, _ := NewURL(base + "/a")
+	_, err := u.Request(nil, nil)
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("Expected ErrTooManyRedirects, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "loop") {
+		t.Errorf("Expected redirect loop to be reported, got '%s'", err)
+	}
+}
+
+func TestStatusReason(t *testing.T) {
+	base := startTestServer(t, func(req testRequest) string {
+		return "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"
+	})
+	u, _ := NewURL(base + "/missing")
+	response, err := u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if response.Status != 404 || response.Reason != "Not Found" {
+		t.Errorf("Expected '404 Not Found', got '%d %s'", response.Status, response.Reason)
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	base := startTestServer(t, func(req testRequest) string {
+		switch req.path {
+		case "/huge":
+			return "HTTP/1.1 200 OK\r\nContent-Length: 9000000000000000000\r\n\r\nabc"
+		case "/overflow":
+			return "HTTP/1.1 200 OK\r\nContent-Length: 99999999999999999999\r\n\r\nabc"
+		case "/short":
+			return "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\nabc"
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabcdef"
+	})
+
+	u, _ := NewURL(base + "/huge")
+	if _, err := u.Request(nil, nil); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Expected ErrBodyTooLarge, got %v", err)
+	}
+	for _, path := range []string{"/overflow", "/short"} {
+		u, _ = NewURL(base + path)
+		if _, err := u.Request(nil, nil); err == nil {
+			t.Errorf("Expected %s to fail", path)
+		}
+	}
+	u, _ = NewURL(base + "/exact")
+	if response, err := u.Request(nil, nil); err != nil || string(response.Body) != "abc" {
+		t.Errorf("Expected the body to end after Content-Length, got %v", err)
+	}
+}
+
+func TestMalformedHeader(t *testing.T) {
+	base := startTestServer(t, func(req testRequest) string {
+		return "HTTP/1.1 200 OK\r\nno colon here\r\nX-Test: a:b\r\nContent-Length: 2\r\n\r\nok"
+	})
+	u, _ := NewURL(base + "/")
+	response, err := u.Request(nil, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if response.Headers["x-test"] != "a:b" || string(response.Body) != "ok" {
+		t.Errorf("Expected the malformed line to be skipped, got %v %q", response.Headers, response.Body)
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	u, _ := NewURL("http://" + address + "/")
+	_, err = u.Request(nil, nil)
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Expected connection refused, got %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		// accept, but never answer
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	defer func(timeout time.Duration) { REQUEST_TIMEOUT = timeout }(REQUEST_TIMEOUT)
+	REQUEST_TIMEOUT = 50 * time.Millisecond
+
+	u, _ := NewURL("http://" + listener.Addr().String() + "/")
+	_, err = u.Request(nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+}
+
+func TestRedirectCheck(t *testing.T) {
+	base := startTestServer(t, func(req testRequest) string {
+		if req.path == "/old" {
+			return "HTTP/1.1 302 Found\r\nLocation: /new\r\n\r\n"
+		}
+		return "HTTP/1.1 200 OK\r\n\r\nnew"
+	})
+	blocked := errors.New("blocked")
+	checked := []string{}
+	ctx := WithRedirectCheck(context.Background(), func(url *URL) error {
+		checked = append(checked, url.String())
+		return blocked
+	})
+	u, _ := NewURL(base + "/old")
+	if _, err := u.RequestContext(ctx, nil, nil); !errors.Is(err, blocked) {
+		t.Errorf("Expected the redirect to be blocked, got %v", err)
+	}
+	if !slices.Equal(checked, []string{base + "/new"}) {
+		t.Errorf("Expected the redirect target to be checked, got %v", checked)
+	}
+}